@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BudgetExceededError 在执行超过 step 上限、超时或者被取消的时候返回，
+// host 可以用 errors.As 把它跟普通的运行时错误区分开。
+type BudgetExceededError struct {
+	Reason string
+	// 超时或者被取消的时候是 ctx.Err()，超过 step 上限的时候为 nil。
+	Err error
+}
+
+func (e *BudgetExceededError) Error() string {
+	return "execution budget exceeded: " + e.Reason
+}
+
+func (e *BudgetExceededError) Unwrap() error {
+	return e.Err
+}
+
+// executionBudget 限制一次 interpret 能执行多少步、执行多长时间，同一个 interpreter fork 出来的 interpreter 共享同一个 budget。
+// 循环的每一次迭代和每一次调用算作一步。
+type executionBudget struct {
+	maxSteps int64         // 为 0 的时候不限制
+	timeout  time.Duration // 为 0 的时候不限制
+	// fork 出来的 budget 跟原来的 budget 共享同一个计数。
+	steps *int64
+
+	// ctx 在 start 的时候被替换，而 task 可能同时在其它 goroutine 中读取它。
+	mu  sync.RWMutex
+	ctx context.Context
+}
+
+func newExecutionBudget() *executionBudget {
+	return &executionBudget{steps: new(int64), ctx: context.Background()}
+}
+
+// start 开始新的一次执行，返回的 cancel 要在执行结束之后调用。
+// 没有 timeout 的时候 ctx 也是可以取消的，执行结束的时候阻塞在 channel 上的 task 和没有被 join 的 task 会跟着停下来。
+// cancel 之后 b 换回不会结束的 ctx，host 在执行结束之后仍然可以通过 Call 调用 lox 的函数。
+func (b *executionBudget) start(ctx context.Context) context.CancelFunc {
+	var cancel context.CancelFunc
+	if b.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	b.mu.Lock()
+	b.ctx = ctx
+	b.mu.Unlock()
+	atomic.StoreInt64(b.steps, 0)
+	return func() {
+		cancel()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.ctx == ctx {
+			b.ctx = context.Background()
+		}
+	}
+}
+
+// fork 返回跟 b 共享 step 上限和计数的 budget，它的 ctx 固定为 fork 时这一次执行的 ctx。
+// 执行结束之后 b 的 ctx 被换掉，fork 出来的 task 仍然会看到原来的 ctx 被取消而停下来。
+func (b *executionBudget) fork() *executionBudget {
+	return &executionBudget{
+		maxSteps: b.maxSteps,
+		timeout:  b.timeout,
+		steps:    b.steps,
+		ctx:      b.context(),
+	}
+}
+
+func (b *executionBudget) context() context.Context {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.ctx
+}
+
+// done 在超时或者被取消的时候关闭，阻塞的操作同时等待它，这样 host 可以停下阻塞住的脚本。
+func (b *executionBudget) done() <-chan struct{} {
+	return b.context().Done()
+}
+
+// step 记录执行了一步，超过 budget 的时候返回 BudgetExceededError。
+func (b *executionBudget) step() error {
+	if b.maxSteps > 0 && atomic.AddInt64(b.steps, 1) > b.maxSteps {
+		return &BudgetExceededError{Reason: fmt.Sprintf("more than %d steps", b.maxSteps)}
+	}
+	select {
+	case <-b.done():
+		return b.exceeded()
+	default:
+		return nil
+	}
+}
+
+func (b *executionBudget) exceeded() error {
+	err := b.context().Err()
+	reason := "cancelled"
+	if err == context.DeadlineExceeded {
+		reason = "deadline exceeded"
+	}
+	return &BudgetExceededError{Reason: reason, Err: err}
+}
+
+// sleep 用 clock 等待 d，等待的过程中超时或者被取消的时候提前返回。
+func (b *executionBudget) sleep(clock Clock, d time.Duration) error {
+	if err := clock.Sleep(b.context(), d); err != nil {
+		return b.exceeded()
+	}
+	return nil
+}
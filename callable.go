@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type Interpreter interface {
 	EvalVisitor
@@ -8,12 +11,138 @@ type Interpreter interface {
 
 	GetGlobalEnv() *Env
 	ExecuteBlock(stmts []Stmt, env *Env) error
+	Evaluate(expr Expr, env *Env) (interface{}, error)
 	Resolve(expr Expr, distance int) error
+	// Call 调用 callee，跟 lox 代码中的调用一样检查调用深度和 budget，native function 和 getter 之类的隐式调用都要通过它。
+	Call(callee Callable, args []interface{}) (interface{}, error)
+	// Fork 返回一个共享 globals 和 locals、但是有自己的 env 的 interpreter，用来在另一个 goroutine 中执行代码。
+	Fork() Interpreter
+	EventLoop() *eventLoop
+	Budget() *executionBudget
+	Memory() *memoryAccount
+	HasCapability(c capability) bool
+	// Stringify 返回值在 lox 中的字符串形式，会调用 instance 的 toString。
+	Stringify(value interface{}) (string, error)
 }
 
 type Callable interface {
-	Arity() int
+	Arity() Arity
 	Call(intp Interpreter, args []interface{}) (interface{}, error)
 
 	fmt.Stringer
 }
+
+// propertyGetter 是内置类型通过 `.` 访问属性的方式，比如 list 的 push 和 class 的 static member。
+type propertyGetter interface {
+	Get(name token) (interface{}, error)
+}
+
+// parameterNamer 是支持具名参数的 callable，返回的名字跟参数的位置一一对应（不包括 rest 参数）。
+type parameterNamer interface {
+	ParamNames() []string
+}
+
+const variadic = -1
+
+// Arity 是 callable 能接受的参数个数范围，Max 为 variadic 表示不限个数。
+type Arity struct {
+	Min int
+	Max int
+}
+
+func fixedArity(n int) Arity {
+	return Arity{Min: n, Max: n}
+}
+
+func (a Arity) Accepts(n int) bool {
+	return n >= a.Min && (a.Max == variadic || n <= a.Max)
+}
+
+// Covers 判断 a 是否能接受所有 other 能接受的参数个数。
+func (a Arity) Covers(other Arity) bool {
+	if a.Min > other.Min {
+		return false
+	}
+	if a.Max == variadic {
+		return true
+	}
+	return other.Max != variadic && a.Max >= other.Max
+}
+
+func (a Arity) String() string {
+	if a.Max == variadic {
+		return fmt.Sprintf("at least %d", a.Min)
+	}
+	if a.Min == a.Max {
+		return fmt.Sprintf("%d", a.Min)
+	}
+	return fmt.Sprintf("%d to %d", a.Min, a.Max)
+}
+
+// missingArgument 标记具名参数调用时中间没有传值的位置，由 callee 用默认值填充。
+type missingArgument struct{}
+
+var argNotProvided = missingArgument{}
+
+type namedArgument struct {
+	name  token
+	value interface{}
+}
+
+// bindArguments 把位置参数和具名参数合并成 callee 需要的位置参数列表，并检查参数个数。
+func bindArguments(callee Callable, args []interface{}, namedArgs []namedArgument) ([]interface{}, error) {
+	var names []string
+	if namer, ok := callee.(parameterNamer); ok {
+		names = namer.ParamNames()
+	}
+	if len(namedArgs) > 0 {
+		if names == nil {
+			return nil, newRuntimeError(ArityError, token{}, "callable: %s does not accept named arguments", callee).withValues(callee)
+		}
+		for _, namedArg := range namedArgs {
+			idx := indexOf(names, namedArg.name.Lexeme)
+			if idx < 0 {
+				return nil, newRuntimeError(ArityError, namedArg.name, "callable: %s, unexpected named argument '%s'", callee, namedArg.name.Lexeme).withValues(callee)
+			}
+			if idx < len(args) {
+				if args[idx] != argNotProvided {
+					return nil, newRuntimeError(ArityError, namedArg.name, "callable: %s, got multiple values for parameter '%s'", callee, namedArg.name.Lexeme).withValues(callee)
+				}
+			} else {
+				for len(args) <= idx {
+					args = append(args, argNotProvided)
+				}
+			}
+			args[idx] = namedArg.value
+		}
+	}
+
+	arity := callee.Arity()
+	if arity.Max != variadic && len(args) > arity.Max {
+		return nil, newRuntimeError(ArityError, token{}, "callable: %s, expected %s arguments but got %d", callee, arity, len(args)).withValues(callee)
+	}
+	var missing []string
+	for idx := 0; idx < arity.Min; idx++ {
+		if idx < len(args) && args[idx] != argNotProvided {
+			continue
+		}
+		if idx < len(names) {
+			missing = append(missing, "'"+names[idx]+"'")
+		} else {
+			missing = append(missing, fmt.Sprintf("#%d", idx+1))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, newRuntimeError(ArityError, token{}, "callable: %s, missing arguments for parameters %s", callee, strings.Join(missing, ", ")).withValues(callee)
+	}
+	return args, nil
+}
+
+func indexOf(names []string, name string) int {
+	for idx, v := range names {
+		if v == name {
+			return idx
+		}
+	}
+	return -1
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// capability 是一组需要授权才能调用的 native function，比如读文件、读环境变量。
+type capability string
+
+const (
+	capabilityFSRead       capability = "fs-read"
+	capabilityFSWrite      capability = "fs-write"
+	capabilityEnv          capability = "env"
+	capabilityClock        capability = "clock"
+	capabilityProcess      capability = "process"
+	capabilityNetLoopback  capability = "net-loopback" // 只能访问本机的网络，还没有 native 声明它
+	allCapabilitiesKeyword            = "all"
+)
+
+var allCapabilities = []capability{
+	capabilityFSRead,
+	capabilityFSWrite,
+	capabilityEnv,
+	capabilityClock,
+	capabilityProcess,
+	capabilityNetLoopback,
+}
+
+// CapabilityError 在调用没有被授权的 native function 的时候返回。
+type CapabilityError struct {
+	Native     string
+	Capability capability
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("native function %s requires capability %q, which is not granted", e.Native, e.Capability)
+}
+
+type capabilitySet map[capability]bool
+
+func newCapabilitySet(caps ...capability) capabilitySet {
+	set := make(capabilitySet, len(caps))
+	for _, c := range caps {
+		set[c] = true
+	}
+	return set
+}
+
+// parseCapabilities 解析逗号分隔的 capability 列表，"all" 表示所有的 capability，空字符串表示没有。
+func parseCapabilities(s string) ([]capability, error) {
+	var caps []capability
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+			continue
+		case allCapabilitiesKeyword:
+			caps = append(caps, allCapabilities...)
+			continue
+		}
+		known := false
+		for _, c := range allCapabilities {
+			if string(c) == name {
+				known = true
+				caps = append(caps, c)
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+	}
+	return caps, nil
+}
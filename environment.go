@@ -1,12 +1,19 @@
 package main
 
 import (
-	"fmt"
+	"sync"
 )
 
+// Env 可能被多个 task 同时访问，比如 closure 捕获的 env 和 globals，所以读写 data 都要加锁。
+// enclosing 在创建之后不会再修改，不需要加锁。
 type Env struct {
 	enclosing *Env
+	mu        sync.RWMutex
 	data      map[string]interface{}
+
+	// 内存记账用：记在这个 env 上的字节数，以及有没有被 closure 捕获，被捕获的 env 不会在 block 结束的时候释放。
+	charged  int64
+	captured bool
 }
 
 func newEnv() *Env {
@@ -23,25 +30,59 @@ func newEnvWithEnclosing(enclosingEnv *Env) *Env {
 	return env
 }
 
+// capture 标记 env 和它外层的 env 被 closure 捕获了。
+func (env *Env) capture() {
+	for e := env; e != nil; e = e.enclosing {
+		e.mu.Lock()
+		captured := e.captured
+		e.captured = true
+		e.mu.Unlock()
+		// 外层的 env 在第一次捕获的时候已经标记过了
+		if captured {
+			return
+		}
+	}
+}
+
 func (env *Env) Define(varName string, value interface{}) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.data[varName] = value
+}
+
+func (env *Env) lookup(varName string) (interface{}, bool) {
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+	v, ok := env.data[varName]
+	return v, ok
+}
+
+// update 只在 varName 已经定义的时候赋值，返回是否赋值成功。
+func (env *Env) update(varName string, value interface{}) bool {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if _, ok := env.data[varName]; !ok {
+		return false
+	}
 	env.data[varName] = value
+	return true
 }
 
 func (env *Env) Get(name token) (interface{}, error) {
-	if v, ok := env.data[name.Lexeme]; ok {
+	if v, ok := env.lookup(name.Lexeme); ok {
 		return v, nil
 	}
 	if env.enclosing != nil {
 		return env.enclosing.Get(name)
 	}
-	return nil, fmt.Errorf("undefined variable %s when getting", name.Lexeme)
+	return nil, newRuntimeError(NameError, name, "undefined variable %s", name.Lexeme)
 }
 
 func (env *Env) Ancestor(distance int) (*Env, error) {
 	destination := env
 	for i := 0; i < distance; i++ {
 		if destination.enclosing == nil {
-			return destination, fmt.Errorf("nil enclosing")
+			return destination, newRuntimeError(InternalError, token{}, "no enclosing environment at distance %d", distance)
 		}
 		destination = destination.enclosing
 	}
@@ -53,22 +94,21 @@ func (env *Env) GetAtByVarName(distance int, varName string) (interface{}, error
 	if err != nil {
 		return nil, err
 	}
-	v, ok := destinationEnv.data[varName]
+	v, ok := destinationEnv.lookup(varName)
 	if ok {
 		return v, nil
 	}
-	return nil, fmt.Errorf("unexpected get, var name %s not found in destination env, maybe something wrong?", varName)
+	return nil, newRuntimeError(InternalError, token{}, "resolved variable %s not found in its environment", varName)
 }
 
 func (env *Env) Assign(name token, value interface{}) error {
-	if _, ok := env.data[name.Lexeme]; ok {
-		env.data[name.Lexeme] = value
+	if env.update(name.Lexeme, value) {
 		return nil
 	}
 	if env.enclosing != nil {
 		return env.enclosing.Assign(name, value)
 	}
-	return fmt.Errorf("undefined variable %s when assiging", name.Lexeme)
+	return newRuntimeError(NameError, name, "assign to undefined variable %s", name.Lexeme)
 }
 
 func (env *Env) AssignAt(distance int, name token, value interface{}) error {
@@ -76,10 +116,8 @@ func (env *Env) AssignAt(distance int, name token, value interface{}) error {
 	if err != nil {
 		return err
 	}
-	_, ok := destinationEnv.data[name.Lexeme]
-	if !ok {
-		return fmt.Errorf("unexpected assign, name %s not found in destination env, maybe something wrong?", name)
+	if !destinationEnv.update(name.Lexeme, value) {
+		return newRuntimeError(InternalError, name, "resolved variable %s not found in its environment", name.Lexeme)
 	}
-	destinationEnv.data[name.Lexeme] = value
 	return nil
 }
@@ -3,15 +3,38 @@ package main
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var (
 	errCastToMapString2Bool  = errors.New("interface{} is not a map[string]bool")
 	errCastStmt2FunctionStmt = errors.New("stmt is not a function stmt")
+
+	// `?.` 遇到 nil 的时候用来跳出整条 chain，由 OptionalChainExpr 接住。
+	errOptionalChainShortCircuit = errors.New("optional chain short circuit")
+
+	// break 和 continue 跟 Return 一样通过 error 传递到最近的循环。
+	errBreak    = errors.New("break outside of a loop")
+	errContinue = errors.New("continue outside of a loop")
 )
 
 type Return struct {
 	Value interface{}
+	// `return f(args)` 中的 f 是 Lox function 的时候不在这里调用，交给调用当前函数的 Call 循环执行。
+	tailCall *tailCall
+}
+
+type tailCall struct {
+	function *LoxFunction
+	args     []interface{}
+}
+
+// result 返回 return 的值，有尾调用的时候先执行尾调用。
+func (r Return) result(intp Interpreter) (interface{}, error) {
+	if r.tailCall == nil {
+		return r.Value, nil
+	}
+	return intp.Call(r.tailCall.function, r.tailCall.args)
 }
 
 func NewReturn(v interface{}) Return {
@@ -23,3 +46,132 @@ func NewReturn(v interface{}) Return {
 func (r Return) Error() string {
 	return fmt.Sprintf("return value is: %v", r.Value)
 }
+
+// ExitError 由 exit(code) 返回，一路返回到 interpret，host 用 Code 作为进程的退出码。
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit with code %d", e.Code)
+}
+
+// EventLoopError 是顶层代码执行完之后 event loop 中的错误，比如没有处理的 rejection。
+// interpret 不输出它，由 host 跟其它错误一起报告。
+type EventLoopError struct {
+	Err error
+}
+
+func (e *EventLoopError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *EventLoopError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKind 是 RuntimeError 的种类，host 可以用 errors.Is(err, TypeError) 判断错误的种类。
+type ErrorKind string
+
+const (
+	TypeError     ErrorKind = "TypeError"          // 值的类型不支持这个操作，比如 nil + 1、调用一个 number
+	NameError     ErrorKind = "NameError"          // 变量没有定义
+	ArityError    ErrorKind = "ArityError"         // 参数的个数不对，或者具名参数不对
+	PropertyError ErrorKind = "PropertyError"      // 属性不存在，或者不能访问、不能赋值
+	IndexError    ErrorKind = "IndexError"         // list 的下标越界，或者 map 中没有这个 key
+	ZeroDivision  ErrorKind = "ZeroDivisionError"  // 除以 0
+	MatchError    ErrorKind = "MatchError"         // match 中没有 case 匹配
+	IOError       ErrorKind = "IOError"            // 读写文件失败，或者路径不在允许访问的目录下
+	ValueError    ErrorKind = "ValueError"         // 参数的类型正确，但是值不合法，比如超出范围、不能解析
+	StateError    ErrorKind = "StateError"         // 对象当前的状态不允许这个操作，比如 send 到已经关闭的 channel
+	InternalError ErrorKind = "InternalError"      // interpreter 自身的错误，正常情况下不会出现
+	StackOverflow ErrorKind = "StackOverflowError" // 调用的深度超过限制
+)
+
+func (k ErrorKind) Error() string {
+	return string(k)
+}
+
+// RuntimeError 是执行 lox 代码时的错误，取代了 fmt.Errorf 拼出来的字符串，host 可以按 Kind 和位置处理错误。
+type RuntimeError struct {
+	Kind ErrorKind
+	// 出错的 token，line 为 0 的时候不知道出错的位置。
+	Token token
+	// 出错时涉及的值，比如 nil + 1 中的 nil 和 1。
+	Values []interface{}
+	Msg    string
+	// 引起这个错误的 go 的错误，比如读文件失败的原因，可以为 nil。
+	cause error
+}
+
+func newRuntimeError(kind ErrorKind, tok token, format string, args ...interface{}) *RuntimeError {
+	return &RuntimeError{Kind: kind, Token: tok, Msg: fmt.Sprintf(format, args...)}
+}
+
+// newStackOverflowError 在调用的深度超过限制的时候返回，而不是让 go 的调用栈耗尽导致整个进程崩溃。
+// Values 是 limit 和最近的几层调用 frames，最近的一层在最前面。
+func newStackOverflowError(limit int, frames []string) *RuntimeError {
+	var b strings.Builder
+	fmt.Fprintf(&b, "stack overflow: call depth exceeds limit %d", limit)
+	for _, frame := range frames {
+		b.WriteString("\n    at ")
+		b.WriteString(frame)
+	}
+	return newRuntimeError(StackOverflow, token{}, "%s", b.String()).withValues(limit, frames)
+}
+
+// withValues 记录出错时涉及的值。
+func (e *RuntimeError) withValues(values ...interface{}) *RuntimeError {
+	e.Values = values
+	return e
+}
+
+// withCause 记录引起这个错误的 go 的错误，host 可以用 errors.Is 继续判断它。
+func (e *RuntimeError) withCause(err error) *RuntimeError {
+	e.cause = err
+	return e
+}
+
+// Line 是出错的行号，不知道位置的时候是 0。
+func (e *RuntimeError) Line() int {
+	return e.Token.line
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Token.line == 0 {
+		return fmt.Sprintf("%s: %s", e.Kind, e.Msg)
+	}
+	return fmt.Sprintf("%s at line %d: %s", e.Kind, e.Token.line, e.Msg)
+}
+
+// Get 让 catch 到的错误在 lox 中可以读取 kind、message 和 line。
+func (e *RuntimeError) Get(name token) (interface{}, error) {
+	switch name.Lexeme {
+	case "kind":
+		return string(e.Kind), nil
+	case "message":
+		return e.Msg, nil
+	case "line":
+		return float64(e.Token.line), nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in error", name.Lexeme).withValues(e)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.cause
+}
+
+// Is 让 errors.Is(err, TypeError) 可以判断 err 的种类。
+func (e *RuntimeError) Is(target error) bool {
+	kind, ok := target.(ErrorKind)
+	return ok && kind == e.Kind
+}
+
+// atToken 给还不知道位置的 RuntimeError 补上位置，比如 list 的下标越界只有在 IndexExpr 中才知道位置。
+func atToken(err error, tok token) error {
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) && runtimeErr.Token.line == 0 {
+		runtimeErr.Token = tok
+	}
+	return err
+}
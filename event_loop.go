@@ -0,0 +1,264 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock 是 event loop 的时间来源，测试中用 fakeClock 让 timer 的执行顺序跟真实时间无关。
+type Clock interface {
+	Now() time.Time
+	// Sleep 一直等到 d 之后，fakeClock 直接把时间往后拨。ctx 结束的时候提前返回 ctx.Err()。
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock(start time.Time) *fakeClock {
+	return &fakeClock{now: start}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return nil
+}
+
+type timer struct {
+	id        int
+	when      time.Time
+	interval  time.Duration // 大于 0 的时候是 setInterval 创建的 timer，执行之后重新排队
+	callback  func() error
+	cancelled bool
+}
+
+// timerQueue 按照到期时间排序，时间相同的按照创建的顺序执行。
+type timerQueue []*timer
+
+func (q timerQueue) Len() int { return len(q) }
+
+func (q timerQueue) Less(i, j int) bool {
+	if q[i].when.Equal(q[j].when) {
+		return q[i].id < q[j].id
+	}
+	return q[i].when.Before(q[j].when)
+}
+
+func (q timerQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *timerQueue) Push(x interface{}) { *q = append(*q, x.(*timer)) }
+
+func (q *timerQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return t
+}
+
+// eventLoop 是单线程的：回调、timer 和 async function 的 body 都在执行 event loop 的 goroutine 中轮流执行。
+type eventLoop struct {
+	mu     sync.Mutex
+	clock  Clock
+	budget *executionBudget
+	tasks  []func() error // 已经可以执行的回调，比如 promise 完成之后恢复 await 的 coroutine
+	timers timerQueue
+	byID   map[int]*timer
+	nextID int
+	// 停在 await 上的 coroutine，event loop 结束的时候还没有恢复的会被取消。
+	suspended map[*coroutine]bool
+	// 被 reject 的 promise，event loop 结束的时候检查有没有没人处理的错误。
+	rejected []*LoxPromise
+	running  bool
+}
+
+func newEventLoop(clock Clock, budget *executionBudget) *eventLoop {
+	return &eventLoop{
+		clock:     clock,
+		budget:    budget,
+		byID:      map[int]*timer{},
+		suspended: map[*coroutine]bool{},
+	}
+}
+
+func (l *eventLoop) enqueue(task func() error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tasks = append(l.tasks, task)
+}
+
+// schedule 在 delay 之后执行 callback，interval 大于 0 的时候之后每隔 interval 执行一次，返回 timer 的 id。
+func (l *eventLoop) schedule(delay, interval time.Duration, callback func() error) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID++
+	t := &timer{
+		id:       l.nextID,
+		when:     l.clock.Now().Add(delay),
+		interval: interval,
+		callback: callback,
+	}
+	l.byID[t.id] = t
+	heap.Push(&l.timers, t)
+	return t.id
+}
+
+// cancel 取消还没有执行的 timer，id 不存在的时候什么也不做。
+func (l *eventLoop) cancel(id int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t, ok := l.byID[id]; ok {
+		t.cancelled = true
+		delete(l.byID, id)
+	}
+}
+
+// next 返回下一个要执行的回调，没有的话返回 false。
+// 只剩下 timer 的时候会等到最早的 timer 到期，等待的时候超过 budget 返回的回调直接返回错误。
+func (l *eventLoop) next() (func() error, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.tasks) > 0 {
+		task := l.tasks[0]
+		l.tasks = l.tasks[1:]
+		return task, true
+	}
+	for l.timers.Len() > 0 {
+		t := heap.Pop(&l.timers).(*timer)
+		if t.cancelled {
+			continue
+		}
+		if wait := t.when.Sub(l.clock.Now()); wait > 0 {
+			l.mu.Unlock()
+			err := l.budget.sleep(l.clock, wait)
+			l.mu.Lock()
+			if err != nil {
+				heap.Push(&l.timers, t)
+				return func() error { return err }, true
+			}
+			if t.cancelled {
+				continue
+			}
+		}
+		if t.interval > 0 {
+			t.when = t.when.Add(t.interval)
+			heap.Push(&l.timers, t)
+		} else {
+			delete(l.byID, t.id)
+		}
+		return t.callback, true
+	}
+	return nil, false
+}
+
+// run 执行所有的回调和 timer 直到 event loop 为空。
+func (l *eventLoop) run() error {
+	if err := l.runUntil(nil); err != nil {
+		return err
+	}
+	for _, p := range l.takeRejected() {
+		if !p.isHandled() {
+			return fmt.Errorf("unhandled error in %s: %w", p.name, p.err)
+		}
+	}
+	l.cancelSuspended()
+	return nil
+}
+
+// runUntil 执行 event loop 直到 done 返回 true 或者 event loop 为空，done 为 nil 的时候一直执行到为空。
+func (l *eventLoop) runUntil(done func() bool) error {
+	l.mu.Lock()
+	if l.running {
+		l.mu.Unlock()
+		return fmt.Errorf("event loop is already running")
+	}
+	l.running = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.running = false
+		l.mu.Unlock()
+	}()
+	for done == nil || !done() {
+		task, ok := l.next()
+		if !ok {
+			return nil
+		}
+		if err := l.budget.step(); err != nil {
+			return err
+		}
+		if err := task(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *eventLoop) suspend(c *coroutine) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.suspended[c] = true
+}
+
+func (l *eventLoop) wake(c *coroutine) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.suspended, c)
+}
+
+func (l *eventLoop) reject(p *LoxPromise) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rejected = append(l.rejected, p)
+}
+
+func (l *eventLoop) takeRejected() []*LoxPromise {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rejected := l.rejected
+	l.rejected = nil
+	return rejected
+}
+
+// cancelSuspended 让等待永远不会完成的 promise 的 coroutine 从 await 处返回，结束它们的 goroutine。
+func (l *eventLoop) cancelSuspended() {
+	l.mu.Lock()
+	suspended := l.suspended
+	l.suspended = map[*coroutine]bool{}
+	l.mu.Unlock()
+	for c := range suspended {
+		c.cancel()
+	}
+}
@@ -15,6 +15,13 @@ type Visitor interface {
 	visitSetExpr(expr *SetExpr) string
 	visitThisExpr(expr *ThisExpr) string
 	visitSuperExpr(expr *SuperExpr) string
+	visitConditionalExpr(expr *ConditionalExpr) string
+	visitOptionalChainExpr(expr *OptionalChainExpr) string
+	visitIndexExpr(expr *IndexExpr) string
+	visitListExpr(expr *ListExpr) string
+	visitMapExpr(expr *MapExpr) string
+	visitSpawnExpr(expr *SpawnExpr) string
+	visitAwaitExpr(expr *AwaitExpr) string
 }
 
 type EvalVisitor interface {
@@ -30,6 +37,13 @@ type EvalVisitor interface {
 	visitSetExpr(expr *SetExpr) (interface{}, error)
 	visitThisExpr(expr *ThisExpr) (interface{}, error)
 	visitSuperExpr(expr *SuperExpr) (interface{}, error)
+	visitConditionalExpr(expr *ConditionalExpr) (interface{}, error)
+	visitOptionalChainExpr(expr *OptionalChainExpr) (interface{}, error)
+	visitIndexExpr(expr *IndexExpr) (interface{}, error)
+	visitListExpr(expr *ListExpr) (interface{}, error)
+	visitMapExpr(expr *MapExpr) (interface{}, error)
+	visitSpawnExpr(expr *SpawnExpr) (interface{}, error)
+	visitAwaitExpr(expr *AwaitExpr) (interface{}, error)
 }
 
 type Expr interface {
@@ -202,16 +216,23 @@ func (expr *LogicalExpr) String() string {
 }
 
 type CallExpr struct {
-	callee Expr
-	paren  token
-	args   []Expr
+	callee    Expr
+	paren     token
+	args      []Expr
+	namedArgs []NamedArg // `f(1, b: 2)` 中的 `b: 2`
 }
 
-func newCallExpr(callee Expr, paren token, args []Expr) *CallExpr {
+type NamedArg struct {
+	name  token
+	value Expr
+}
+
+func newCallExpr(callee Expr, paren token, args []Expr, namedArgs []NamedArg) *CallExpr {
 	return &CallExpr{
-		callee: callee,
-		paren:  paren,
-		args:   args,
+		callee:    callee,
+		paren:     paren,
+		args:      args,
+		namedArgs: namedArgs,
 	}
 }
 
@@ -224,12 +245,16 @@ func (expr *CallExpr) acceptEvalVisitor(visitor EvalVisitor) (interface{}, error
 }
 
 func (expr *CallExpr) String() string {
+	if len(expr.namedArgs) > 0 {
+		return fmt.Sprintf("call expr, callee: %s args:%s named args:%v", expr.callee, expr.args, expr.namedArgs)
+	}
 	return fmt.Sprintf("call expr, callee: %s args:%s", expr.callee, expr.args)
 }
 
 type GetExpr struct {
-	object Expr
-	name   token
+	object   Expr
+	name     token
+	optional bool // `?.`，object 为 nil 的时候整个 chain 短路成 nil
 }
 
 func newGetExpr(object Expr, name token) *GetExpr {
@@ -239,6 +264,14 @@ func newGetExpr(object Expr, name token) *GetExpr {
 	}
 }
 
+func newOptionalGetExpr(object Expr, name token) *GetExpr {
+	return &GetExpr{
+		object:   object,
+		name:     name,
+		optional: true,
+	}
+}
+
 func (expr *GetExpr) acceptStringVisitor(visitor Visitor) string {
 	return visitor.visitGetExpr(expr)
 }
@@ -248,6 +281,9 @@ func (expr *GetExpr) acceptEvalVisitor(visitor EvalVisitor) (interface{}, error)
 }
 
 func (expr *GetExpr) String() string {
+	if expr.optional {
+		return fmt.Sprintf("optional get expr, object: %s name:%s", expr.object, expr.name)
+	}
 	return fmt.Sprintf("get expr, object: %s name:%s", expr.object, expr.name)
 }
 
@@ -322,3 +358,182 @@ func (expr *SuperExpr) acceptEvalVisitor(visitor EvalVisitor) (interface{}, erro
 func (expr *SuperExpr) String() string {
 	return fmt.Sprintf("super expr, keyword: %s, method: %s", expr.keyword, expr.method)
 }
+
+type ConditionalExpr struct {
+	condition  Expr
+	thenBranch Expr
+	elseBranch Expr
+}
+
+func newConditionalExpr(condition, thenBranch, elseBranch Expr) *ConditionalExpr {
+	return &ConditionalExpr{
+		condition:  condition,
+		thenBranch: thenBranch,
+		elseBranch: elseBranch,
+	}
+}
+
+func (expr *ConditionalExpr) acceptStringVisitor(visitor Visitor) string {
+	return visitor.visitConditionalExpr(expr)
+}
+
+func (expr *ConditionalExpr) acceptEvalVisitor(visitor EvalVisitor) (interface{}, error) {
+	return visitor.visitConditionalExpr(expr)
+}
+
+func (expr *ConditionalExpr) String() string {
+	return fmt.Sprintf("conditional expr, condition: %s then: %s else: %s", expr.condition, expr.thenBranch, expr.elseBranch)
+}
+
+// OptionalChainExpr 包住一整条含有 `?.` 的 call chain，比如 `a?.b.c()`。
+// chain 中任何一个 `?.` 遇到 nil 都会让整个 chain 的值变成 nil。
+type OptionalChainExpr struct {
+	expr Expr
+}
+
+func newOptionalChainExpr(expr Expr) *OptionalChainExpr {
+	return &OptionalChainExpr{
+		expr: expr,
+	}
+}
+
+func (expr *OptionalChainExpr) acceptStringVisitor(visitor Visitor) string {
+	return visitor.visitOptionalChainExpr(expr)
+}
+
+func (expr *OptionalChainExpr) acceptEvalVisitor(visitor EvalVisitor) (interface{}, error) {
+	return visitor.visitOptionalChainExpr(expr)
+}
+
+func (expr *OptionalChainExpr) String() string {
+	return fmt.Sprintf("optional chain expr, expr: %s", expr.expr)
+}
+
+// IndexExpr 是 `object[index]`，bracket 是 `[`，报错的时候用来定位。
+type IndexExpr struct {
+	object  Expr
+	bracket token
+	index   Expr
+}
+
+func newIndexExpr(object Expr, bracket token, index Expr) *IndexExpr {
+	return &IndexExpr{
+		object:  object,
+		bracket: bracket,
+		index:   index,
+	}
+}
+
+func (expr *IndexExpr) acceptStringVisitor(visitor Visitor) string {
+	return visitor.visitIndexExpr(expr)
+}
+
+func (expr *IndexExpr) acceptEvalVisitor(visitor EvalVisitor) (interface{}, error) {
+	return visitor.visitIndexExpr(expr)
+}
+
+func (expr *IndexExpr) String() string {
+	return fmt.Sprintf("index expr, object: %s index: %s", expr.object, expr.index)
+}
+
+// ListExpr 是 list 字面量 `[a, b]`。
+type ListExpr struct {
+	bracket  token
+	elements []Expr
+}
+
+func newListExpr(bracket token, elements []Expr) *ListExpr {
+	return &ListExpr{
+		bracket:  bracket,
+		elements: elements,
+	}
+}
+
+func (expr *ListExpr) acceptStringVisitor(visitor Visitor) string {
+	return visitor.visitListExpr(expr)
+}
+
+func (expr *ListExpr) acceptEvalVisitor(visitor EvalVisitor) (interface{}, error) {
+	return visitor.visitListExpr(expr)
+}
+
+func (expr *ListExpr) String() string {
+	return fmt.Sprintf("list expr, elements: %s", expr.elements)
+}
+
+// MapExpr 是 map 字面量 `{key: value}`，keys 和 values 一一对应。
+type MapExpr struct {
+	brace  token
+	keys   []Expr
+	values []Expr
+}
+
+func newMapExpr(brace token, keys, values []Expr) *MapExpr {
+	return &MapExpr{
+		brace:  brace,
+		keys:   keys,
+		values: values,
+	}
+}
+
+func (expr *MapExpr) acceptStringVisitor(visitor Visitor) string {
+	return visitor.visitMapExpr(expr)
+}
+
+func (expr *MapExpr) acceptEvalVisitor(visitor EvalVisitor) (interface{}, error) {
+	return visitor.visitMapExpr(expr)
+}
+
+func (expr *MapExpr) String() string {
+	return fmt.Sprintf("map expr, keys: %s values: %s", expr.keys, expr.values)
+}
+
+// SpawnExpr 是 `spawn f(args)`，callee 和参数在当前 task 中求值，调用在新的 task 中执行。
+type SpawnExpr struct {
+	keyword token
+	call    *CallExpr
+}
+
+func newSpawnExpr(keyword token, call *CallExpr) *SpawnExpr {
+	return &SpawnExpr{
+		keyword: keyword,
+		call:    call,
+	}
+}
+
+func (expr *SpawnExpr) acceptStringVisitor(visitor Visitor) string {
+	return visitor.visitSpawnExpr(expr)
+}
+
+func (expr *SpawnExpr) acceptEvalVisitor(visitor EvalVisitor) (interface{}, error) {
+	return visitor.visitSpawnExpr(expr)
+}
+
+func (expr *SpawnExpr) String() string {
+	return fmt.Sprintf("spawn expr, call: %s", expr.call)
+}
+
+// AwaitExpr 是 `await value`，value 是 promise 的时候等待它完成，否则直接得到 value。
+type AwaitExpr struct {
+	keyword token
+	value   Expr
+}
+
+func newAwaitExpr(keyword token, value Expr) *AwaitExpr {
+	return &AwaitExpr{
+		keyword: keyword,
+		value:   value,
+	}
+}
+
+func (expr *AwaitExpr) acceptStringVisitor(visitor Visitor) string {
+	return visitor.visitAwaitExpr(expr)
+}
+
+func (expr *AwaitExpr) acceptEvalVisitor(visitor EvalVisitor) (interface{}, error) {
+	return visitor.visitAwaitExpr(expr)
+}
+
+func (expr *AwaitExpr) String() string {
+	return fmt.Sprintf("await expr, value: %s", expr.value)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// formatNumber 是 number 在 lox 中的字符串形式，整数不带小数点和指数，比如 3000000 而不是 3e+06。
+func formatNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == math.Trunc(f) && math.Abs(f) < 1e21:
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// formatSpec 是 format 中 `{index:spec}` 的 spec 部分，语法是 [[fill]align][0][width][.precision][type]。
+// align 是 `<`、`>` 或者 `^`，type 是 s、d、f、e 或者 x。
+type formatSpec struct {
+	fill      rune
+	align     rune
+	zero      bool
+	width     int
+	precision int // 为 -1 的时候没有指定
+	verb      byte
+}
+
+func parseFormatSpec(spec string) (formatSpec, error) {
+	f := formatSpec{fill: ' ', precision: -1}
+	s := spec
+	isAlign := func(r rune) bool { return r == '<' || r == '>' || r == '^' }
+	if first, size := utf8.DecodeRuneInString(s); len(s) > size {
+		if second, secondSize := utf8.DecodeRuneInString(s[size:]); isAlign(second) {
+			f.fill, f.align = first, second
+			s = s[size+secondSize:]
+		}
+	}
+	if f.align == 0 && len(s) > 0 && isAlign(rune(s[0])) {
+		f.align = rune(s[0])
+		s = s[1:]
+	}
+	if f.align == 0 && strings.HasPrefix(s, "0") {
+		f.zero = true
+		s = s[1:]
+	}
+	digits := func() (int, bool) {
+		n := 0
+		for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		if n == 0 {
+			return 0, false
+		}
+		v, err := strconv.Atoi(s[:n])
+		s = s[n:]
+		return v, err == nil
+	}
+	f.width, _ = digits()
+	if strings.HasPrefix(s, ".") {
+		s = s[1:]
+		precision, ok := digits()
+		if !ok {
+			return f, newRuntimeError(ValueError, token{}, "format: missing precision in spec %q", spec)
+		}
+		f.precision = precision
+	}
+	if len(s) == 1 && strings.Contains("sdfex", s) {
+		f.verb = s[0]
+		s = ""
+	}
+	if s != "" {
+		return f, newRuntimeError(ValueError, token{}, "format: invalid spec %q", spec)
+	}
+	return f, nil
+}
+
+// format 按 spec 格式化一个参数，text 是参数经过 stringify 之后的结果。
+func (f formatSpec) format(arg interface{}, text string) (string, error) {
+	num, isNum := arg.(float64)
+	switch f.verb {
+	case 'd', 'x':
+		// NaN 不等于自身，也会在这里报错。
+		if !isNum || num != math.Trunc(num) || math.IsInf(num, 0) {
+			return "", newRuntimeError(TypeError, token{}, "format: {:%c} expects an integer, got %s", f.verb, text)
+		}
+		// 超出 int64 范围的值转成 int64 的结果是未定义的。
+		if num < math.MinInt64 || num >= 1<<63 {
+			return "", newRuntimeError(ValueError, token{}, "format: {:%c} integer %s is out of range", f.verb, text)
+		}
+		base := 10
+		if f.verb == 'x' {
+			base = 16
+		}
+		text = strconv.FormatInt(int64(num), base)
+	case 'f', 'e':
+		if !isNum {
+			return "", newRuntimeError(TypeError, token{}, "format: {:%c} expects a number, got %s", f.verb, text)
+		}
+		precision := f.precision
+		if precision < 0 {
+			precision = 6
+		}
+		text = strconv.FormatFloat(num, f.verb, precision, 64)
+	default:
+		if isNum && f.verb == 0 && f.precision >= 0 {
+			text = strconv.FormatFloat(num, 'f', f.precision, 64)
+		} else if f.precision >= 0 && utf8.RuneCountInString(text) > f.precision {
+			// string 的 precision 是最多保留的字符数。
+			text = string([]rune(text)[:f.precision])
+		}
+	}
+	pad := f.width - utf8.RuneCountInString(text)
+	if pad <= 0 {
+		return text, nil
+	}
+	if f.zero && isNum && f.verb != 's' {
+		sign := ""
+		if strings.HasPrefix(text, "-") {
+			sign, text = "-", text[1:]
+		}
+		return sign + strings.Repeat("0", pad) + text, nil
+	}
+	align := f.align
+	if align == 0 {
+		// 跟 python 一样，number 默认右对齐，其它的值默认左对齐。
+		align = '<'
+		if isNum && f.verb != 's' {
+			align = '>'
+		}
+	}
+	fill := string(f.fill)
+	switch align {
+	case '>':
+		return strings.Repeat(fill, pad) + text, nil
+	case '^':
+		return strings.Repeat(fill, pad/2) + text + strings.Repeat(fill, pad-pad/2), nil
+	}
+	return text + strings.Repeat(fill, pad), nil
+}
+
+// formatString 把 args 按 template 中的 `{}` 格式化，`{{` 和 `}}` 输出花括号本身。
+// `{}` 按顺序使用参数，`{1}` 使用指定下标的参数，两种方式不能混用。
+func formatString(intp Interpreter, template string, args []interface{}) (string, error) {
+	var b strings.Builder
+	next := 0
+	auto, explicit := false, false
+	for idx := 0; idx < len(template); idx++ {
+		c := template[idx]
+		if c == '}' {
+			if idx+1 < len(template) && template[idx+1] == '}' {
+				b.WriteByte('}')
+				idx++
+				continue
+			}
+			return "", newRuntimeError(ValueError, token{}, "format: single '}' at offset %d", idx)
+		}
+		if c != '{' {
+			b.WriteByte(c)
+			continue
+		}
+		if idx+1 < len(template) && template[idx+1] == '{' {
+			b.WriteByte('{')
+			idx++
+			continue
+		}
+		end := strings.IndexByte(template[idx:], '}')
+		if end < 0 {
+			return "", newRuntimeError(ValueError, token{}, "format: unclosed '{' at offset %d", idx)
+		}
+		field := template[idx+1 : idx+end]
+		idx += end
+		index, spec := field, ""
+		if colon := strings.IndexByte(field, ':'); colon >= 0 {
+			index, spec = field[:colon], field[colon+1:]
+		}
+		argIdx := next
+		if index == "" {
+			auto = true
+			next++
+		} else {
+			n, err := strconv.Atoi(index)
+			if err != nil || n < 0 {
+				return "", newRuntimeError(ValueError, token{}, "format: invalid argument index %q", index)
+			}
+			explicit = true
+			argIdx = n
+		}
+		if auto && explicit {
+			return "", newRuntimeError(ValueError, token{}, "format: cannot mix automatic and explicit argument indexes")
+		}
+		if argIdx >= len(args) {
+			return "", newRuntimeError(IndexError, token{}, "format: missing argument %d, got %d arguments", argIdx, len(args))
+		}
+		f, err := parseFormatSpec(spec)
+		if err != nil {
+			return "", err
+		}
+		text, err := intp.Stringify(args[argIdx])
+		if err != nil {
+			return "", err
+		}
+		text, err = f.format(args[argIdx], text)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(text)
+	}
+	return b.String(), nil
+}
+
+// newNativeFunctionFormat 返回 format(template, ...args)，比如 format("{:>8.2f} {}", a, b)。
+func newNativeFunctionFormat() *nativeFunction {
+	return newNativeFunction("format", Arity{Min: 1, Max: variadic}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+		template, ok := args[0].(string)
+		if !ok {
+			return nil, newRuntimeError(TypeError, token{}, "format expects a template string, got %v", loxString(args[0]))
+		}
+		s, err := formatString(intp, template, args[1:])
+		if err != nil {
+			return nil, err
+		}
+		if err := intp.Memory().chargeString(s); err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+}
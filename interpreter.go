@@ -1,25 +1,186 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 )
 
 type interpreter struct {
 	globals *Env
 	env     *Env
-	locals  map[Expr]int // 即使是同一个 name 的 var，实际上也是不同的 Expr 对象。如果 Expr 实现的 receiver 不是 pointer 的话，就不满足这个约束了。
+	locals  *localsTable
+
+	// 执行 generator body 的 interpreter 才有，yield 通过它把值交给调用方。
+	generator *generatorState
+	// 还没有结束的 generator，interpret 结束的时候关闭。
+	generators *generatorSet
+	// 执行 async function body 的 interpreter 才有，await 通过它把控制权交还给 event loop。
+	coroutine *coroutine
+	loop      *eventLoop
+
+	// 当前 goroutine 中正在执行的调用，深度超过 maxCallDepth 的时候返回 StackOverflow 错误，为 0 的时候不限制。
+	frames       []callFrame
+	maxCallDepth int
+	budget       *executionBudget
+	memory       *memoryAccount
+	capabilities capabilitySet
+	fs           *fsSandbox
+	stdin        *bufio.Reader
+	stdout       io.Writer
+	lookupEnv    func(string) (string, bool)
+	args         []string
+}
+
+// defaultMaxCallDepth 远小于 go 默认的调用栈上限能容纳的 Lox 调用深度。
+const defaultMaxCallDepth = 10000
+
+// stackOverflowFrames 是 StackOverflow 错误中保留的调用层数。
+const stackOverflowFrames = 5
+
+type callFrame struct {
+	callee Callable
+	line   int // 调用的位置，operator 之类的隐式调用没有位置，为 0
+}
+
+func (f callFrame) String() string {
+	if f.line == 0 {
+		return f.callee.String()
+	}
+	return fmt.Sprintf("%s (line %d)", f.callee, f.line)
+}
+
+type interpreterOption func(*interpreter)
+
+// withClock 替换 event loop 的时间来源。
+func withClock(clock Clock) interpreterOption {
+	return func(i *interpreter) {
+		i.loop.clock = clock
+	}
+}
+
+// withMaxSteps 限制一次 interpret 中循环的迭代和调用的总次数，为 0 的时候不限制。
+func withMaxSteps(steps int64) interpreterOption {
+	return func(i *interpreter) {
+		i.budget.maxSteps = steps
+	}
+}
+
+// withTimeout 限制一次 interpret 的执行时间，包括 event loop 的执行时间，为 0 的时候不限制。
+func withTimeout(timeout time.Duration) interpreterOption {
+	return func(i *interpreter) {
+		i.budget.timeout = timeout
+	}
+}
+
+// withMemoryLimit 限制记账的内存的字节数，为 0 的时候不限制。
+func withMemoryLimit(bytes int64) interpreterOption {
+	return func(i *interpreter) {
+		i.memory.limit = bytes
+	}
 }
 
-func newInterpreter() *interpreter {
+// withCapabilities 只授予 caps 中的 capability，没有这个 option 的时候授予所有的 capability。
+func withCapabilities(caps ...capability) interpreterOption {
+	return func(i *interpreter) {
+		i.capabilities = newCapabilitySet(caps...)
+	}
+}
+
+// withFSRoot 允许 fs namespace 访问 root 目录下的文件，没有这个 option 的时候不能访问文件系统。
+func withFSRoot(root string) interpreterOption {
+	return func(i *interpreter) {
+		i.fs = newFSSandbox(root)
+	}
+}
+
+// withStdin 替换 readLine 和 readAll 读取的输入，stdin 已经是 *bufio.Reader 的时候直接使用，跟调用方共享缓冲区。
+func withStdin(stdin io.Reader) interpreterOption {
+	return func(i *interpreter) {
+		if reader, ok := stdin.(*bufio.Reader); ok {
+			i.stdin = reader
+			return
+		}
+		i.stdin = bufio.NewReader(stdin)
+	}
+}
+
+// withLookupEnv 替换 getenv 读取环境变量的方式。
+func withLookupEnv(lookup func(string) (string, bool)) interpreterOption {
+	return func(i *interpreter) {
+		i.lookupEnv = lookup
+	}
+}
+
+// withStdout 替换 print 输出的位置。
+func withStdout(stdout io.Writer) interpreterOption {
+	return func(i *interpreter) {
+		i.stdout = stdout
+	}
+}
+
+// withArgs 设置脚本通过 args 拿到的命令行参数。
+func withArgs(args []string) interpreterOption {
+	return func(i *interpreter) {
+		i.args = args
+	}
+}
+
+// withMaxCallDepth 设置调用深度的上限，为 0 的时候不限制。
+func withMaxCallDepth(depth int) interpreterOption {
+	return func(i *interpreter) {
+		i.maxCallDepth = depth
+	}
+}
+
+func newInterpreter(opts ...interpreterOption) *interpreter {
 	i := &interpreter{}
 	i.globals = newEnv()
 	i.env = i.globals
+	i.budget = newExecutionBudget()
+	i.memory = newMemoryAccount()
+	i.capabilities = newCapabilitySet(allCapabilities...)
+	i.fs = newFSSandbox("")
+	i.stdin = stdinReader
+	i.stdout = os.Stdout
+	i.lookupEnv = os.LookupEnv
+	i.loop = newEventLoop(realClock{}, i.budget)
+	i.generators = newGeneratorSet()
+	i.maxCallDepth = defaultMaxCallDepth
+	for _, opt := range opts {
+		opt(i)
+	}
 	// init native functions
 	i.globals.Define("clock", newNativeFunctionClock())
+	i.globals.Define("range", newNativeFunctionRange())
+	i.globals.Define("channel", newNativeFunctionChannel())
+	i.globals.Define("setTimeout", newNativeFunctionSetTimeout())
+	i.globals.Define("setInterval", newNativeFunctionSetInterval())
+	i.globals.Define("clearTimer", newNativeFunctionClearTimer())
+	i.globals.Define("sleep", newNativeFunctionSleep())
+	i.globals.Define("fs", newFSNamespace(i.fs))
+	i.globals.Define("args", newLoxArgs(i.args))
+	i.globals.Define("readLine", newNativeFunctionReadLine(i.stdin))
+	i.globals.Define("readAll", newNativeFunctionReadAll(i.stdin))
+	i.globals.Define("exit", newNativeFunctionExit())
+	i.globals.Define("getenv", newNativeFunctionGetenv(i.lookupEnv))
+	i.globals.Define("json", newJSONNamespace())
+	i.globals.Define("regex", newRegexNamespace())
+	i.globals.Define("datetime", newDatetimeNamespace())
+	i.globals.Define("format", newNativeFunctionFormat())
+	// 除以 0 会报 ZeroDivisionError，需要 NaN 和 Infinity 的时候使用这两个常量。
+	i.globals.Define("nan", math.NaN())
+	i.globals.Define("inf", math.Inf(1))
 	// record variables' distance to current env
-	i.locals = make(map[Expr]int)
+	i.locals = newLocalsTable()
 	return i
 }
 
@@ -27,6 +188,47 @@ func (i *interpreter) GetGlobalEnv() *Env {
 	return i.globals
 }
 
+func (i *interpreter) Fork() Interpreter {
+	return &interpreter{
+		globals: i.globals,
+		env:     i.globals,
+		locals:  i.locals,
+		loop:    i.loop,
+
+		generators:   i.generators,
+		maxCallDepth: i.maxCallDepth,
+		budget:       i.budget.fork(),
+		memory:       i.memory,
+		capabilities: i.capabilities,
+		fs:           i.fs,
+		stdin:        i.stdin,
+		stdout:       i.stdout,
+		lookupEnv:    i.lookupEnv,
+		args:         i.args,
+	}
+}
+
+func (i *interpreter) EventLoop() *eventLoop {
+	return i.loop
+}
+
+func (i *interpreter) Budget() *executionBudget {
+	return i.budget
+}
+
+func (i *interpreter) Memory() *memoryAccount {
+	return i.memory
+}
+
+func (i *interpreter) HasCapability(c capability) bool {
+	return i.capabilities[c]
+}
+
+// MemoryStats 返回到目前为止的内存统计。
+func (i *interpreter) MemoryStats() MemoryStats {
+	return i.memory.stats()
+}
+
 func (i *interpreter) debugEnv() {
 	if i == nil {
 		return
@@ -46,14 +248,35 @@ func (i *interpreter) debugEnv() {
 	}
 }
 
-func (i *interpreter) interpret(stmts []Stmt) {
+// interpret 执行顶层代码，ctx 结束的时候执行会停下来并返回 BudgetExceededError。
+// 顶层语句的错误在这里输出之后返回，exit(code) 返回的 ExitError 不算失败，不输出。
+// event loop 的错误包装成 EventLoopError 返回，不在这里输出。
+func (i *interpreter) interpret(ctx context.Context, stmts []Stmt) error {
+	cancel := i.budget.start(ctx)
+	defer cancel()
+	i.memory.start()
+	// 没有执行完的 generator 的 body 停在 yield 上，不关闭的话执行它们的 goroutine 会一直留着。
+	defer i.generators.closeAll()
+	// 出错的时候 event loop 没有执行完，停在 await 上的 coroutine 也要取消，执行成功的时候 loop.run 已经取消过了。
+	defer i.loop.cancelSuspended()
+	var exit *ExitError
 	for _, stmt := range stmts {
 		if err := i.execute(stmt); err != nil {
-			fmt.Printf("Execute stmt: %s failed, reason: %v\n", stmt, err)
-			return
+			if !errors.As(err, &exit) {
+				fmt.Printf("Execute stmt: %s failed, reason: %v\n", stmt, err)
+			}
+			return err
 		}
 	}
+	// 顶层代码执行完之后，继续执行 timer 和 async function 直到 event loop 为空。
+	if err := i.loop.run(); err != nil {
+		if errors.As(err, &exit) {
+			return err
+		}
+		return &EventLoopError{Err: err}
+	}
 	fmt.Println(strings.ToUpper("Execute stmts success!"))
+	return nil
 }
 
 func (i *interpreter) execute(stmt Stmt) error {
@@ -64,16 +287,56 @@ func (i *interpreter) evaluate(expr Expr) (interface{}, error) {
 	return expr.acceptEvalVisitor(i)
 }
 
+// evaluateIn 在指定的 env 中对 expr 求值，求值结束后还原 env。
+func (i *interpreter) evaluateIn(expr Expr, env *Env) (interface{}, error) {
+	preEnv := i.env
+	i.env = env
+	defer func() {
+		i.env = preEnv
+	}()
+	return i.evaluate(expr)
+}
+
+func (i *interpreter) Evaluate(expr Expr, env *Env) (interface{}, error) {
+	return i.evaluateIn(expr, env)
+}
+
 func (i *interpreter) Resolve(expr Expr, distance int) error {
 	return i.resolve(expr, distance)
 }
 
 func (i *interpreter) resolve(expr Expr, distance int) error {
 	// fmt.Printf("put %s to locals, distane: %d\n", expr, distance)
-	i.locals[expr] = distance
+	i.locals.set(expr, distance)
 	return nil
 }
 
+// localsTable 记录 resolver 计算出的变量距离，被同一个程序的所有 task 共享。
+type localsTable struct {
+	mu sync.RWMutex
+	// 即使是同一个 name 的 var，实际上也是不同的 Expr 对象。如果 Expr 实现的 receiver 不是 pointer 的话，就不满足这个约束了。
+	distances map[Expr]int
+}
+
+func newLocalsTable() *localsTable {
+	return &localsTable{
+		distances: make(map[Expr]int),
+	}
+}
+
+func (t *localsTable) get(expr Expr) (int, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	distance, ok := t.distances[expr]
+	return distance, ok
+}
+
+func (t *localsTable) set(expr Expr, distance int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.distances[expr] = distance
+}
+
 func (i *interpreter) isTruthy(obj interface{}) bool {
 	if obj == nil {
 		return false
@@ -86,14 +349,24 @@ func (i *interpreter) isTruthy(obj interface{}) bool {
 	}
 }
 
-func (i *interpreter) isEqual(obj1, obj2 interface{}) bool {
+// isEqual 在操作数是 LoxInstance 并且实现了 `__eq` 的时候使用 `__eq` 的结果，否则 number、string 和 bool 按值比较，其它的值按引用比较。
+func (i *interpreter) isEqual(obj1, obj2 interface{}) (bool, error) {
+	if v, ok, err := i.binaryOperator(EQUAL_EQUAL, obj1, obj2); err != nil {
+		return false, err
+	} else if ok {
+		return i.isTruthy(v), nil
+	}
 	if obj1 == nil && obj2 == nil {
-		return true
+		return true, nil
 	}
 	if obj1 == nil || obj2 == nil {
-		return false
+		return false, nil
 	}
-	return reflect.DeepEqual(obj1, obj2)
+	// instance、list 和 map 按引用比较，字段相同的两个 instance 也不相等，需要的话用 `__eq` 自己定义。
+	if !reflect.TypeOf(obj1).Comparable() || !reflect.TypeOf(obj2).Comparable() {
+		return false, nil
+	}
+	return obj1 == obj2, nil
 }
 
 func (i *interpreter) checkNumber(obj interface{}) (float64, error) {
@@ -123,18 +396,19 @@ func (i *interpreter) checkNumber(obj interface{}) (float64, error) {
 	case float64:
 		return obj.(float64), nil
 	default:
-		return 0, fmt.Errorf("%v is not a number", obj)
+		return 0, newRuntimeError(TypeError, token{}, "%s is not a number", loxString(obj)).withValues(obj)
 	}
 }
 
-func (i *interpreter) checkNumbers(obj1, obj2 interface{}) (float64, float64, error) {
+// checkNumbers 检查 operator 的两个操作数都是 number。
+func (i *interpreter) checkNumbers(operator token, obj1, obj2 interface{}) (float64, float64, error) {
 	obj1Num, err := i.checkNumber(obj1)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, atToken(err, operator)
 	}
 	obj2Num, err := i.checkNumber(obj2)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, atToken(err, operator)
 	}
 	return obj1Num, obj2Num, nil
 }
@@ -144,7 +418,7 @@ func (i interpreter) checkString(obj interface{}) (string, error) {
 	case string:
 		return obj.(string), nil
 	default:
-		return "", fmt.Errorf("%v is not a string", obj)
+		return "", newRuntimeError(TypeError, token{}, "%s is not a string", loxString(obj)).withValues(obj)
 	}
 }
 
@@ -169,65 +443,88 @@ func (i *interpreter) visitBinaryExpr(expr *BinaryExpr) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	if v, ok, err := i.binaryOperator(expr.operator.Type, left, right); err != nil {
+		return nil, atToken(err, expr.operator)
+	} else if ok {
+		return v, nil
+	}
 	switch expr.operator.Type {
 	case GREATER:
-		leftNum, rightNum, err := i.checkNumbers(left, right)
+		leftNum, rightNum, err := i.checkNumbers(expr.operator, left, right)
 		if err != nil {
 			return nil, err
 		}
 		return leftNum > rightNum, nil
 	case GREATER_EQUAL:
-		leftNum, rightNum, err := i.checkNumbers(left, right)
+		leftNum, rightNum, err := i.checkNumbers(expr.operator, left, right)
 		if err != nil {
 			return nil, err
 		}
 		return leftNum >= rightNum, nil
 	case LESS:
-		leftNum, rightNum, err := i.checkNumbers(left, right)
+		leftNum, rightNum, err := i.checkNumbers(expr.operator, left, right)
 		if err != nil {
 			return nil, err
 		}
 		return leftNum < rightNum, nil
 	case LESS_EQUAL:
-		leftNum, rightNum, err := i.checkNumbers(left, right)
+		leftNum, rightNum, err := i.checkNumbers(expr.operator, left, right)
 		if err != nil {
 			return nil, err
 		}
 		return leftNum <= rightNum, nil
 	case BANG_EQUAL:
-		return !i.isEqual(left, right), nil
+		equal, err := i.isEqual(left, right)
+		return !equal, err
 	case EQUAL_EQUAL:
-		return i.isEqual(left, right), nil
+		return i.isEqual(left, right)
 	case MINUS:
-		leftNum, rightNum, err := i.checkNumbers(left, right)
+		leftNum, rightNum, err := i.checkNumbers(expr.operator, left, right)
 		if err != nil {
 			return nil, err
 		}
 		return leftNum - rightNum, nil
 	case PLUS:
-		leftNum, rightNum, err := i.checkNumbers(left, right)
+		leftNum, rightNum, err := i.checkNumbers(expr.operator, left, right)
 		if err == nil {
 			return leftNum + rightNum, nil
 		}
-		leftStr, rightStr, err := i.checkStrings(left, right)
-		if err == nil {
-			return leftStr + rightStr, nil
+		// 有一边是 string 的时候，另一边转换成 string 再拼接。
+		_, leftIsStr := left.(string)
+		_, rightIsStr := right.(string)
+		if leftIsStr || rightIsStr {
+			leftStr, err := i.stringify(left)
+			if err != nil {
+				return nil, err
+			}
+			rightStr, err := i.stringify(right)
+			if err != nil {
+				return nil, err
+			}
+			result := leftStr + rightStr
+			if err := i.memory.chargeString(result); err != nil {
+				return nil, err
+			}
+			return result, nil
 		}
-		return nil, fmt.Errorf("left: %v, right: %v are not the same type(float or string)", left, right)
+		return nil, newRuntimeError(TypeError, expr.operator, "left: %s, right: %s are not the same type(float or string)", loxString(left), loxString(right)).withValues(left, right)
 	case SLASH:
-		leftNum, rightNum, err := i.checkNumbers(left, right)
+		leftNum, rightNum, err := i.checkNumbers(expr.operator, left, right)
 		if err != nil {
 			return nil, err
 		}
+		if rightNum == 0 {
+			return nil, newRuntimeError(ZeroDivision, expr.operator, "division by zero").withValues(left, right)
+		}
 		return leftNum / rightNum, nil
 	case STAR:
-		leftNum, rightNum, err := i.checkNumbers(left, right)
+		leftNum, rightNum, err := i.checkNumbers(expr.operator, left, right)
 		if err != nil {
 			return nil, err
 		}
 		return leftNum * rightNum, nil
 	default:
-		return nil, fmt.Errorf("unkown operator: %v between %v and %v", expr.operator, expr.left, expr.right)
+		return nil, newRuntimeError(InternalError, expr.operator, "unknown binary operator %s", expr.operator.Lexeme)
 	}
 }
 
@@ -240,13 +537,18 @@ func (i *interpreter) visitUnaryExpr(expr *UnaryExpr) (interface{}, error) {
 	case BANG:
 		return i.isTruthy(right), nil
 	case MINUS:
+		if instance, ok := right.(*LoxInstance); ok {
+			if v, ok, err := i.callSpecialMethod(instance, "__neg"); ok || err != nil {
+				return v, err
+			}
+		}
 		v, ok := right.(float64)
 		if !ok {
-			customPanic("not a float")
+			return nil, newRuntimeError(TypeError, expr.operator, "operand of - must be a number, got %s", loxString(right)).withValues(right)
 		}
 		return -v, nil
 	}
-	return nil, fmt.Errorf("cannot eval -(%v)", right)
+	return nil, newRuntimeError(InternalError, expr.operator, "unknown unary operator %s", expr.operator.Lexeme)
 }
 
 func (i *interpreter) visitLiteralExpr(expr *LiteralExpr) (interface{}, error) {
@@ -266,11 +568,92 @@ func (i *interpreter) visitGetExpr(expr *GetExpr) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	v, ok := object.(*LoxInstance)
-	if !ok {
-		return nil, fmt.Errorf("%s is not a LoxInstance", object)
+	if object == nil && expr.optional {
+		return nil, errOptionalChainShortCircuit
+	}
+	if expr.name.Type == PRIVATE_IDENTIFIER {
+		class, err := i.enclosingClass(expr)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := object.(*LoxInstance)
+		if !ok {
+			return nil, newRuntimeError(PropertyError, expr.name, "private member %s of class %s is not accessible on %s", expr.name.Lexeme, class.name, loxString(object)).withValues(object)
+		}
+		return v.GetPrivate(class, expr.name)
+	}
+	switch v := object.(type) {
+	case *LoxInstance:
+		value, err := v.Get(i, expr.name)
+		return value, atToken(err, expr.name)
+	case propertyGetter:
+		value, err := v.Get(expr.name)
+		return value, atToken(err, expr.name)
 	}
-	return v.Get(expr.name)
+	return nil, newRuntimeError(PropertyError, expr.name, "cannot get property %s of %s, it is not an object", expr.name.Lexeme, loxString(object)).withValues(object)
+}
+
+// `object[index]` 支持 list 和 map，instance 调用 `__index`。
+func (i *interpreter) visitIndexExpr(expr *IndexExpr) (interface{}, error) {
+	object, err := i.evaluate(expr.object)
+	if err != nil {
+		return nil, err
+	}
+	index, err := i.evaluate(expr.index)
+	if err != nil {
+		return nil, err
+	}
+	switch v := object.(type) {
+	case *LoxList:
+		value, err := v.Index(index)
+		return value, atToken(err, expr.bracket)
+	case *LoxMap:
+		value, err := v.Index(index)
+		return value, atToken(err, expr.bracket)
+	case *LoxInstance:
+		if v, ok, err := i.callSpecialMethod(v, "__index", index); ok || err != nil {
+			return v, err
+		}
+	}
+	return nil, newRuntimeError(TypeError, expr.bracket, "%s is not indexable", loxString(object)).withValues(object, index)
+}
+
+func (i *interpreter) visitListExpr(expr *ListExpr) (interface{}, error) {
+	elements := make([]interface{}, 0, len(expr.elements))
+	for _, element := range expr.elements {
+		v, err := i.evaluate(element)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, v)
+	}
+	if err := i.memory.charge(memoryCollections, collectionCost+int64(len(elements))*elementCost); err != nil {
+		return nil, err
+	}
+	return newLoxList(elements), nil
+}
+
+func (i *interpreter) visitMapExpr(expr *MapExpr) (interface{}, error) {
+	m := newLoxMap()
+	for idx, keyExpr := range expr.keys {
+		key, err := i.evaluate(keyExpr)
+		if err != nil {
+			return nil, err
+		}
+		if !isHashable(key) {
+			return nil, newRuntimeError(TypeError, expr.brace, "%s cannot be used as a map key", loxString(key)).withValues(key)
+		}
+		value, err := i.evaluate(expr.values[idx])
+		if err != nil {
+			return nil, err
+		}
+		m.Store(key, value)
+	}
+	// 跟 list 一样，先对 entry 求值，entry 中分配的内存先记账。
+	if err := i.memory.charge(memoryCollections, collectionCost+int64(m.Len())*mapEntryCost); err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
 func (i *interpreter) visitSetExpr(expr *SetExpr) (interface{}, error) {
@@ -278,15 +661,55 @@ func (i *interpreter) visitSetExpr(expr *SetExpr) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	v, ok := object.(*LoxInstance)
-	if !ok {
-		return nil, fmt.Errorf("%s is not a LoxInstance, only LoxInstance has fields", object)
+	if expr.name.Type == PRIVATE_IDENTIFIER {
+		class, err := i.enclosingClass(expr)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := object.(*LoxInstance)
+		if !ok {
+			return nil, newRuntimeError(PropertyError, expr.name, "private member %s of class %s is not accessible on %s", expr.name.Lexeme, class.name, loxString(object)).withValues(object)
+		}
+		value, err := i.evaluate(expr.value)
+		if err != nil {
+			return nil, err
+		}
+		return nil, v.SetPrivate(class, expr.name, value)
 	}
-	value, err := i.evaluate(expr.value)
+	switch v := object.(type) {
+	case *LoxInstance:
+		value, err := i.evaluate(expr.value)
+		if err != nil {
+			return nil, err
+		}
+		return nil, atToken(v.Set(i, expr.name, value), expr.name)
+	case *LoxClass:
+		value, err := i.evaluate(expr.value)
+		if err != nil {
+			return nil, err
+		}
+		return nil, atToken(v.Set(expr.name, value), expr.name)
+	}
+	return nil, newRuntimeError(PropertyError, expr.name, "cannot set property %s of %s, only instances and classes have fields", expr.name.Lexeme, loxString(object)).withValues(object)
+}
+
+func (i *interpreter) visitConditionalExpr(expr *ConditionalExpr) (interface{}, error) {
+	condition, err := i.evaluate(expr.condition)
 	if err != nil {
 		return nil, err
 	}
-	return nil, v.Set(expr.name, value)
+	if i.isTruthy(condition) {
+		return i.evaluate(expr.thenBranch)
+	}
+	return i.evaluate(expr.elseBranch)
+}
+
+func (i *interpreter) visitOptionalChainExpr(expr *OptionalChainExpr) (interface{}, error) {
+	value, err := i.evaluate(expr.expr)
+	if errors.Is(err, errOptionalChainShortCircuit) {
+		return nil, nil
+	}
+	return value, err
 }
 
 func (i *interpreter) visitThisExpr(expr *ThisExpr) (interface{}, error) {
@@ -294,9 +717,9 @@ func (i *interpreter) visitThisExpr(expr *ThisExpr) (interface{}, error) {
 }
 
 func (i *interpreter) visitSuperExpr(expr *SuperExpr) (interface{}, error) {
-	distance, ok := i.locals[expr]
+	distance, ok := i.locals.get(expr)
 	if !ok {
-		return nil, fmt.Errorf("expr: %s not found in locas", expr)
+		return nil, newRuntimeError(InternalError, expr.keyword, "super expression is not resolved")
 	}
 	var err error
 	var superclass *LoxClass
@@ -305,19 +728,23 @@ func (i *interpreter) visitSuperExpr(expr *SuperExpr) (interface{}, error) {
 		return nil, err
 	}
 
+	if superclassInterface == nil {
+		return nil, newRuntimeError(TypeError, expr.keyword, "cannot use 'super' in a class with no super class")
+	}
 	if v, ok := superclassInterface.(*LoxClass); !ok {
-		return nil, fmt.Errorf("cast %s to LoxClass failed", superclassInterface)
+		return nil, newRuntimeError(InternalError, expr.keyword, "superclass %s is not a class", loxString(superclassInterface))
 	} else {
 		superclass = v
 	}
 
 	var object *LoxInstance
-	objectInterface, err := i.env.GetAtByVarName(distance-1, "this")
+	// super 和 this 之间隔着 "#class" 所在的 env
+	objectInterface, err := i.env.GetAtByVarName(distance-2, "this")
 	if err != nil {
 		return nil, err
 	}
 	if v, ok := objectInterface.(*LoxInstance); !ok {
-		return nil, fmt.Errorf("cast %s to LoxClass failed", superclassInterface)
+		return nil, newRuntimeError(InternalError, expr.keyword, "this %s is not an instance", loxString(objectInterface))
 	} else {
 		object = v
 	}
@@ -328,14 +755,38 @@ func (i *interpreter) visitSuperExpr(expr *SuperExpr) (interface{}, error) {
 	}
 
 	if method == nil {
-		return nil, fmt.Errorf("%s undefined property %s", expr.method, expr.method.Lexeme)
+		return nil, newRuntimeError(PropertyError, expr.method, "undefined property %s in superclass %s", expr.method.Lexeme, superclass.name)
+	}
+
+	bound, err := method.Bind(object)
+	if err != nil {
+		return nil, err
 	}
+	if bound.isGetter {
+		return i.call(bound, nil, expr.method.line)
+	}
+	return bound, nil
+}
 
-	return method.Bind(object)
+// enclosingClass 返回访问 private member 的代码所在的 class，resolver 把 "#class" 的距离记录在访问的 expr 上。
+func (i *interpreter) enclosingClass(expr Expr) (*LoxClass, error) {
+	distance, ok := i.locals.get(expr)
+	if !ok {
+		return nil, newRuntimeError(InternalError, token{}, "private member access %s is not resolved", expr)
+	}
+	v, err := i.env.GetAtByVarName(distance, "#class")
+	if err != nil {
+		return nil, err
+	}
+	class, ok := v.(*LoxClass)
+	if !ok {
+		return nil, newRuntimeError(InternalError, token{}, "enclosing class %s is not a class", loxString(v))
+	}
+	return class, nil
 }
 
 func (i *interpreter) lookupVariable(exprName token, expr Expr) (interface{}, error) {
-	distance, ok := i.locals[expr]
+	distance, ok := i.locals.get(expr)
 	if ok {
 		return i.env.GetAtByVarName(distance, exprName.Lexeme)
 	}
@@ -344,18 +795,20 @@ func (i *interpreter) lookupVariable(exprName token, expr Expr) (interface{}, er
 
 func (i *interpreter) visitPrintStmt(stmt PrintStmt) error {
 	value, err := i.evaluate(stmt.expr)
-	if err != nil {
+	if err != nil || (stmt.bare && value == nil) {
 		return err
 	}
-	if value != nil {
-		fmt.Println(value)
+	str, err := i.stringify(value)
+	if err != nil {
+		return err
 	}
+	fmt.Fprintln(i.stdout, str)
 	return nil
 }
 
 func (i *interpreter) visitExpressionStmt(stmt ExpressionStmt) error {
-	i.evaluate(stmt.expr)
-	return nil
+	_, err := i.evaluate(stmt.expr)
+	return err
 }
 
 func (i *interpreter) visitVarStmt(stmt VarStmt) error {
@@ -367,12 +820,22 @@ func (i *interpreter) visitVarStmt(stmt VarStmt) error {
 			return err
 		}
 	}
+	if _, ok := i.env.lookup(stmt.name.Lexeme); !ok {
+		if err := i.memory.chargeEnv(i.env, 1); err != nil {
+			return err
+		}
+	}
 	i.env.Define(stmt.name.Lexeme, value)
 	return nil
 }
 
 func (i *interpreter) visitBlockStmt(stmt BlockStmt) error {
-	return i.executeBlock(stmt.stmts, newEnvWithEnclosing(i.env))
+	env := newEnvWithEnclosing(i.env)
+	if err := i.memory.chargeEnv(env, 0); err != nil {
+		return err
+	}
+	defer i.memory.releaseEnv(env)
+	return i.executeBlock(stmt.stmts, env)
 }
 
 func (i *interpreter) ExecuteBlock(stmts []Stmt, env *Env) error {
@@ -405,10 +868,34 @@ func (i *interpreter) visitClassStmt(stmt ClassStmt) error {
 		}
 		v, ok := superclassInterface.(*LoxClass)
 		if !ok {
-			return fmt.Errorf("superclass must be a class")
+			return newRuntimeError(TypeError, stmt.superclass.name, "superclass %s must be a class, got %s", stmt.superclass.name.Lexeme, loxString(superclassInterface)).withValues(superclassInterface)
 		}
 		superclass = v
 	}
+	var traits []*LoxTrait
+	for _, traitExpr := range stmt.traits {
+		traitInterface, err := i.evaluate(traitExpr)
+		if err != nil {
+			return err
+		}
+		trait, ok := traitInterface.(*LoxTrait)
+		if !ok {
+			return newRuntimeError(TypeError, traitExpr.name, "%s is not a trait", traitExpr.name.Lexeme).withValues(traitInterface)
+		}
+		traits = append(traits, trait)
+	}
+	var interfaces []*LoxInterface
+	for _, interfaceExpr := range stmt.interfaces {
+		interfaceInterface, err := i.evaluate(interfaceExpr)
+		if err != nil {
+			return err
+		}
+		iface, ok := interfaceInterface.(*LoxInterface)
+		if !ok {
+			return newRuntimeError(TypeError, interfaceExpr.name, "%s is not an interface", interfaceExpr.name.Lexeme).withValues(interfaceInterface)
+		}
+		interfaces = append(interfaces, iface)
+	}
 	i.env.Define(stmt.name.Lexeme, nil)
 
 	//  处理 super 调用
@@ -416,6 +903,9 @@ func (i *interpreter) visitClassStmt(stmt ClassStmt) error {
 		i.env = newEnvWithEnclosing(i.env)
 		i.env.Define("super", superclass)
 	}
+	// "#class" 记录 method 所在的 class，访问 private member 的时候用。
+	i.env = newEnvWithEnclosing(i.env)
+	i.env.Define("#class", nil)
 
 	methods := make(map[string]*LoxFunction) // 这里是指针会不会有问题？
 	for _, method := range stmt.methods {
@@ -426,33 +916,330 @@ func (i *interpreter) visitClassStmt(stmt ClassStmt) error {
 		function := newLoxFunction(method, i.env, isInitializar)
 		methods[method.name.Lexeme] = function
 	}
+	for _, method := range stmt.abstractMethods {
+		methods[method.name.Lexeme] = newLoxFunction(method, i.env, false)
+	}
+	for _, getter := range stmt.getters {
+		function := newLoxFunction(getter, i.env, false)
+		function.isGetter = true
+		methods[getter.name.Lexeme] = function
+	}
+	for _, setter := range stmt.setters {
+		methods[setterKey(setter.name.Lexeme)] = newLoxFunction(setter, i.env, false)
+	}
 	loxClass := newLoxClassWithSuperClass(stmt.name.Lexeme, superclass, methods)
+	err := i.mixInTraits(loxClass, traits)
+	if err == nil {
+		err = loxClass.checkInterfaces(interfaces)
+	}
+	if err != nil {
+		i.env = i.env.enclosing
+		if superclass != nil {
+			i.env = i.env.enclosing
+		}
+		return err
+	}
+	for _, method := range stmt.staticMethods {
+		loxClass.staticMethods[method.name.Lexeme] = newLoxFunction(method, i.env, false)
+	}
+	for _, method := range stmt.privateMethods {
+		loxClass.privateMethods[method.name.Lexeme] = newLoxFunction(method, i.env, false)
+	}
+	loxClass.privateFields = stmt.privateFields
+	loxClass.privateEnv = i.env
+	i.env.Define("#class", loxClass)
+	methodEnv := i.env
+	i.env = i.env.enclosing
 
 	// 处理 super 调用，把 env 还原。
 	if superclass != nil {
 		i.env = i.env.enclosing
 	}
 
-	return i.env.Assign(stmt.name, loxClass)
+	if err := i.env.Assign(stmt.name, loxClass); err != nil {
+		return err
+	}
+	// static field 的初始值在 class 定义之后按顺序求值，this 指向 class 本身。
+	if len(stmt.staticFields) > 0 {
+		env := newEnvWithEnclosing(methodEnv)
+		env.Define("this", loxClass)
+		for _, field := range stmt.staticFields {
+			var value interface{}
+			if field.expr != nil {
+				var err error
+				value, err = i.evaluateIn(field.expr, env)
+				if err != nil {
+					return err
+				}
+			}
+			if err := loxClass.Set(field.name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mixInTraits 把 trait 中的 method 复制到 class 的 method 表中。
+// class 自己声明的 method 优先级最高，其次是 trait，最后才是从 superclass 继承来的 method。
+// 如果两个 trait 提供了同名的 method 而 class 自己没有声明，就是冲突，必须在 class 中显式 override。
+func (i *interpreter) mixInTraits(class *LoxClass, traits []*LoxTrait) error {
+	declared := make(map[string]bool)
+	for name, method := range class.methods {
+		// trait 可以实现 class 自己声明的 abstract method。
+		declared[name] = !method.declaration.isAbstract
+	}
+	providedBy := make(map[string]*LoxTrait)
+	for _, trait := range traits {
+		methods := trait.methodsFor(class)
+		for _, method := range trait.methods {
+			name := method.name.Lexeme
+			if declared[name] {
+				continue
+			}
+			if other, ok := providedBy[name]; ok {
+				return newRuntimeError(TypeError, method.name, "method %s is provided by both trait %s and trait %s, override it in class %s", name, other.name, trait.name, class.name)
+			}
+			providedBy[name] = trait
+			class.methods[name] = methods[name]
+		}
+	}
+	return nil
+}
+
+func (i *interpreter) visitTraitStmt(stmt TraitStmt) error {
+	i.env.Define(stmt.name.Lexeme, newLoxTrait(stmt.name.Lexeme, stmt.methods, i.env))
+	return nil
+}
+
+func (i *interpreter) visitInterfaceStmt(stmt InterfaceStmt) error {
+	i.env.Define(stmt.name.Lexeme, newLoxInterface(stmt.name.Lexeme, stmt.methods))
+	return nil
+}
+
+// 按顺序尝试每个 case，第一个 pattern 匹配并且 guard 为真的 case 会被执行。
+// 每次尝试都用一个新的 env，这样匹配失败的 pattern 绑定的名字不会泄漏到后面的 case。
+func (i *interpreter) visitMatchStmt(stmt MatchStmt) error {
+	subject, err := i.evaluate(stmt.subject)
+	if err != nil {
+		return err
+	}
+	for _, matchCase := range stmt.cases {
+		for _, pattern := range matchCase.patterns {
+			env := newEnvWithEnclosing(i.env)
+			matched, err := i.matchPattern(pattern, subject, env)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				continue
+			}
+			if matchCase.guard != nil {
+				guard, err := i.evaluateIn(matchCase.guard, env)
+				if err != nil {
+					return err
+				}
+				if !i.isTruthy(guard) {
+					continue
+				}
+			}
+			return i.executeBlock([]Stmt{matchCase.body}, env)
+		}
+	}
+	return newRuntimeError(MatchError, stmt.keyword, "no case matched value %s", loxString(subject)).withValues(subject)
+}
+
+func (i *interpreter) matchPattern(pattern Pattern, value interface{}, env *Env) (bool, error) {
+	switch v := pattern.(type) {
+	case *WildcardPattern:
+		return true, nil
+	case *LiteralPattern:
+		return i.isEqual(v.value, value)
+	case *BindingPattern:
+		env.Define(v.name.Lexeme, value)
+		return true, nil
+	case *ClassPattern:
+		classInterface, err := i.evaluateIn(v.class, env)
+		if err != nil {
+			return false, err
+		}
+		class, ok := classInterface.(*LoxClass)
+		if !ok {
+			return false, newRuntimeError(TypeError, v.class.name, "%s in pattern is not a class", v.class.name.Lexeme).withValues(classInterface)
+		}
+		instance, ok := value.(*LoxInstance)
+		if !ok || !instance.class.isSubclassOf(class) {
+			return false, nil
+		}
+		for _, field := range v.fields {
+			// 跟 `.` 一样查找属性，getter 也可以被匹配；instance 没有这个属性的时候不匹配。
+			has, err := instance.hasProperty(field.name.Lexeme)
+			if err != nil || !has {
+				return false, err
+			}
+			fieldValue, err := instance.Get(i, field.name)
+			if err != nil {
+				return false, err
+			}
+			matched, err := i.matchPattern(field.pattern, fieldValue, env)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+		return true, nil
+	default:
+		return false, newRuntimeError(InternalError, token{}, "unknown pattern %s", pattern)
+	}
 }
 
 func (i *interpreter) visitWhileStmt(stmt WhileStmt) error {
 	for {
+		if err := i.budget.step(); err != nil {
+			return err
+		}
 		condition, err := i.evaluate(stmt.condition)
 		if err != nil {
 			return err
 		}
-		if i.isTruthy(condition) {
-			if err := i.execute(stmt.body); err != nil {
+		if !i.isTruthy(condition) {
+			break
+		}
+		if err := i.execute(stmt.body); err != nil {
+			if errors.Is(err, errBreak) {
+				break
+			}
+			if !errors.Is(err, errContinue) {
+				return err
+			}
+		}
+		if stmt.increment != nil {
+			if _, err := i.evaluate(stmt.increment); err != nil {
 				return err
 			}
-		} else {
-			break
 		}
 	}
 	return nil
 }
 
+// 每次循环都在一个新的 env 中绑定 name，这样 body 中的 closure 捕获的是当次循环的值。
+func (i *interpreter) visitForInStmt(stmt ForInStmt) error {
+	iterable, err := i.evaluate(stmt.iterable)
+	if err != nil {
+		return err
+	}
+	next, err := i.iteratorOf(iterable)
+	if err != nil {
+		return err
+	}
+	for {
+		if err := i.budget.step(); err != nil {
+			return err
+		}
+		value, ok, err := next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		env := newEnvWithEnclosing(i.env)
+		if err := i.memory.chargeEnv(env, 1); err != nil {
+			return err
+		}
+		env.Define(stmt.name.Lexeme, value)
+		err = i.executeBlock([]Stmt{stmt.body}, env)
+		i.memory.releaseEnv(env)
+		if err != nil {
+			if errors.Is(err, errBreak) {
+				return nil
+			}
+			if !errors.Is(err, errContinue) {
+				return err
+			}
+		}
+	}
+}
+
+func (i *interpreter) visitSelectStmt(stmt SelectStmt) error {
+	operations := make([]selectOperation, 0, len(stmt.cases))
+	for _, selectCase := range stmt.cases {
+		var operation selectOperation
+		if selectCase.channel != nil {
+			v, err := i.evaluate(selectCase.channel)
+			if err != nil {
+				return err
+			}
+			channel, ok := v.(*LoxChannel)
+			if !ok {
+				return newRuntimeError(TypeError, selectCase.keyword, "%s is not a channel", loxString(v)).withValues(v)
+			}
+			operation.channel = channel
+		}
+		if selectCase.send {
+			v, err := i.evaluate(selectCase.value)
+			if err != nil {
+				return err
+			}
+			operation.send = true
+			operation.value = v
+		}
+		operations = append(operations, operation)
+	}
+	idx, value, err := selectChannels(i.budget, operations)
+	if err != nil {
+		return err
+	}
+	selectCase := stmt.cases[idx]
+	env := newEnvWithEnclosing(i.env)
+	if selectCase.name != nil {
+		env.Define(selectCase.name.Lexeme, value)
+	}
+	return i.executeBlock([]Stmt{selectCase.body}, env)
+}
+
+func (i *interpreter) visitTryStmt(stmt TryStmt) error {
+	err := i.execute(stmt.body)
+	// body 中 `return f()` 的尾调用要在这里执行，否则 f 中的错误会跳过 catch。
+	var returnValue Return
+	if errors.As(err, &returnValue) && returnValue.tailCall != nil {
+		v, callErr := returnValue.result(i)
+		if callErr == nil {
+			return NewReturn(v)
+		}
+		err = callErr
+	}
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		return err
+	}
+	env := newEnvWithEnclosing(i.env)
+	env.Define(stmt.name.Lexeme, runtimeErr)
+	return i.executeBlock([]Stmt{stmt.handler}, env)
+}
+
+func (i *interpreter) visitYieldStmt(stmt YieldStmt) error {
+	var value interface{}
+	if stmt.value != nil {
+		var err error
+		value, err = i.evaluate(stmt.value)
+		if err != nil {
+			return err
+		}
+	}
+	if i.generator == nil {
+		return newRuntimeError(StateError, stmt.keyword, "cannot yield outside of a generator")
+	}
+	return i.generator.yield(value)
+}
+
+func (i *interpreter) visitBreakStmt(stmt BreakStmt) error {
+	return errBreak
+}
+
+func (i *interpreter) visitContinueStmt(stmt ContinueStmt) error {
+	return errContinue
+}
+
 // if 语句存在一个问题： 如果两个 if 之后，出现了一个 else ，那么 else 属于哪个 if ？
 // 这里实际上是认为 else 跟最近的 if 搭配。
 // 不同的编程语言解决这个问题都不一样，实际操作很复杂。
@@ -481,7 +1268,7 @@ func (i *interpreter) visitAssignExpr(expr *AssignExpr) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	distance, ok := i.locals[expr]
+	distance, ok := i.locals.get(expr)
 	if ok {
 		if err := i.env.AssignAt(distance, expr.name, value); err != nil {
 			return nil, err
@@ -503,6 +1290,11 @@ func (i *interpreter) visitLogicalExpr(expr *LogicalExpr) (interface{}, error) {
 		if i.isTruthy(left) {
 			return left, nil
 		}
+	} else if expr.operator.Type == QUESTION_QUESTION {
+		// 跟 or 不一样，只有 nil 才会取右边的值，false 会被保留。
+		if left != nil {
+			return left, nil
+		}
 	} else {
 		if !i.isTruthy(left) {
 			return left, nil
@@ -516,25 +1308,100 @@ func (i *interpreter) visitLogicalExpr(expr *LogicalExpr) (interface{}, error) {
 }
 
 func (i *interpreter) visitCallExpr(expr *CallExpr) (interface{}, error) {
-	callee, err := i.evaluate(expr.callee)
+	callee, args, err := i.evaluateCall(expr)
 	if err != nil {
 		return nil, err
 	}
+	v, err := i.call(callee, args, expr.paren.line)
+	// native function 返回的 RuntimeError 不知道位置，使用调用的位置。
+	return v, atToken(err, expr.paren)
+}
+
+func (i *interpreter) Call(callee Callable, args []interface{}) (interface{}, error) {
+	return i.call(callee, args, 0)
+}
+
+// call 记录调用的深度，超过限制的时候返回 StackOverflow 错误。
+func (i *interpreter) call(callee Callable, args []interface{}, line int) (interface{}, error) {
+	if err := i.budget.step(); err != nil {
+		return nil, err
+	}
+	if i.maxCallDepth > 0 && len(i.frames) >= i.maxCallDepth {
+		frames := []string{callFrame{callee: callee, line: line}.String()}
+		for idx := len(i.frames) - 1; idx >= 0 && len(frames) < stackOverflowFrames; idx-- {
+			frames = append(frames, i.frames[idx].String())
+		}
+		return nil, newStackOverflowError(i.maxCallDepth, frames)
+	}
+	i.frames = append(i.frames, callFrame{callee: callee, line: line})
+	defer func() {
+		i.frames = i.frames[:len(i.frames)-1]
+	}()
+	return callee.Call(i, args)
+}
+
+// visitAwaitExpr 在 async function 中挂起当前的 coroutine，在顶层代码中执行 event loop 直到 promise 完成。
+func (i *interpreter) visitAwaitExpr(expr *AwaitExpr) (interface{}, error) {
+	value, err := i.evaluate(expr.value)
+	if err != nil {
+		return nil, err
+	}
+	promise, ok := value.(*LoxPromise)
+	if !ok {
+		return value, nil
+	}
+	if i.coroutine != nil {
+		return i.coroutine.await(promise)
+	}
+	if err := i.loop.runUntil(promise.isSettled); err != nil {
+		return nil, err
+	}
+	if !promise.isSettled() {
+		return nil, newRuntimeError(StateError, expr.keyword, "%s can never be settled", promise)
+	}
+	return promise.result()
+}
+
+// spawn 的 callee 和参数在当前 task 中求值，这样参数中的错误会在 spawn 的地方报出来。
+func (i *interpreter) visitSpawnExpr(expr *SpawnExpr) (interface{}, error) {
+	callee, args, err := i.evaluateCall(expr.call)
+	if err != nil {
+		return nil, err
+	}
+	return spawnTask(i, callee, args), nil
+}
+
+// evaluateCall 对 callee 和参数求值，并把具名参数合并到位置参数中。
+func (i *interpreter) evaluateCall(expr *CallExpr) (Callable, []interface{}, error) {
+	callee, err := i.evaluate(expr.callee)
+	if err != nil {
+		return nil, nil, err
+	}
 	var argsList []interface{}
 	for _, args := range expr.args {
 		arg, err := i.evaluate(args)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		argsList = append(argsList, arg)
 	}
-	if v, ok := callee.(Callable); ok {
-		if len(argsList) != v.Arity() {
-			return nil, fmt.Errorf("callable: %s, Expected: %d arguments but got: %d", v, v.Arity(), len(argsList))
+	var namedArgs []namedArgument
+	for _, namedArg := range expr.namedArgs {
+		arg, err := i.evaluate(namedArg.value)
+		if err != nil {
+			return nil, nil, err
 		}
-		return v.Call(i, argsList)
+		namedArgs = append(namedArgs, namedArgument{name: namedArg.name, value: arg})
 	}
-	return nil, fmt.Errorf("%v is not callable", callee)
+	v, ok := callee.(Callable)
+	if !ok {
+		return nil, nil, newRuntimeError(TypeError, expr.paren, "%s is not callable", loxString(callee)).withValues(callee)
+	}
+	argsList, err = bindArguments(v, argsList, namedArgs)
+	if err != nil {
+		return nil, nil, atToken(err, expr.paren)
+	}
+	return v, argsList, nil
 }
 
 func (i *interpreter) visitFunctionStmt(stmt FunctionStmt) error {
@@ -544,6 +1411,24 @@ func (i *interpreter) visitFunctionStmt(stmt FunctionStmt) error {
 }
 
 func (i *interpreter) visitReturnStmt(stmt ReturnStmt) error {
+	if call, ok := stmt.value.(*CallExpr); ok {
+		callee, args, err := i.evaluateCall(call)
+		if err != nil {
+			return err
+		}
+		if function, ok := callee.(*LoxFunction); ok {
+			// 尾调用不经过 call，在这里算作一步，这样无限的尾递归也会被 budget 停下来。
+			if err := i.budget.step(); err != nil {
+				return err
+			}
+			return Return{tailCall: &tailCall{function: function, args: args}}
+		}
+		value, err := i.call(callee, args, call.paren.line)
+		if err != nil {
+			return err
+		}
+		return NewReturn(value)
+	}
 	var value interface{}
 	if stmt.value != nil {
 		var err error
@@ -0,0 +1,2167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// execLox 按 run() 的流程执行一段源码，返回执行完之后的 interpreter，方便检查 globals。
+func execLox(source string, opts ...interpreterOption) (*interpreter, error) {
+	return execLoxContext(context.Background(), source, opts...)
+}
+
+// execLoxContext 跟 execLox 一样，ctx 结束的时候停止执行。
+func execLoxContext(ctx context.Context, source string, opts ...interpreterOption) (*interpreter, error) {
+	tokens, err := newScanner(source).scanTokens()
+	if err != nil {
+		return nil, err
+	}
+	stmts, err := newParser(tokens).parse()
+	if err != nil {
+		return nil, err
+	}
+	intp := newInterpreter(opts...)
+	if err := newResolver(intp).resolveStmts(stmts); err != nil {
+		return intp, err
+	}
+	return intp, intp.interpret(ctx, stmts)
+}
+
+func runLox(t *testing.T, source string) *interpreter {
+	t.Helper()
+	intp, err := execLox(source)
+	if err != nil {
+		t.Fatalf("run %q failed: %v", source, err)
+	}
+	return intp
+}
+
+func loxGlobal(t *testing.T, intp *interpreter, name string) interface{} {
+	t.Helper()
+	v, err := intp.globals.Get(newToken(IDENTIFIER, name, nil, 0))
+	if err != nil {
+		t.Fatalf("get global %s failed: %v", name, err)
+	}
+	return v
+}
+
+func Test_interpreter_conditionalAndNilOperators(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   interface{}
+	}{
+		{"ternary then", `var r = 1 < 2 ? "yes" : "no";`, "yes"},
+		{"ternary else", `var r = nil ? "yes" : "no";`, "no"},
+		{"ternary right assoc", `var r = false ? 1 : true ? 2 : 3;`, 2.0},
+		{"coalesce nil", `var r = nil ?? "default";`, "default"},
+		{"coalesce keeps false", `var r = false ?? "default";`, false},
+		{"coalesce lower than or", `var r = nil ?? nil or "b";`, "b"},
+		{"optional get on nil", `var a; var r = a?.b.c;`, nil},
+		{"optional call on nil", `var a; var r = a?.method() ?? "none";`, "none"},
+		{"optional get on instance", `class A { init() { this.b = 3; } } var r = A()?.b;`, 3.0},
+		{"optional method on instance", `class A { m() { return "m"; } } var r = A()?.m();`, "m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, tt.source)
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_interpreter_optionalChainIsNotAssignable(t *testing.T) {
+	if _, err := execLox(`var a; a?.b = 1;`); err == nil {
+		t.Error("expect invalid assign target error")
+	}
+}
+
+func Test_interpreter_matchStmt(t *testing.T) {
+	classes := `
+class Shape {}
+class Point < Shape {
+  init(x, y) {
+    this.x = x;
+    this.y = y;
+  }
+}
+class Circle < Shape {
+  init(r) {
+    this.r = r;
+  }
+}
+class Square < Shape {
+  init(side) {
+    this.side = side;
+  }
+  area { return this.side * this.side; }
+}
+fun describe(v) {
+  var r;
+  match (v) {
+    case 1, 2 => r = "small";
+    case -1 => r = "negative";
+    case "x" => r = "x";
+    case Point(x: 0, y) => r = "on y axis at " + y;
+    case Point(x, y) if x == y => r = "diagonal";
+    case Point(x, y) => r = "point";
+    case Square(area: 4) => r = "square of area 4";
+    case Shape() => r = "shape";
+    case nil => r = "nil";
+    case _ => r = "other";
+  }
+  return r;
+}
+`
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"1", "small"},
+		{"2", "small"},
+		{"-1", "negative"},
+		{`"x"`, "x"},
+		{`Point(0, "7")`, "on y axis at 7"},
+		{"Point(3, 3)", "diagonal"},
+		{"Point(3, 4)", "point"},
+		{"Circle(1)", "shape"},
+		{"Square(2)", "square of area 4"},
+		{"Square(3)", "shape"},
+		{"nil", "nil"},
+		{"true", "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			intp := runLox(t, classes+"var r = describe("+tt.arg+");")
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_interpreter_matchStmtErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"no case matched", `match (3) { case 1 => print 1; }`, "MatchError at line 1: no case matched value 3"},
+		{"binding in alternatives", `match (3) { case 1, x => print x; }`, "cannot bind names in a case with alternative patterns"},
+		{"duplicate binding", `class P {} match (3) { case P(x, y: x) => print x; }`, "already a variable with this name x in this scope"},
+		{"binding out of scope", `fun f() { match (3) { case x => print x; } return x; } f();`, "NameError at line 1: undefined variable x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_parameters(t *testing.T) {
+	fns := `
+fun f(a, b = 10, c = a + b, ...rest) {
+  return a + b + c + rest.length() * 1000;
+}
+class Point {
+  init(x, y = 0) {
+    this.x = x;
+    this.y = y;
+  }
+}
+`
+	tests := []struct {
+		name string
+		expr string
+		want interface{}
+	}{
+		{"defaults", "f(1)", 22.0},
+		{"default refers to earlier param", "f(1, 2)", 6.0},
+		{"all positional", "f(1, 2, 3)", 6.0},
+		{"rest", "f(1, 2, 3, 4, 5)", 2006.0},
+		{"named", "f(1, c: 5)", 16.0},
+		{"named skips default", "f(a: 1, b: 2)", 6.0},
+		{"named initializer", "Point(y: 2, x: 1).y", 2.0},
+		{"initializer default", "Point(1).y", 0.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, fns+"var r = "+tt.expr+";")
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_interpreter_parameterErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"missing", "fun f(a, b) {} f(b: 1);", "missing arguments for parameters 'a'"},
+		{"too many", "fun f(a, b = 1) {} f(1, 2, 3);", "expected 1 to 2 arguments but got 3"},
+		{"unexpected named", "fun f(a) {} f(1, c: 2);", "unexpected named argument 'c'"},
+		{"named twice", "fun f(a) {} f(1, a: 2);", "got multiple values for parameter 'a'"},
+		{"native named", "clock(a: 1);", "does not accept named arguments"},
+		{"no init named", "class A {} A(a: 1);", "unexpected named argument 'a'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_staticMembers(t *testing.T) {
+	classes := `
+class Math {
+  static PI = 3;
+  static TAU = this.PI * 2;
+  static square(n) {
+    return n * n;
+  }
+  static name() {
+    return this;
+  }
+  static create() {
+    return this();
+  }
+}
+class MoreMath < Math {
+  static cube(n) {
+    return n * this.square(n);
+  }
+}
+`
+	tests := []struct {
+		name string
+		expr string
+		want interface{}
+	}{
+		{"static method", "Math.square(3)", 9.0},
+		{"static field", "Math.PI", 3.0},
+		{"static field refers to this", "Math.TAU", 6.0},
+		{"inherited static method", "MoreMath.square(4)", 16.0},
+		{"inherited static field", "MoreMath.PI", 3.0},
+		{"this is subclass", "MoreMath.cube(2)", 8.0},
+		{"this is receiver class", "MoreMath.name() == MoreMath", true},
+		{"factory", "MoreMath.create() != nil", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, classes+"var r = "+tt.expr+";")
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	intp := runLox(t, classes+"MoreMath.PI = 4; var r = Math.PI; var s = MoreMath.PI;")
+	if got := loxGlobal(t, intp, "r"); got != 3.0 {
+		t.Errorf("assigning to subclass changed superclass field: %v", got)
+	}
+	if got := loxGlobal(t, intp, "s"); got != 4.0 {
+		t.Errorf("got %v, want 4", got)
+	}
+}
+
+func Test_interpreter_staticMemberErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"static init", "class A { static init() {} }"},
+		{"super in static method", "class A {} class B < A { static m() { return super.m(); } }"},
+		{"static method on instance", "class A { static m() {} } A().m();"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := execLox(tt.source); err == nil {
+				t.Error("expect error")
+			}
+		})
+	}
+}
+
+func Test_interpreter_accessors(t *testing.T) {
+	classes := `
+class Rect {
+  init(w, h) {
+    this.w = w;
+    this.h = h;
+  }
+  area {
+    return this.w * this.h;
+  }
+  width {
+    return this.w;
+  }
+  set width(v) {
+    if (v < 0) v = 0;
+    this.w = v;
+  }
+}
+class Square < Rect {
+  init(size) {
+    super.init(size, size);
+  }
+  doubleArea {
+    return super.area * 2;
+  }
+}
+`
+	tests := []struct {
+		name   string
+		source string
+		want   interface{}
+	}{
+		{"getter", "var r = Rect(2, 3).area;", 6.0},
+		{"setter", "var rect = Rect(2, 3); rect.width = 5; var r = rect.area;", 15.0},
+		{"setter validates", "var rect = Rect(2, 3); rect.width = -1; var r = rect.width;", 0.0},
+		{"inherited getter", "var r = Square(3).area;", 9.0},
+		{"inherited setter", "var s = Square(3); s.width = 1; var r = s.area;", 3.0},
+		{"super getter", "var r = Square(3).doubleArea;", 18.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, classes+tt.source)
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	_, err := execLox(classes + "Rect(1, 2).area = 3;")
+	if err == nil || !strings.Contains(err.Error(), "cannot assign to getter-only property area") {
+		t.Errorf("got err %v, want getter-only error", err)
+	}
+}
+
+func Test_interpreter_privateMembers(t *testing.T) {
+	classes := `
+class Counter {
+  #count = 0;
+  #step;
+  init(step) {
+    this.#step = step;
+  }
+  increment() {
+    this.#count = this.#count + this.#bump();
+    return this.#count;
+  }
+  #bump() {
+    return this.#step;
+  }
+  same(other) {
+    return this.#count == other.#count;
+  }
+}
+class Sub < Counter {
+  #count = 100;
+  subCount() {
+    return this.#count;
+  }
+}
+`
+	tests := []struct {
+		name   string
+		source string
+		want   interface{}
+	}{
+		{"private field and method", "var c = Counter(2); c.increment(); var r = c.increment();", 4.0},
+		{"other instance of same class", "var r = Counter(1).same(Counter(2));", true},
+		{"subclass has its own slot", "var s = Sub(1); s.increment(); var r = s.subCount();", 100.0},
+		{"superclass slot unaffected", "var s = Sub(1); var r = s.increment();", 1.0},
+		{"public field with same name", "var c = Counter(1); c.count = 9; var r = c.increment();", 1.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, classes+tt.source)
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"outside class", "Counter(1).#count;", "private member can only be accessed inside a class body"},
+		{"subclass body", "class S < Counter { m() { return this.#step; } }", "private member #step is not declared in the enclosing class"},
+		{"assign outside class", "Counter(1).#count = 3;", "private member can only be accessed inside a class body"},
+		{"other class at runtime", "class Other { #count; m() {} } Counter(1).same(Other());", "private member #count of class Counter is not accessible"},
+		{"assign private method", "class A { #m() {} n() { this.#m = 1; } } A().n();", "cannot assign to private method #m of class A"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(classes + tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_traits(t *testing.T) {
+	decls := `
+trait Greeter {
+  greet() {
+    return "hello " + this.name;
+  }
+  describe() {
+    return "greeter";
+  }
+}
+trait Walker {
+  walk() {
+    return this.name + " walks";
+  }
+  describe() {
+    return "walker";
+  }
+}
+class Animal {
+  init(name) {
+    this.name = name;
+  }
+  describe() {
+    return "animal";
+  }
+  speak() {
+    return "...";
+  }
+}
+trait Loud {
+  speak() {
+    return super.speak() + "!";
+  }
+}
+class Dog < Animal with Greeter, Walker {
+  describe() {
+    return "dog";
+  }
+}
+class Puppy < Animal with Loud {}
+class Robot with Greeter {
+  init() {
+    this.name = "robot";
+  }
+}
+`
+	tests := []struct {
+		name string
+		expr string
+		want interface{}
+	}{
+		{"trait method", `Dog("rex").greet()`, "hello rex"},
+		{"second trait method", `Dog("rex").walk()`, "rex walks"},
+		{"class overrides conflicting trait methods", `Dog("rex").describe()`, "dog"},
+		{"super resolves through real superclass", `Puppy("bo").speak()`, "...!"},
+		{"trait without superclass", `Robot().greet()`, "hello robot"},
+		{"superclass methods still inherited", `Puppy("bo").describe()`, "animal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, decls+"var r = "+tt.expr+";")
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"resolver conflict", "class Both with Greeter, Walker {}", "method describe is provided by both trait Greeter and trait Walker"},
+		{"runtime conflict", "fun make(a, b) { class C with a, b {} } make(Greeter, Walker);", "method describe is provided by both trait Greeter and trait Walker"},
+		{"not a trait", "class C with Animal {}", "Animal is not a trait"},
+		{"trait init", "trait T { init() {} }", "trait cannot declare an initializer"},
+		{"super without superclass", "trait T { m() { return super.m(); } } class C with T {} C().m();", "cannot use 'super' in a class with no super class"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(decls + tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_abstractAndInterfaces(t *testing.T) {
+	decls := `
+interface Shape {
+  area();
+  scale(factor);
+}
+class Base implements Shape {
+  abstract area();
+  abstract scale(factor);
+  double() {
+    return this.area() * 2;
+  }
+}
+class Square < Base {
+  init(side) {
+    this.side = side;
+  }
+  area() {
+    return this.side * this.side;
+  }
+  scale(factor, origin = nil) {
+    return Square(this.side * factor);
+  }
+}
+trait Named {
+  name() {
+    return "named";
+  }
+}
+class Thing with Named {
+  abstract name();
+}
+`
+	tests := []struct {
+		name string
+		expr string
+		want interface{}
+	}{
+		{"abstract method implemented in subclass", `Square(3).double()`, 18.0},
+		{"default parameter still conforms", `Square(2).scale(2).area()`, 16.0},
+		{"trait implements abstract method", `Thing().name()`, "named"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, decls+"var r = "+tt.expr+";")
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"instantiate abstract class", "Base();", "cannot instantiate abstract class Base, unimplemented abstract methods: area, scale"},
+		{"partially implemented", "class Half < Base { area() { return 1; } } Half();", "cannot instantiate abstract class Half, unimplemented abstract methods: scale"},
+		{"missing interface method", "class Circle implements Shape { area() { return 1; } }", "class Circle does not conform to its interfaces: missing method scale of interface Shape"},
+		{"arity mismatch", "class Circle implements Shape { area(a) { return 1; } scale() {} }", "method area accepts 1 arguments but interface Shape requires 0; method scale accepts 0 arguments"},
+		{"not an interface", "class C implements Square {}", "Square is not an interface"},
+		{"super calls abstract method", "class Sub < Base { area() { return super.area(); } scale(f) {} } Sub().area();", "cannot call abstract method area"},
+		{"abstract initializer", "class C { abstract init(); }", "initializer cannot be abstract"},
+		{"abstract and concrete", "class C { abstract m(); m() {} }", "method m is declared both abstract and concrete"},
+		{"duplicate interface method", "interface I { m(); m(a); }", "method m is already declared in interface I"},
+		{"interface method with body", "interface I { m() {} }", "expect ';' after interface method declaration"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(decls + tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_operatorOverloading(t *testing.T) {
+	decls := `
+class Vector {
+  init(x, y) {
+    this.x = x;
+    this.y = y;
+  }
+  __add(other) {
+    return Vector(this.x + other.x, this.y + other.y);
+  }
+  __sub(other) {
+    return Vector(this.x - other.x, this.y - other.y);
+  }
+  __mul(k) {
+    return Vector(this.x * k, this.y * k);
+  }
+  __rmul(k) {
+    return this * k;
+  }
+  __neg() {
+    return Vector(-this.x, -this.y);
+  }
+  __eq(other) {
+    return this.x == other.x and this.y == other.y;
+  }
+  __index(i) {
+    if (i == 0) return this.x;
+    return this.y;
+  }
+  __str() {
+    return "vector";
+  }
+}
+class Money {
+  init(cents) {
+    this.cents = cents;
+  }
+  __lt(other) {
+    return this.cents < other;
+  }
+  __gt(other) {
+    return this.cents > other;
+  }
+}
+`
+	tests := []struct {
+		name string
+		expr string
+		want interface{}
+	}{
+		{"add", `(Vector(1, 2) + Vector(3, 4)).x`, 4.0},
+		{"sub", `(Vector(1, 2) - Vector(3, 4)).y`, -2.0},
+		{"mul", `(Vector(1, 2) * 3).y`, 6.0},
+		{"reflected mul", `(3 * Vector(1, 2)).x`, 3.0},
+		{"neg", `(-Vector(1, 2)).y`, -2.0},
+		{"eq", `Vector(1, 2) == Vector(1, 2)`, true},
+		{"not eq", `Vector(1, 2) != Vector(1, 3)`, true},
+		{"identity without eq", `Money(5) == Money(5)`, false},
+		{"index", `Vector(1, 2)[1]`, 2.0},
+		{"lt", `Money(5) < 10`, true},
+		{"reflected lt", `10 < Money(5)`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, decls+"var r = "+tt.expr+";")
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("str", func(t *testing.T) {
+		intp := runLox(t, decls+"var r = Vector(1, 2);")
+		got, err := intp.stringify(loxGlobal(t, intp, "r"))
+		if err != nil || got != "vector" {
+			t.Errorf("got %q, %v, want %q", got, err, "vector")
+		}
+	})
+
+	t.Run("match uses eq", func(t *testing.T) {
+		intp := runLox(t, `
+class Any {
+  __eq(other) {
+    return true;
+  }
+}
+var r;
+match (Any()) {
+  case 1 => r = "one";
+}`)
+		if got := loxGlobal(t, intp, "r"); got != "one" {
+			t.Errorf("got %v, want %v", got, "one")
+		}
+	})
+
+	errTests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"no operator method", "Money(1) - 1;", "is not a number"},
+		{"not indexable", "Money(1)[0];", "is not indexable"},
+		{"str must return string", "class S { __str() { return 1; } } print S();", "__str of <S instance> must return a string"},
+		{"operator arity", "class A { __add() { return 1; } } A() + 1;", "expected 0 arguments but got 1"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(decls + tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_forIn(t *testing.T) {
+	decls := `
+class Countdown {
+  init(from) {
+    this.from = from;
+  }
+  iterator() {
+    return CountdownIterator(this.from);
+  }
+}
+class CountdownIterator {
+  init(n) {
+    this.n = n;
+  }
+  hasNext() {
+    return this.n > 0;
+  }
+  next() {
+    this.n = this.n - 1;
+    return this.n + 1;
+  }
+}
+class Wrapper {
+  init(list) {
+    this.list = list;
+  }
+  iterator() {
+    return this.list.iterator();
+  }
+}
+fun collect(iterable) {
+  var out = [];
+  for (x in iterable) out.push(x);
+  return out;
+}
+`
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"list", `var r = collect([1, 2, 3]);`, "[1, 2, 3]"},
+		{"map keys in insertion order", `var r = collect({"b": 1, "a": 2, 3: nil});`, "[b, a, 3]"},
+		{"string", `var r = collect("héllo");`, "[h, é, l, l, o]"},
+		{"range end", `var r = collect(range(4));`, "[0, 1, 2, 3]"},
+		{"range start end", `var r = collect(range(2, 5));`, "[2, 3, 4]"},
+		{"range negative step", `var r = collect(range(5, 0, -2));`, "[5, 3, 1]"},
+		{"custom iterator", `var r = collect(Countdown(3));`, "[3, 2, 1]"},
+		{"iterator returns builtin", `var r = collect(Wrapper([7, 8]));`, "[7, 8]"},
+		{"break", `var r = []; for (var x in range(10)) { if (x == 3) break; r.push(x); }`, "[0, 1, 2]"},
+		{"continue", `var r = []; for (x in range(5)) { if (x == 1 or x == 3) continue; r.push(x); }`, "[0, 2, 4]"},
+		{"continue runs increment", `var r = []; for (var i = 0; i < 5; i = i + 1) { if (i == 2) continue; r.push(i); }`, "[0, 1, 3, 4]"},
+		{"break in while", `var r = []; var i = 0; while (true) { i = i + 1; if (i > 3) break; r.push(i); }`, "[1, 2, 3]"},
+		{"nested break", `var r = []; for (a in range(3)) { for (b in range(3)) { if (b == 1) break; r.push(a); } }`, "[0, 1, 2]"},
+		{"fresh binding per iteration", `var fs = []; for (x in [1, 2]) { fun f() { return x; } fs.push(f); } var r = [fs.get(0)(), fs.get(1)()];`, "[1, 2]"},
+		{"push while iterating list", `var r = [1]; for (x in r) { if (x < 3) r.push(x + 1); }`, "[1, 2, 3]"},
+		{"index", `var m = {"k": [10, 20]}; var r = [m["k"][1], m.get("x", 0), m.length()];`, "[20, 0, 1]"},
+		{"range is lazy", `var r = []; for (x in range(0, 1000000000000)) { if (x == 2) break; r.push(x); }`, "[0, 1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, decls+tt.source)
+			if got := loxString(loxGlobal(t, intp, "r")); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"not iterable", "for (x in 1) {}", "1 is not iterable"},
+		{"instance without iterator", "class A {} for (x in A()) {}", "is not iterable, it has no iterator() method"},
+		{"break outside loop", "break;", "cannot break outside of a loop"},
+		{"continue in function in loop", "while (true) { fun f() { continue; } }", "cannot continue outside of a loop"},
+		{"range step zero", "range(0, 1, 0);", "range step cannot be zero"},
+		{"missing key", `var m = {}; m["x"];`, "key x not found in map"},
+		{"list index out of range", "[1][1];", "list index 1 out of range"},
+		{"unhashable key", `var m = {}; m.set(nan, 1);`, "cannot be used as a map key"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(decls + tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_generators(t *testing.T) {
+	decls := `
+fun* naturals() {
+  var n = 0;
+  while (true) {
+    yield n;
+    n = n + 1;
+  }
+}
+fun* take(n, iterable) {
+  if (n <= 0) return;
+  for (x in iterable) {
+    yield x;
+    n = n - 1;
+    if (n == 0) return;
+  }
+}
+class Tree {
+  init(left, value, right) {
+    this.left = left;
+    this.value = value;
+    this.right = right;
+  }
+  *walk() {
+    if (this.left != nil) for (x in this.left.walk()) yield x;
+    yield this.value;
+    if (this.right != nil) for (x in this.right.walk()) yield x;
+  }
+  iterator() {
+    return this.walk();
+  }
+}
+fun collect(iterable) {
+  var out = [];
+  for (x in iterable) out.push(x);
+  return out;
+}
+`
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"infinite generator is lazy", `var r = collect(take(3, naturals()));`, "[0, 1, 2]"},
+		{"tree walker", `var t = Tree(Tree(nil, 1, nil), 2, Tree(nil, 3, Tree(nil, 4, nil))); var r = collect(t);`, "[1, 2, 3, 4]"},
+		{"manual protocol", `var g = naturals(); g.next(); var r = [g.hasNext(), g.next(), g.next()];`, "[true, 1, 2]"},
+		{"finished generator", `fun* one() { yield 1; } var g = one(); var r = [g.next(), g.hasNext()];`, "[1, false]"},
+		{"body runs lazily", `var log = []; fun* g() { log.push("start"); yield 1; } var it = g(); var r = [log.length()]; it.next(); r.push(log.length());`, "[0, 1]"},
+		{"arguments bound at call", `fun* echo(a, b = a + 1) { yield a; yield b; } var r = collect(echo(1));`, "[1, 2]"},
+		{"close", `var g = naturals(); g.next(); g.close(); var r = [g.hasNext()];`, "[false]"},
+		{"yield nil", `fun* g() { yield; } var r = collect(g());`, "[nil]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, decls+tt.source)
+			if got := loxString(loxGlobal(t, intp, "r")); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"yield outside generator", "fun f() { yield 1; }", "cannot yield outside of a generator"},
+		{"yield in nested function", "fun* g() { fun f() { yield 1; } }", "cannot yield outside of a generator"},
+		{"return value", "fun* g() { return 1; }", "cannot return a value from a generator"},
+		{"generator initializer", "class A { *init() {} }", "initializer cannot be a generator"},
+		{"error in body", "fun* g() { yield 1; yield nil + 1; } collect(g());", "are not the same type"},
+		{"exhausted", "fun* g() {} g().next();", "generator g is exhausted"},
+		{"reentrant", "var gen; fun* g() { gen.next(); yield 1; } gen = g(); gen.next();", "generator g is already running"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(decls + tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_abandonedGeneratorsDoNotLeak(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"unreachable generators", `
+fun* naturals() {
+  var n = 0;
+  while (true) {
+    yield n;
+    n = n + 1;
+  }
+}
+fun firstTwo() {
+  var g = naturals();
+  g.next();
+  return g.next();
+}
+for (i in range(100)) firstTwo();
+`},
+		// body 引用了 generator 自己，generator 永远不会被回收，只能在 interpret 结束的时候关闭。
+		{"self referencing generators", `
+fun selfish() {
+  var g;
+  fun* gen() {
+    var me = g;
+    while (true) yield me;
+  }
+  g = gen();
+  g.next();
+}
+for (i in range(100)) selfish();
+`},
+		{"generators left open by an error", `
+fun* naturals() {
+  var n = 0;
+  while (true) {
+    yield n;
+    n = n + 1;
+  }
+}
+var g = naturals();
+g.next();
+nil + 1;
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			execLox(tt.source)
+			deadline := time.Now().Add(5 * time.Second)
+			for runtime.NumGoroutine() > before {
+				if time.Now().After(deadline) {
+					t.Fatalf("got %d goroutines, want at most %d", runtime.NumGoroutine(), before)
+				}
+				runtime.GC()
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// generator 被多个 task 同时驱动的时候，每个值只会被一个 task 拿到，其它的 task 得到 already running 的错误。
+func Test_interpreter_generatorSharedByTasks(t *testing.T) {
+	intp := runLox(t, `
+fun* naturals() {
+  var n = 0;
+  while (true) {
+    yield n;
+    n = n + 1;
+  }
+}
+`)
+	// interpret 结束的时候会关闭没有结束的 generator，所以在这之后再创建。
+	v, err := loxGlobal(t, intp, "naturals").(*LoxFunction).Call(intp, nil)
+	if err != nil {
+		t.Fatalf("call naturals failed: %v", err)
+	}
+	g := v.(*LoxGenerator)
+	var mu sync.Mutex
+	seen := map[interface{}]bool{}
+	var wg sync.WaitGroup
+	for task := 0; task < 8; task++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				if _, err := g.HasNext(); err != nil && !strings.Contains(err.Error(), "already running") {
+					t.Errorf("hasNext failed: %v", err)
+				}
+				v, err := g.Next()
+				if err != nil {
+					if !strings.Contains(err.Error(), "already running") {
+						t.Errorf("next failed: %v", err)
+					}
+					continue
+				}
+				mu.Lock()
+				if seen[v] {
+					t.Errorf("value %v returned twice", v)
+				}
+				seen[v] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if err := g.Close(); err != nil {
+		t.Errorf("close failed: %v", err)
+	}
+}
+
+// fork 出来的 interpreter 跟原来的 interpreter 使用同样的 I/O 状态。
+func Test_interpreter_forkSharesIO(t *testing.T) {
+	lookup := func(string) (string, bool) { return "", false }
+	intp := newInterpreter(withFSRoot(t.TempDir()), withStdin(strings.NewReader("")), withLookupEnv(lookup), withArgs([]string{"a"}))
+	fork := intp.Fork().(*interpreter)
+	if fork.fs != intp.fs || fork.stdin != intp.stdin || fork.stdout != intp.stdout || len(fork.args) != 1 || fork.args[0] != "a" {
+		t.Errorf("got fork fs %v, stdin %v, stdout %v, args %v, want the parent's", fork.fs, fork.stdin, fork.stdout, fork.args)
+	}
+	if reflect.ValueOf(fork.lookupEnv).Pointer() != reflect.ValueOf(intp.lookupEnv).Pointer() {
+		t.Error("got a different lookupEnv in the fork")
+	}
+}
+
+func Test_interpreter_concurrency(t *testing.T) {
+	decls := `
+fun square(x) {
+  return x * x;
+}
+fun producer(ch, n) {
+  for (i in range(n)) ch.send(i);
+  ch.close();
+}
+`
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"join returns result", `var r = [spawn square(3), spawn square(4)]; r = [r.get(0).join(), r.get(1).join()];`, "[9, 16]"},
+		{"channel between tasks", `var ch = channel(); spawn producer(ch, 4); var r = []; for (x in ch) r.push(x);`, "[0, 1, 2, 3]"},
+		{"buffered channel", `var ch = channel(2); ch.send(1); ch.send(nil); ch.close(); var r = [ch.receive(), ch.receive(), ch.receive()];`, "[1, nil, nil]"},
+		{"select receive binding", `var ch = channel(1); ch.send("hi"); var r; select { case v = ch.receive() => r = v; case _ => r = "none"; }`, "hi"},
+		{"select default", `var ch = channel(); var r; select { case v = ch.receive() => r = v; case _ => r = "none"; }`, "none"},
+		{"select send", `var ch = channel(1); var r; select { case ch.send(5) => r = ch.receive(); }`, "5"},
+		{"select closed channel", `var ch = channel(); ch.close(); var r = 1; select { case v = ch.receive() => r = v; }`, "nil"},
+		{"select waits for task", `var a = channel(); var b = channel(); spawn producer(b, 1); var r; select { case v = a.receive() => r = "a"; case v = b.receive() => r = v; }`, "0"},
+		{"shared list", `var r = []; fun work(n) { for (i in range(n)) r.push(i); } var tasks = []; for (i in range(8)) tasks.push(spawn work(50)); for (t in tasks) t.join(); r = r.length();`, "400"},
+		{"shared instance and globals", `class Box {} var box = Box(); var last; fun work(i) { box.value = i; last = i; return box.value; } var tasks = []; for (i in range(8)) tasks.push(spawn work(i)); for (t in tasks) t.join(); var r = box.value != nil and last != nil;`, "true"},
+		{"closures in tasks", `fun counter() { var n = 0; fun inc() { n = n + 1; return n; } return inc; } var inc = counter(); var tasks = []; for (i in range(4)) tasks.push(spawn inc()); for (t in tasks) t.join(); var r = inc() > 0;`, "true"},
+		{"generator in task", `fun* gen() { yield 1; yield 2; } fun sum() { var s = 0; for (x in gen()) s = s + x; return s; } var r = (spawn sum()).join();`, "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, decls+tt.source)
+			if got := loxString(loxGlobal(t, intp, "r")); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"error surfaces on join", "fun fail() { return nil + 1; } (spawn fail()).join();", "are not the same type"},
+		{"spawn needs a call", "spawn square;", "expect a call after 'spawn'"},
+		{"send on closed", "var ch = channel(1); ch.close(); ch.send(1);", "send on closed channel"},
+		{"close twice", "var ch = channel(); ch.close(); ch.close();", "close of closed channel"},
+		{"select on non channel", "select { case v = square.receive() => nil; }", "is not a channel"},
+		{"select unsupported op", "var ch = channel(); select { case ch.peek() => nil; }", "expect 'ch.receive()' or 'ch.send(value)' in select case"},
+		{"two defaults", "select { case _ => nil; case _ => nil; }", "select cannot have more than one default case"},
+		{"bad capacity", "channel(-1);", "channel capacity must be a non-negative integer"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(decls + tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_asyncAwait(t *testing.T) {
+	decls := `
+var log = [];
+fun note(s) { log.push(s); }
+async fun double(x) { return x * 2; }
+async fun worker(name, ms) {
+  note(name + " start");
+  await sleep(ms);
+  note(name + " end");
+  return name;
+}
+`
+	start := time.Unix(0, 0)
+	tests := []struct {
+		name    string
+		source  string
+		wantLog string
+		// event loop 结束之后 fake clock 走过的时间
+		wantElapsed time.Duration
+	}{
+		{"timers fire in deadline order", `fun a() { note("a"); } fun b() { note("b"); } fun c() { note("c"); } setTimeout(a, 30); setTimeout(b, 10); setTimeout(c, 10);`, "[b, c, a]", 30 * time.Millisecond},
+		{"async body runs until first await", `worker("a", 20); worker("b", 10); note("main");`, "[a start, b start, main, b end, a end]", 20 * time.Millisecond},
+		{"top level await", `note(await double(21)); note(await 5);`, "[42, 5]", 0},
+		{"await chains", `async fun sum() { return await double(1) + await double(2); } note(await sum());`, "[6]", 0},
+		{"await timer result", `async fun both() { var a = worker("a", 10); var b = worker("b", 5); return [await a, await b]; } note(await both());`, "[a start, b start, b end, a end, [a, b]]", 10 * time.Millisecond},
+		{"interval until cleared", `var n = 0; var id; fun tick() { n = n + 1; note(n); if (n == 3) clearTimer(id); } id = setInterval(tick, 5);`, "[1, 2, 3]", 15 * time.Millisecond},
+		{"async method", `class C { async wait(ms) { await sleep(ms); return this; } } var c = C(); note(await c.wait(7) == c);`, "[true]", 7 * time.Millisecond},
+		{"cleared timeout never fires", `fun late() { note("late"); } var id = setTimeout(late, 50); fun cancel() { clearTimer(id); } setTimeout(cancel, 1);`, "[]", time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(start)
+			intp, err := execLox(decls+tt.source, withClock(clock))
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := loxString(loxGlobal(t, intp, "log")); got != tt.wantLog {
+				t.Errorf("got log %v, want %v", got, tt.wantLog)
+			}
+			if got := clock.Now().Sub(start); got != tt.wantElapsed {
+				t.Errorf("got elapsed %v, want %v", got, tt.wantElapsed)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"await outside async function", "fun f() { await sleep(1); }", "cannot await outside of an async function"},
+		{"async initializer", "class C { async init() {} }", "initializer cannot be async"},
+		{"rejection surfaces on await", "async fun bad() { await sleep(1); return nil + 1; } await bad();", "are not the same type"},
+		{"unhandled rejection", "async fun bad() { return nil + 1; } bad();", "unhandled error in bad"},
+		{"error in timer callback", "fun fail() { return nil + 1; } setTimeout(fail, 1);", "are not the same type"},
+		{"promise awaiting itself", "var p; async fun self() { await sleep(1); await p; } p = self(); await p;", "can never be settled"},
+		{"bad delay", "sleep(-1);", "sleep delay must be a non-negative number"},
+		{"callback with parameters", "setTimeout(double, 1);", "setTimeout expects a function without parameters"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(decls+tt.source, withClock(newFakeClock(start)))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_suspendedCoroutinesDoNotLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+	_, err := execLox(`
+async fun waitForever() {
+  await sleep(60000);
+}
+for (i in range(10)) waitForever();
+nil + 1;
+`, withClock(newFakeClock(time.Unix(0, 0))))
+	if err == nil {
+		t.Fatal("expect error")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d goroutines, want at most %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_interpreter_tailCalls(t *testing.T) {
+	// 没有尾调用优化的时候，一百万层的递归会远远超过这里的栈大小限制。
+	defer debug.SetMaxStack(debug.SetMaxStack(16 << 20))
+	tests := []struct {
+		name   string
+		source string
+		want   interface{}
+	}{
+		{"deep self recursion", `fun count(n, acc) { if (n == 0) return acc; return count(n - 1, acc + 1); } var r = count(1000000, 0);`, 1000000.0},
+		{"mutual recursion", `fun isEven(n) { if (n == 0) return true; return isOdd(n - 1); } fun isOdd(n) { if (n == 0) return false; return isEven(n - 1); } var r = isEven(100001);`, false},
+		{"tail call into method", `class Counter { down(n) { if (n == 0) return "done"; return this.down(n - 1); } } var r = Counter().down(200000);`, "done"},
+		{"tail call to native", `fun make(n) { return range(n); } var r = 0; for (x in make(4)) r = r + x;`, 6.0},
+		{"tail call into initializer", `class P { init(x) { this.x = x; } } fun make(x) { return P(x); } var r = make(4).x;`, 4.0},
+		{"non tail call keeps result", `fun fact(n) { if (n <= 1) return 1; return n * fact(n - 1); } var r = fact(10);`, 3628800.0},
+		{"tail call from async", `async fun twice(x) { return count(x, x); } fun count(n, acc) { if (n == 0) return acc; return count(n - 1, acc + 1); } var r = await twice(5);`, 10.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, tt.source)
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_interpreter_callDepthLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		limit      int
+		wantFrames []string
+	}{
+		{"runaway recursion", "fun down(n) {\n  return 1 + down(n + 1);\n}\ndown(0);", 100, []string{"<function: down> (line 2)", "<function: down> (line 2)", "<function: down> (line 2)", "<function: down> (line 2)", "<function: down> (line 2)"}},
+		{"frames of different functions", "fun a() { return 1 + b(); }\nfun b() { return 1 + a(); }\na();", 3, []string{"<function: b> (line 1)", "<function: a> (line 2)", "<function: b> (line 1)", "<function: a> (line 3)"}},
+		{"operator method recursion", "class N { __add(other) { return this + other; } }\nN() + 1;", 10, []string{"<function: __add>", "<function: __add>", "<function: __add>", "<function: __add>", "<function: __add>"}},
+		{"getter recursion", "class A {\n  x { return 1 + this.x; }\n}\nprint A().x;", 5, []string{"<function: x>", "<function: x>", "<function: x>", "<function: x>", "<function: x>"}},
+		{"setter recursion", "class A {\n  set x(v) { this.x = v; }\n}\nA().x = 1;", 5, []string{"<function: x>", "<function: x>", "<function: x>", "<function: x>", "<function: x>"}},
+		{"super getter recursion", "class A {\n  x { return 1 + this.x; }\n}\nclass B < A {\n  x { return super.x; }\n}\nprint B().x;", 4, []string{"<function: x>", "<function: x> (line 5)", "<function: x>", "<function: x> (line 5)", "<function: x>"}},
+		{"regex callback recursion", "fun f(m) { return regex.replace(\"a\", \"a\", f); }\nf(nil);", 10, nil},
+		{"timer callback recursion", "fun f() { return 1 + f(); }\nsetTimeout(f, 0);", 10, nil},
+		{"default limit", "fun down(n) { return 1 + down(n + 1); } down(0);", defaultMaxCallDepth, nil},
+		{"default limit stops getter recursion", "class A { x { return 1 + this.x; } } print A().x;", defaultMaxCallDepth, nil},
+		{"default limit stops setter recursion", "class A { set x(v) { this.x = v; } } A().x = 1;", defaultMaxCallDepth, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []interpreterOption
+			if tt.limit != defaultMaxCallDepth {
+				opts = append(opts, withMaxCallDepth(tt.limit))
+			}
+			_, err := execLox(tt.source, opts...)
+			var overflow *RuntimeError
+			if !errors.As(err, &overflow) || overflow.Kind != StackOverflow {
+				t.Fatalf("got err %v, want a stack overflow", err)
+			}
+			if limit := overflow.Values[0].(int); limit != tt.limit {
+				t.Errorf("got limit %d, want %d", limit, tt.limit)
+			}
+			if frames := overflow.Values[1].([]string); tt.wantFrames != nil && strings.Join(frames, "|") != strings.Join(tt.wantFrames, "|") {
+				t.Errorf("got frames %q, want %q", frames, tt.wantFrames)
+			}
+		})
+	}
+
+	okTests := []struct {
+		name   string
+		source string
+		limit  int
+		want   interface{}
+	}{
+		{"tail calls do not count", "fun count(n) { if (n == 0) return 0; return count(n - 1); } var r = count(1000);", 10, 0.0},
+		{"depth is released after returning", "fun deep(n) { if (n == 0) return 0; return 1 + deep(n - 1); } var r = deep(8) + deep(8) + deep(8);", 10, 24.0},
+		{"no limit", "fun deep(n) { if (n == 0) return 0; return 1 + deep(n - 1); } var r = deep(20000);", 0, 20000.0},
+		{"tasks have their own depth", "fun deep(n) { if (n == 0) return 0; return 1 + deep(n - 1); } fun outer(n) { return 1 + deep(n); } var r = (spawn outer(8)).join();", 10, 9.0},
+		{"caught by try", "fun down(n) { return 1 + down(n + 1); } var r; try { down(0); } catch (e) { r = e.kind; }", 10, "StackOverflowError"},
+		{"script continues after catching", "fun down(n) { return 1 + down(n + 1); } var r = 0; for (i in range(3)) { try { down(0); } catch (e) { r = r + 1; } }", 10, 3.0},
+	}
+	for _, tt := range okTests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp, err := execLox(tt.source, withMaxCallDepth(tt.limit))
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_interpreter_executionBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		opts       []interpreterOption
+		cancelIn   time.Duration // 大于 0 的时候在这之后取消 ctx
+		wantReason string
+		wantCause  error
+	}{
+		{"step limit stops infinite loop", "while (true) {}", []interpreterOption{withMaxSteps(1000)}, 0, "more than 1000 steps", nil},
+		{"step limit stops infinite tail recursion", "fun spin() { return spin(); } spin();", []interpreterOption{withMaxSteps(1000)}, 0, "more than 1000 steps", nil},
+		{"step limit stops endless interval", "fun tick() {} setInterval(tick, 1);", []interpreterOption{withMaxSteps(100), withClock(newFakeClock(time.Unix(0, 0)))}, 0, "more than 100 steps", nil},
+		{"timeout stops infinite loop", "for (;;) {}", []interpreterOption{withTimeout(20 * time.Millisecond)}, 0, "deadline exceeded", context.DeadlineExceeded},
+		{"timeout interrupts a long timer", "await sleep(60000);", []interpreterOption{withTimeout(20 * time.Millisecond)}, 0, "deadline exceeded", context.DeadlineExceeded},
+		{"timeout stops spawned tasks", "fun spin() { while (true) {} } (spawn spin()).join();", []interpreterOption{withTimeout(20 * time.Millisecond)}, 0, "deadline exceeded", context.DeadlineExceeded},
+		{"cancel stops for in loop", "for (x in range(1000000000000)) {}", nil, 20 * time.Millisecond, "cancelled", context.Canceled},
+		{"timeout interrupts a blocked receive", "var ch = channel(); fun spin() { while (true) {} } spawn spin(); ch.receive();", []interpreterOption{withTimeout(20 * time.Millisecond)}, 0, "deadline exceeded", context.DeadlineExceeded},
+		{"timeout interrupts a blocked send", "var ch = channel(); ch.send(1);", []interpreterOption{withTimeout(20 * time.Millisecond)}, 0, "deadline exceeded", context.DeadlineExceeded},
+		{"cancel interrupts a blocked for in over channel", "var ch = channel(); for (x in ch) {}", nil, 20 * time.Millisecond, "cancelled", context.Canceled},
+		{"cancel interrupts a blocked join", "var ch = channel(); fun wait() { ch.receive(); } (spawn wait()).join();", nil, 20 * time.Millisecond, "cancelled", context.Canceled},
+		{"cancel interrupts a blocked select", "var ch = channel(); select { case v = ch.receive() => nil; }", nil, 20 * time.Millisecond, "cancelled", context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelIn > 0 {
+				time.AfterFunc(tt.cancelIn, cancel)
+			}
+			_, err := execLoxContext(ctx, tt.source, tt.opts...)
+			var exceeded *BudgetExceededError
+			if !errors.As(err, &exceeded) {
+				t.Fatalf("got err %v, want a budget exceeded error", err)
+			}
+			if exceeded.Reason != tt.wantReason {
+				t.Errorf("got reason %q, want %q", exceeded.Reason, tt.wantReason)
+			}
+			if exceeded.Err != tt.wantCause {
+				t.Errorf("got cause %v, want %v", exceeded.Err, tt.wantCause)
+			}
+		})
+	}
+
+	t.Run("runtime errors are not budget errors", func(t *testing.T) {
+		_, err := execLox("nil + 1;", withMaxSteps(10))
+		var exceeded *BudgetExceededError
+		if err == nil || errors.As(err, &exceeded) {
+			t.Errorf("got err %v, want an ordinary runtime error", err)
+		}
+	})
+	// 没有 timeout 的时候，interpret 返回之后没有被 join 的 task 也要停下来。
+	unjoined := []struct {
+		name   string
+		source string
+	}{
+		{"blocked receive", "var ch = channel(); fun wait() { ch.receive(); } var t = spawn wait();"},
+		{"blocked select", "var ch = channel(); fun wait() { select { case v = ch.receive() => nil; } } var t = spawn wait();"},
+		{"busy loop", "fun spin() { while (true) {} } var t = spawn spin();"},
+	}
+	for _, tt := range unjoined {
+		t.Run("unjoined task stops after interpret: "+tt.name, func(t *testing.T) {
+			intp, err := execLox(tt.source)
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			task := loxGlobal(t, intp, "t").(*LoxTask)
+			select {
+			case <-task.done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("task is still running after interpret returned")
+			}
+			var exceeded *BudgetExceededError
+			if !errors.As(task.err, &exceeded) || exceeded.Err != context.Canceled {
+				t.Errorf("got task err %v, want the task to be cancelled", task.err)
+			}
+		})
+	}
+	t.Run("host calls after interpret", func(t *testing.T) {
+		intp, err := execLox("fun add(a, b) { return a + b; }")
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		if got, err := intp.Call(loxGlobal(t, intp, "add").(Callable), []interface{}{1.0, 2.0}); err != nil || got != 3.0 {
+			t.Errorf("got %v, %v, want 3", got, err)
+		}
+	})
+	t.Run("programs within budget finish", func(t *testing.T) {
+		intp, err := execLox("var r = 0; for (i in range(10)) r = r + i;", withMaxSteps(100), withTimeout(time.Minute))
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		if got := loxGlobal(t, intp, "r"); got != 45.0 {
+			t.Errorf("got %v, want 45", got)
+		}
+	})
+}
+
+func Test_interpreter_memoryLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"string concatenation", `var s = "x"; while (true) s = s + s;`},
+		{"instance graph", `class Node {} var head; while (true) { var n = Node(); n.next = head; head = n; }`},
+		{"list growth", `var l = []; while (true) l.push(l);`},
+		{"map growth", `var m = {}; var i = 0; while (true) { m.set(i, i); i = i + 1; }`},
+		{"list literals", `var l; while (true) l = [l, l, l, l];`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp, err := execLox(tt.source, withMemoryLimit(64<<10))
+			var exceeded *MemoryLimitError
+			if !errors.As(err, &exceeded) {
+				t.Fatalf("got err %v, want a memory limit error", err)
+			}
+			if stats := intp.MemoryStats(); stats.Peak > 64<<10 {
+				t.Errorf("got peak %d, want at most the limit", stats.Peak)
+			}
+		})
+	}
+}
+
+// 长时间运行、但是存活的数据很少的程序不应该因为记账只增不减而超过上限。
+func Test_interpreter_memoryLimitLongRunning(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"string garbage", `var s; for (i in range(200000)) s = "item " + i;`},
+		{"instance garbage", `class P { init(x) { this.x = x; } } var p; for (i in range(100000)) p = P(i);`},
+		{"list garbage", `var l; for (i in range(100000)) l = [i, i, i];`},
+		{"map garbage", `var m; for (i in range(50000)) { m = {"k": i}; m.keys(); m.values(); }`},
+		{"closure garbage", `var f; for (i in range(50000)) { fun g() { return i; } f = g; }`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp, err := execLox(tt.source, withMemoryLimit(1<<20))
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if stats := intp.MemoryStats(); stats.Allocated <= 1<<20 {
+				t.Errorf("got allocated %d, want the program to allocate more than the limit", stats.Allocated)
+			}
+		})
+	}
+}
+
+func Test_memoryAccount_reconcile(t *testing.T) {
+	t.Run("gc only after enough allocation", func(t *testing.T) {
+		m := newMemoryAccount()
+		m.limit = 1000
+		calls := 0
+		m.liveHeap = func() int64 {
+			calls++
+			// GC 的时候没有持有锁，记账的其它操作不会阻塞。
+			m.stats()
+			return 0
+		}
+		m.start()
+		for n := 0; n < 10000; n++ {
+			if err := m.charge(memoryStrings, 1); err != nil {
+				t.Fatalf("charge failed: %v", err)
+			}
+		}
+		if want := 1 + 10000/(1000/reconcileDivisor); calls > want {
+			t.Errorf("got %d heap reads, want at most %d", calls, want)
+		}
+	})
+	t.Run("heap of other interpreters is not charged", func(t *testing.T) {
+		m := newMemoryAccount()
+		m.limit = 1000
+		m.liveHeap = func() int64 { return 0 }
+		m.start()
+		// 开始执行之后其它 interpreter 让 heap 变大了很多。
+		m.liveHeap = func() int64 { return 1 << 40 }
+		if err := m.charge(memoryStrings, 900); err != nil {
+			t.Fatalf("charge failed: %v", err)
+		}
+		var exceeded *MemoryLimitError
+		if err := m.charge(memoryStrings, 200); !errors.As(err, &exceeded) || exceeded.InUse != 900 {
+			t.Errorf("got err %v, want a memory limit error with 900 bytes in use", err)
+		}
+	})
+}
+
+func Test_interpreter_memoryStats(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		maxPeak int64
+		minUse  int64 // 结束的时候 InUse 的下限
+	}{
+		{"call environments are released", `fun f(n) { var a = n; return a; } for (i in range(1000)) f(i);`, 2048, 0},
+		{"tail calls run in constant memory", `fun count(n) { if (n == 0) return 0; return count(n - 1); } count(1000);`, 2048, 0},
+		{"captured environments stay", `var fs = []; fun mk(i) { fun g() { return i; } return g; } for (i in range(100)) fs.push(mk(i));`, 1 << 20, 100 * envOverhead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, tt.source)
+			stats := intp.MemoryStats()
+			if stats.Peak > tt.maxPeak {
+				t.Errorf("got peak %d, want at most %d", stats.Peak, tt.maxPeak)
+			}
+			if stats.InUse < tt.minUse {
+				t.Errorf("got in use %d, want at least %d", stats.InUse, tt.minUse)
+			}
+			if stats.ByKind["environments"] == 0 || stats.Allocated < stats.Peak {
+				t.Errorf("got stats %+v, want environments to be accounted", stats)
+			}
+		})
+	}
+}
+
+func Test_interpreter_capabilities(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	tests := []struct {
+		name       string
+		source     string
+		caps       []capability
+		wantNative string
+		wantCap    capability
+	}{
+		{"clock without clock capability", "clock();", []capability{capabilityEnv}, "clock", capabilityClock},
+		{"timers without clock capability", "fun f() {} setTimeout(f, 1);", nil, "setTimeout", capabilityClock},
+		{"sleep without clock capability", "async fun f() { await sleep(1); } await f();", []capability{capabilityFSRead}, "sleep", capabilityClock},
+		{"getenv without env capability", `getenv("HOME");`, []capability{capabilityProcess}, "getenv", capabilityEnv},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(tt.source, withCapabilities(tt.caps...))
+			var denied *CapabilityError
+			if !errors.As(err, &denied) {
+				t.Fatalf("got err %v, want a capability error", err)
+			}
+			if denied.Native != tt.wantNative || denied.Capability != tt.wantCap {
+				t.Errorf("got %v, want %s to require %s", denied, tt.wantNative, tt.wantCap)
+			}
+		})
+	}
+
+	t.Run("fake clock is deterministic", func(t *testing.T) {
+		clock := newFakeClock(start)
+		intp, err := execLox("var a = clock(); var b; fun later() { b = clock(); } setTimeout(later, 250);", withClock(clock), withCapabilities(capabilityClock))
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		if got := loxGlobal(t, intp, "a"); got != 1700000000000.0 {
+			t.Errorf("got a %v, want 1700000000000", got)
+		}
+		if got := loxGlobal(t, intp, "b"); got != 1700000000250.0 {
+			t.Errorf("got b %v, want 1700000000250", got)
+		}
+	})
+	t.Run("natives without capabilities are always allowed", func(t *testing.T) {
+		intp, err := execLox("var ch = channel(1); ch.send(1); var r = 0; for (i in range(3)) r = r + i;", withCapabilities())
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		if got := loxGlobal(t, intp, "r"); got != 3.0 {
+			t.Errorf("got %v, want 3", got)
+		}
+	})
+
+	t.Run("getenv reads the environment", func(t *testing.T) {
+		env := map[string]string{"LOX_HOME": "/opt/lox"}
+		lookup := func(name string) (string, bool) {
+			v, ok := env[name]
+			return v, ok
+		}
+		intp, err := execLox(`var home = getenv("LOX_HOME"); var missing = getenv("LOX_MISSING");`, withLookupEnv(lookup), withCapabilities(capabilityEnv))
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		if got := loxGlobal(t, intp, "home"); got != "/opt/lox" {
+			t.Errorf("got home %v, want /opt/lox", got)
+		}
+		if got := loxGlobal(t, intp, "missing"); got != nil {
+			t.Errorf("got missing %v, want nil", got)
+		}
+	})
+
+	parseTests := []struct {
+		flag    string
+		want    []capability
+		wantErr string
+	}{
+		{"", nil, ""},
+		{"clock, env", []capability{capabilityClock, capabilityEnv}, ""},
+		{"net-loopback", []capability{capabilityNetLoopback}, ""},
+		{"all", allCapabilities, ""},
+		{"clock,gpu", nil, `unknown capability "gpu"`},
+	}
+	for _, tt := range parseTests {
+		t.Run("parse "+tt.flag, func(t *testing.T) {
+			got, err := parseCapabilities(tt.flag)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("got err %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_interpreter_fs(t *testing.T) {
+	newRoot := func(t *testing.T) string {
+		t.Helper()
+		root := t.TempDir()
+		if err := os.WriteFile(filepath.Join(root, "input.txt"), []byte("a\nb\r\nc\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		outside := t.TempDir()
+		if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"read file", `var r = fs.readFile("input.txt");`, "a\nb\r\nc\n"},
+		{"read lines", `var r = fs.readLines("input.txt");`, "[a, b, c]"},
+		{"write then read", `fs.writeFile("out.txt", "x"); fs.writeFile("out.txt", "report"); var r = fs.readFile("out.txt");`, "report"},
+		{"append", `fs.appendFile("log.txt", "1"); fs.appendFile("log.txt", "2"); var r = fs.readFile("log.txt");`, "12"},
+		{"exists", `var r = [fs.exists("input.txt"), fs.exists("missing.txt")];`, "[true, false]"},
+		{"mkdir and list", `fs.mkdir("reports/2024"); fs.writeFile("reports/b.txt", ""); fs.writeFile("reports/a.txt", ""); var r = fs.listDir("reports");`, "[2024, a.txt, b.txt]"},
+		{"remove", `fs.writeFile("tmp.txt", ""); fs.remove("tmp.txt"); var r = fs.exists("tmp.txt");`, "false"},
+		{"dot dot inside root", `fs.mkdir("sub"); var r = fs.readFile("sub/../input.txt");`, "a\nb\r\nc\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp, err := execLox(tt.source, withFSRoot(newRoot(t)))
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := loxString(loxGlobal(t, intp, "r")); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		opts    []interpreterOption
+		wantErr string
+	}{
+		{"parent directory", `fs.readFile("../secret.txt");`, nil, "is outside of the root directory"},
+		{"absolute path", `fs.readFile("/etc/passwd");`, nil, "is outside of the root directory"},
+		{"symlink out of root", `fs.readFile("escape/secret.txt");`, nil, "is outside of the root directory"},
+		{"write through symlink", `fs.writeFile("escape/new.txt", "x");`, nil, "is outside of the root directory"},
+		{"missing file", `fs.readFile("missing.txt");`, nil, "readFile missing.txt: open"},
+		{"remove root", `fs.remove(".");`, nil, "cannot remove the root directory"},
+		{"not a path", `fs.readFile(1);`, nil, "readFile expects a path string"},
+		{"unknown member", `fs.format("x");`, nil, "format not found in namespace fs"},
+		{"read without capability", `fs.readFile("input.txt");`, []interpreterOption{withCapabilities(capabilityFSWrite)}, `requires capability "fs-read"`},
+		{"write without capability", `fs.writeFile("out.txt", "x");`, []interpreterOption{withCapabilities(capabilityFSRead)}, `requires capability "fs-write"`},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(tt.source, append([]interpreterOption{withFSRoot(newRoot(t))}, tt.opts...)...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("dangling symlinks", func(t *testing.T) {
+		root, outside := t.TempDir(), t.TempDir()
+		links := map[string]string{
+			"evil":       filepath.Join(outside, "pwned.txt"),
+			"evilDir":    filepath.Join(outside, "missing"),
+			"chain":      "evil",
+			"inside":     "created.txt",
+			"insideLoop": "insideLoop",
+		}
+		for name, target := range links {
+			if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, source := range []string{
+			`fs.writeFile("evil", "escaped");`,
+			`fs.appendFile("chain", "escaped");`,
+			`fs.writeFile("evilDir/pwned.txt", "escaped");`,
+			`fs.mkdir("evilDir/sub");`,
+		} {
+			_, err := execLox(source, withFSRoot(root))
+			if err == nil || !strings.Contains(err.Error(), "is outside of the root directory") {
+				t.Errorf("%s: got err %v, want the path to be outside of the root directory", source, err)
+			}
+		}
+		if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+			t.Errorf("got %d entries outside of the root, want none", len(entries))
+		}
+		if _, err := execLox(`fs.writeFile("insideLoop", "x");`, withFSRoot(root)); !errors.Is(err, IOError) {
+			t.Errorf("got err %v, want an IOError for a symlink loop", err)
+		}
+		intp, err := execLox(`fs.writeFile("inside", "ok"); var r = fs.readFile("created.txt");`, withFSRoot(root))
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		if got := loxGlobal(t, intp, "r"); got != "ok" {
+			t.Errorf("got %q, want the dangling symlink inside the root to be written", got)
+		}
+	})
+
+	t.Run("no root configured", func(t *testing.T) {
+		_, err := execLox(`fs.exists("input.txt");`)
+		if err == nil || !strings.Contains(err.Error(), "file system access is not configured") {
+			t.Errorf("got err %v, want file system access to be disabled", err)
+		}
+	})
+	catchTests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"missing file", `var r; try { fs.readFile("missing.txt"); } catch (e) { r = e.kind; }`, "IOError"},
+		{"outside of root", `var r; try { fs.writeFile("../x.txt", "x"); } catch (e) { r = e.kind + " " + e.line; }`, "IOError 1"},
+		{"not a path", `var r; try { fs.exists(nil); } catch (e) { r = e.kind; }`, "TypeError"},
+		{"script continues", `var r = "before"; try { fs.listDir("missing"); } catch (e) {} r = fs.readFile("input.txt");`, "a\nb\r\nc\n"},
+	}
+	for _, tt := range catchTests {
+		t.Run("catch "+tt.name, func(t *testing.T) {
+			intp, err := execLox(tt.source, withFSRoot(newRoot(t)))
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("errors wrap os errors", func(t *testing.T) {
+		_, err := execLox(`fs.readFile("missing.txt");`, withFSRoot(newRoot(t)))
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got err %v, want it to wrap fs.ErrNotExist", err)
+		}
+	})
+}
+
+func Test_interpreter_process(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		stdin  string
+		args   []string
+		want   string
+	}{
+		{"args", `var r = args;`, "", []string{"a.txt", "-v"}, "[a.txt, -v]"},
+		{"no args", `var r = args.length();`, "", nil, "0"},
+		{"read lines until eof", `var r = []; var line = readLine(); while (line != nil) { r.push(line); line = readLine(); }`, "one\r\ntwo\n\nlast", nil, "[one, two, , last]"},
+		{"read all after a line", `var first = readLine(); var r = [first, readAll()];`, "head\nrest\nof it\n", nil, "[head, rest\nof it\n]"},
+		{"read all at eof", `readAll(); var r = [readAll(), readLine()];`, "x", nil, "[, nil]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp, err := execLox(tt.source, withStdin(strings.NewReader(tt.stdin)), withArgs(tt.args))
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := loxString(loxGlobal(t, intp, "r")); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	exitTests := []struct {
+		name     string
+		source   string
+		wantCode int
+	}{
+		{"exit with code", `exit(3); print "unreachable";`, 3},
+		{"exit without code", `exit();`, 0},
+		{"exit from nested call", `fun check(n) { if (n > 2) exit(4); return n; } for (i in range(10)) check(i);`, 4},
+		{"exit from async function", `async fun stop() { await sleep(1); exit(5); } stop();`, 5},
+	}
+	for _, tt := range exitTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(tt.source, withClock(newFakeClock(time.Unix(0, 0))))
+			var exit *ExitError
+			if !errors.As(err, &exit) || exit.Code != tt.wantCode {
+				t.Errorf("got err %v, want exit code %d", err, tt.wantCode)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		opts    []interpreterOption
+		wantErr string
+	}{
+		{"bad exit code", `exit(256);`, nil, "exit code must be an integer between 0 and 255"},
+		{"fractional exit code", `exit(1.5);`, nil, "exit code must be an integer between 0 and 255"},
+		{"exit without capability", `exit(1);`, []interpreterOption{withCapabilities()}, `requires capability "process"`},
+		{"stdin without capability", `readLine();`, []interpreterOption{withCapabilities(capabilityFSRead)}, `requires capability "process"`},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(tt.source, tt.opts...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_json(t *testing.T) {
+	// lox 的 string 没有转义，json 文本通过 stdin 传进去。
+	roundTrips := []string{
+		`null`,
+		`true`,
+		`-12.5`,
+		`1e+21`,
+		`"a \"quoted\" \\ line\nwith tab\t and unicode ☃ <&>"`,
+		`[]`,
+		`{}`,
+		`[1,"two",false,null,[3,[4]],{"k":"v"}]`,
+		`{"z":1,"a":{"nested":[1,2,{"deep":true}]},"m":[]}`,
+	}
+	for _, src := range roundTrips {
+		t.Run("round trip "+src, func(t *testing.T) {
+			intp, err := execLox(`var r = json.stringify(json.parse(readAll()));`, withStdin(strings.NewReader(src)))
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := loxGlobal(t, intp, "r"); got != src {
+				t.Errorf("got %v, want %v", got, src)
+			}
+		})
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		stdin  string
+		want   string
+	}{
+		{"parse to lox values", `var v = json.parse(readAll()); var r = [v["a"], v["b"].length(), v["b"][1], v["c"]];`, ` {"a": 1.5, "b": ["x", "y"], "c": null} `, "[1.5, 2, y, nil]"},
+		{"duplicate keys keep the last", `var r = json.parse(readAll())["a"];`, `{"a":1,"a":2}`, "2"},
+		{"stringify instance fields", `class P { #secret; init(x, y) { this.y = y; this.x = x; this.#secret = 1; } } var r = json.stringify([P(1, "b"), P(nil, [true])]);`, "", `[{"x":1,"y":"b"},{"x":null,"y":[true]}]`},
+		{"shared value is not a cycle", `var shared = [1]; var r = json.stringify({"a": shared, "b": [shared, shared]});`, "", `{"a":[1],"b":[[1],[1]]}`},
+		{"indent with spaces", `var r = json.stringify({"a": [1, 2], "b": {}}, 2);`, "", "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": {}\n}"},
+		{"indent with string", `var r = json.stringify([1], "	");`, "", "[\n\t1\n]"},
+		{"zero indent is compact", `var r = json.stringify([1, {"a": nil}], 0);`, "", `[1,{"a":null}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp, err := execLox(tt.source, withStdin(strings.NewReader(tt.stdin)))
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := loxString(loxGlobal(t, intp, "r")); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	parseErrTests := []struct {
+		stdin   string
+		wantErr string
+	}{
+		{``, "unexpected end of input at line 1, column 1"},
+		{`{"a" 1}`, `unexpected '1', expect ':' after object key at line 1, column 6`},
+		{`[1,]`, `unexpected ']' at line 1, column 4`},
+		{`[1 2]`, `unexpected '2', expect ',' or ']' after array element at line 1, column 4`},
+		{"{\n  \"a\": tru\n}", "invalid literal, expect true at line 2, column 8"},
+		{"[\n1,\n  01]", "leading zero in number at line 3, column 3"},
+		{`{"a":1} x`, `unexpected 'x' after JSON value at line 1, column 9`},
+		{`{1:2}`, `unexpected '1', expect object key string at line 1, column 2`},
+		{`["abc`, "unterminated string at line 1, column 2"},
+		{`"bad \q"`, `invalid escape '\q' in string at line 1, column 6`},
+		{`"\u12x4"`, `invalid escape '\u' in string at line 1, column 2`},
+		{`[-]`, `unexpected ']', expect digit at line 1, column 3`},
+		{`[1.e5]`, `unexpected 'e', expect digit after decimal point at line 1, column 4`},
+		{strings.Repeat("[", maxJSONDepth+1), "nesting deeper than 1000 levels at line 1, column 1001"},
+		{`{"é": "ü" x}`, `unexpected 'x', expect ',' or '}' after object value at line 1, column 11`},
+		{"[\"日本\",\n \"語\" 語]", `unexpected '語', expect ',' or ']' after array element at line 2, column 6`},
+	}
+	for _, tt := range parseErrTests {
+		t.Run("parse error "+tt.stdin, func(t *testing.T) {
+			_, err := execLox(`json.parse(readAll());`, withStdin(strings.NewReader(tt.stdin)))
+			if err == nil || !strings.Contains(err.Error(), "json.parse: "+tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"list cycle", `var l = [1]; l.push(l); json.stringify(l);`, "cycle detected, a list contains itself"},
+		{"map cycle", `var m = {}; m.set("self", [m]); json.stringify(m);`, "cycle detected, a map contains itself"},
+		{"instance cycle", `class Node {} var n = Node(); n.next = n; json.stringify(n);`, "cycle detected, a instance of Node contains itself"},
+		{"non string key", `json.stringify({1: 2});`, "object keys must be strings, got 1"},
+		{"function", `fun f() {} json.stringify([f]);`, "cannot serialize <function: f>"},
+		{"nan", `json.stringify(nan);`, "cannot serialize number NaN"},
+		{"bad indent", `json.stringify(1, 1.5);`, "indent must be an integer between 0 and 10"},
+		{"indent string with other characters", `json.stringify([1], "ab");`, `indent string must contain only spaces and tabs, got "ab"`},
+		{"deeply nested list", `var l = []; for (i in range(2000)) l = [l]; json.stringify(l);`, "nesting deeper than 1000 levels"},
+		{"parse non string", `json.parse(1);`, "json.parse expects a string, got 1"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_regex(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"test", `var r = [regex.test("^\d+$", "123"), regex.test("^\d+$", "12a")];`, "[true, false]"},
+		{"compiled pattern is a value", `var re = regex.compile("a+"); var all = [re]; var r = [all[0].test("caab"), regex.test(re, "xyz"), re.source(), re];`, "[true, false, a+, <regex: a+>]"},
+		{"find", `var m = regex.find("(\w+)@(\w+)", "mail: bob@example now"); var r = [m.text(), m.start(), m.end(), m.group(1), m.group(2), m.groups()];`, "[bob@example, 6, 17, bob, example, [bob, example]]"},
+		{"find without match", `var r = regex.find("x", "abc");`, "nil"},
+		{"named groups", `var m = regex.find("(?P<year>\d{4})-(?P<month>\d{2})", "on 2024-05-17"); var r = [m.group("year"), m.group("month"), m.named()];`, "[2024, 05, {year: 2024, month: 05}]"},
+		{"optional group is nil", `var m = regex.find("a(b)?(c)", "ac"); var r = m.groups();`, "[nil, c]"},
+		{"find all", `var r = []; for (m in regex.findAll("\d+", "a1 b22 c333")) r.push(m.text());`, "[1, 22, 333]"},
+		{"positions count characters", `var m = regex.findAll("b", "ébb"); var r = [m[0].start(), m[1].start(), m[1].end()];`, "[1, 2, 3]"},
+		{"replace with template", `var r = regex.replace("(\w+)@(\w+)", "bob@home, amy@work", "$2:$1");`, "home:bob, work:amy"},
+		{"replace with named template", `var r = regex.replace("(?P<n>\d+)", "a1b2", "<${n}>");`, "a<1>b<2>"},
+		{"replace with callback", `var count = 0; fun shout(m) { count = count + 1; return m.group(1) + "!"; } var r = [regex.replace("(o+)", "foo boo z", shout), count];`, "[foo! boo! z, 2]"},
+		{"replace with bound method", `class Upper { init(prefix) { this.prefix = prefix; } apply(m) { return this.prefix + m.text(); } } var r = regex.compile("\d").replace("a1b2", Upper("#").apply);`, "a#1b#2"},
+		{"split", `var r = regex.split("\s*,\s*", "a , b,c  ,d");`, "[a, b, c, d]"},
+		{"split with limit", `var r = regex.compile(",").split("a,b,c", 2);`, "[a, b,c]"},
+		{"escape", `var r = regex.test("^" + regex.escape("1+1=2?") + "$", "1+1=2?");`, "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp, err := execLox(tt.source)
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := loxString(loxGlobal(t, intp, "r")); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"invalid pattern", `regex.compile("a(b");`, "regex: invalid pattern \"a(b\": error parsing regexp: missing closing ): `a(b`"},
+		{"invalid pattern in test", `regex.test("[z-a]", "x");`, "invalid character class range"},
+		{"subject must be a string", `regex.test("a", 1);`, "test expects a string, got 1"},
+		{"pattern must be a string", `regex.find(nil, "a");`, "find expects a pattern string or a regex, got <nil>"},
+		{"unknown group index", `regex.find("a", "a").group(1);`, "group index 1 out of range [0, 1)"},
+		{"unknown group name", `regex.find("(?P<x>a)", "a").group("y");`, "no group named y"},
+		{"callback must return a string", `fun f(m) { return 1; } regex.replace("a", "a", f);`, "replace function must return a string, got 1"},
+		{"callback arity", `fun f() { return ""; } regex.replace("a", "a", f);`, "replace expects a function with one parameter"},
+		{"callback error stops replace", `fun f(m) { return nil + 1; } regex.replace("a", "aaa", f);`, "are not the same type"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_datetime(t *testing.T) {
+	start := time.Date(2024, time.March, 10, 6, 30, 15, 250*int(time.Millisecond), time.UTC)
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"now uses the injected clock", `var r = [datetime.now(), datetime.now("Asia/Tokyo")];`, "[2024-03-10T06:30:15.250Z, 2024-03-10T15:30:15.250+09:00]"},
+		{"now follows sleep", `var before = datetime.now(); await sleep(1500); var r = datetime.now() - before;`, "1.5s"},
+		{"of components", `var d = datetime.of(2024, 2, 29, 23, 59, 58, 7); var r = [d, d.year(), d.month(), d.day(), d.hour(), d.minute(), d.second(), d.millisecond(), d.weekday(), d.yearDay()];`, "[2024-02-29T23:59:58.007Z, 2024, 2, 29, 23, 59, 58, 7, 4, 60]"},
+		{"of with named zone", `var d = datetime.of(2024, 7, 1, hour: 9, zone: "Europe/Paris"); var r = [d, d.zone(), d.offset()];`, "[2024-07-01T09:00:00.000+02:00, Europe/Paris, 120]"},
+		{"parse offsets", `var r = [datetime.parse("2024-05-17T10:30:00Z"), datetime.parse("2024-05-17T10:30:00.5+05:30"), datetime.parse("2024-05-17T10:30-03:00")];`, "[2024-05-17T10:30:00.000Z, 2024-05-17T10:30:00.500+05:30, 2024-05-17T10:30:00.000-03:00]"},
+		{"parse without offset uses zone", `var r = [datetime.parse("2024-05-17"), datetime.parse("2024-05-17T08:15", "America/New_York"), datetime.parse("2024-05-17T08:15:01.125", zone: "UTC")];`, "[2024-05-17T00:00:00.000Z, 2024-05-17T08:15:00.000-04:00, 2024-05-17T08:15:01.125Z]"},
+		{"format", `var d = datetime.of(2024, 1, 5, 14, 7); var r = [d.format(), d.format("2006/01/02 03:04PM Mon Jan")];`, "[2024-01-05T14:07:00.000Z, 2024/01/05 02:07PM Fri Jan]"},
+		{"convert zone keeps the instant", `var d = datetime.of(2024, 12, 31, 20, zone: "America/Los_Angeles"); var tokyo = d.in("Asia/Tokyo"); var r = [tokyo, tokyo == d, tokyo.unixMillis() == d.unixMillis()];`, "[2025-01-01T13:00:00.000+09:00, true, true]"},
+		{"add durations", `var d = datetime.of(2024, 3, 9, 12, zone: "America/New_York"); var r = [d + datetime.duration(days: 1), datetime.duration(hours: 1, minutes: 30) + d, d - datetime.duration(seconds: 0.5)];`, "[2024-03-10T13:00:00.000-04:00, 2024-03-09T13:30:00.000-05:00, 2024-03-09T11:59:59.500-05:00]"},
+		{"add calendar days across dst", `var d = datetime.of(2024, 3, 9, 12, zone: "America/New_York"); var r = [d.addDate(days: 1), d.addDate(0, 1), d.addDate(years: -1)];`, "[2024-03-10T12:00:00.000-04:00, 2024-04-09T12:00:00.000-04:00, 2023-03-09T12:00:00.000-05:00]"},
+		{"subtract datetimes", `var a = datetime.parse("2024-01-01T00:00:00Z"); var b = datetime.parse("2024-01-02T06:00:00+02:00"); var d = b - a; var r = [d, d.hours(), d.days(), a - b];`, "[28h0m0s, 28, 1.1666666666666667, -28h0m0s]"},
+		{"compare datetimes", `var a = datetime.of(2024, 1, 1); var b = datetime.of(2024, 1, 1, 1); var r = [a < b, a <= b, a > b, b >= a, a == datetime.of(2024, 1, 1), a != b, a == nil];`, "[true, true, false, true, true, true, false]"},
+		{"duration arithmetic", `var h = datetime.parseDuration("1h"); var m = datetime.duration(minutes: 15); var r = [h + m, h - m, m * 3, 2 * m, h / 4, h / m, m < h, h == datetime.duration(minutes: 60), m.seconds(), m.milliseconds()];`, "[1h15m0s, 45m0s, 45m0s, 30m0s, 15m0s, 4, true, true, 900, 900000]"},
+		{"from unix millis", `var r = [datetime.fromUnixMillis(0), datetime.fromUnixMillis(86400000, "Asia/Kolkata")];`, "[1970-01-01T00:00:00.000Z, 1970-01-02T05:30:00.000+05:30]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp, err := execLox(tt.source, withClock(newFakeClock(start)))
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := loxString(loxGlobal(t, intp, "r")); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		opts    []interpreterOption
+		wantErr string
+	}{
+		{"invalid month", `datetime.of(2024, 13, 1);`, nil, "datetime.of: month must be an integer between 1 and 12, got 13"},
+		{"invalid day of month", `datetime.of(2023, 2, 29);`, nil, "datetime.of: day 29 out of range for 2023-02"},
+		{"missing day", `datetime.of(2024, 1, hour: 3);`, nil, "missing arguments for parameters 'day'"},
+		{"unknown zone", `datetime.now("Mars/Olympus");`, nil, `datetime.now: unknown time zone "Mars/Olympus"`},
+		{"unknown named parameter", `datetime.of(2024, 1, 1, tz: "UTC");`, nil, "unexpected named argument 'tz'"},
+		{"bad iso string", `datetime.parse("17/05/2024");`, nil, `datetime.parse: cannot parse "17/05/2024" as an ISO-8601 date time`},
+		{"bad duration", `datetime.parseDuration("soon");`, nil, `datetime.parseDuration: cannot parse "soon"`},
+		{"duration overflow", `datetime.duration(days: 1000000);`, nil, "duration out of range"},
+		{"duration minus datetime", `datetime.duration(hours: 1) - datetime.of(2024, 1, 1);`, nil, "1h0m0s is not a number"},
+		{"compare with number", `datetime.of(2024, 1, 1) < 1;`, nil, "2024-01-01T00:00:00.000Z is not a number"},
+		{"divide by zero", `datetime.duration(hours: 1) / 0;`, nil, "division of duration by zero"},
+		{"now without capability", `datetime.now();`, []interpreterOption{withCapabilities()}, `requires capability "clock"`},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(tt.source, tt.opts...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_stringify(t *testing.T) {
+	decls := `
+class Point {
+  init(x, y) { this.x = x; this.y = y; }
+  toString() { return "(" + this.x + ", " + this.y + ")"; }
+}
+class Plain {}
+`
+	tests := []struct {
+		name   string
+		source string
+		opts   []interpreterOption
+		want   string
+	}{
+		{"numbers", `print 3000000; print 1.5; print -2; print 0.1 + 0.2; print 100000000000 * 100000000000; print nan; print inf; print -inf; print nan == nan;`, nil, "3000000\n1.5\n-2\n0.30000000000000004\n1e+22\nNaN\nInfinity\n-Infinity\nfalse\n"},
+		{"nil and booleans", `print nil; print true; print [nil, false];`, nil, "nil\ntrue\n[nil, false]\n"},
+		{"instances", `print Plain(); print Point(1, 2.5); print [Point(0, 1000000)]; print {"p": Point(3, 4)};`, nil, "<Plain instance>\n(1, 2.5)\n[(0, 1000000)]\n{p: (3, 4)}\n"},
+		{"concatenation", `print "n=" + 1000000; print 2 + "x"; print "p=" + Point(1, 2) + nil; print "l=" + [1, true];`, nil, "n=1000000\n2x\np=(1, 2)nil\nl=[1, true]\n"},
+		{"self containing list", `var l = [1]; l.push(l); print l;`, nil, "[1, [...]]\n"},
+		{"expression statements print values that are not nil", `1 + 2; Point(1, 2); nil; var x = 1; x = nil;`, nil, "3\n(1, 2)\n"},
+		{"format", `print format("{:>8.2f}|{}|{:<5}|{:^7}|", 3.14159, "s", 42, "mid");`, nil, "    3.14|s|42   |  mid  |\n"},
+		{"format explicit indexes", `print format("{1} {0} {1}", "a", "b");`, nil, "b a b\n"},
+		{"format integers", `print format("{:d} {:05d} {:x} {:+>6d}", 42, -42, 255, 7);`, nil, "42 -0042 ff +++++7\n"},
+		{"format int64 bounds", `print format("{:d} {:x}", -9223372036854775808, 9223372036854774784);`, nil, "-9223372036854775808 7ffffffffffffc00\n"},
+		{"format floats", `print format("{:.3e} {:f} {:.0f} {:08.3f}", 12345.678, 2, 2.5, -3.14159);`, nil, "1.235e+04 2.000000 2 -003.142\n"},
+		{"format uses toString", `print format("[{:>8}] [{:.3}] {{literal}}", Point(1, 2), "truncate");`, nil, "[  (1, 2)] [tru] {literal}\n"},
+		{"format unicode width", `print format("{:*^5}", "é");`, nil, "**é**\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			_, err := execLox(decls+tt.source, append(tt.opts, withStdout(&out))...)
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"toString must return a string", `class B { toString() { return 1; } } print B();`, "toString of <B instance> must return a string, got 1"},
+		{"missing argument", `format("{} {}", 1);`, "format: missing argument 1, got 1 arguments"},
+		{"mixed indexes", `format("{} {0}", 1);`, "format: cannot mix automatic and explicit argument indexes"},
+		{"unclosed brace", `format("a {", 1);`, "format: unclosed '{' at offset 2"},
+		{"single closing brace", `format("a } b");`, "format: single '}' at offset 2"},
+		{"invalid spec", `format("{:q}", 1);`, `format: invalid spec "q"`},
+		{"integer verb", `format("{:d}", 1.5);`, "format: {:d} expects an integer, got 1.5"},
+		{"integer verb with nan", `format("{:d}", nan);`, "format: {:d} expects an integer, got NaN"},
+		{"integer verb with inf", `format("{:x}", -inf);`, "format: {:x} expects an integer, got -Infinity"},
+		{"integer too large", `format("{:d}", 9223372036854775808);`, "format: {:d} integer 9223372036854776000 is out of range"},
+		{"integer too small", `format("{:x}", -10000000000000000000);`, "format: {:x} integer -10000000000000000000 is out of range"},
+		{"number verb", `format("{:f}", "x");`, "format: {:f} expects a number, got x"},
+		{"template must be a string", `format(1);`, "format expects a template string, got 1"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(decls+tt.source, withStdout(io.Discard))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got err %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_interpreter_runtimeErrors(t *testing.T) {
+	decls := "class A { g { return 1; } }\nvar m = {};\n"
+	tests := []struct {
+		name     string
+		source   string
+		wantKind ErrorKind
+		wantLine int
+		wantMsg  string
+	}{
+		{"negate a string", `print -"a";`, TypeError, 3, "operand of - must be a number, got a"},
+		{"compare nil", "var x = 1;\nprint x < nil;", TypeError, 4, "nil is not a number"},
+		{"add nil", `print nil + 1;`, TypeError, 3, "left: nil, right: 1 are not the same type"},
+		{"call a number", "var n = 1;\nn();", TypeError, 4, "1 is not callable"},
+		{"index a number", `print 1[0];`, TypeError, 3, "1 is not indexable"},
+		{"non integer index", `print [1][0.5];`, TypeError, 3, "list index 0.5 is not an integer"},
+		{"undefined variable", "\n\nprint missing;", NameError, 5, "undefined variable missing"},
+		{"assign undefined variable", `missing = 1;`, NameError, 3, "assign to undefined variable missing"},
+		{"too many arguments", "fun f(a) {}\nf(1, 2);", ArityError, 4, "expected 1 arguments but got 2"},
+		{"missing default", "fun f(a, b = 1) {}\nf(b: 2);", ArityError, 4, "missing arguments for parameters 'a'"},
+		{"native arity", `clock(1);`, ArityError, 3, "expected 0 arguments but got 1"},
+		{"undefined property", "var a = A();\nprint a.nope;", PropertyError, 4, "undefined property nope on <A instance>"},
+		{"getter only", `A().g = 2;`, PropertyError, 3, "cannot assign to getter-only property g"},
+		{"property of nil", `print nil.x;`, PropertyError, 3, "cannot get property x of nil"},
+		{"list method", `[].nope();`, PropertyError, 3, "nope not found in list"},
+		{"list index out of range", "var l = [1];\nprint l[3];", IndexError, 4, "list index 3 out of range [0, 1)"},
+		{"missing map key", `print m["k"];`, IndexError, 3, "key k not found in map"},
+		{"division by zero", "var z = 0;\nprint 1 / z;", ZeroDivision, 4, "division by zero"},
+		{"duration division by zero", `print datetime.duration(hours: 1) / 0;`, ZeroDivision, 3, "division of duration by zero"},
+		{"no case matched", "match (3) {\n  case 1 => print 1;\n}", MatchError, 3, "no case matched value 3"},
+		{"error inside function", "fun f(x) {\n  return x.y;\n}\nf(1);", PropertyError, 4, "cannot get property y of 1"},
+		{"self containing list in message", "var l = [1];\nl.push(l);\nvar x = l - 1;", TypeError, 5, "[1, [...]] is not a number"},
+		{"self containing map in message", "var c = {};\nc.set(\"self\", c);\nprint -c;", TypeError, 5, "operand of - must be a number, got {self: {...}}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execLox(decls + tt.source)
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Fatalf("got err %v, want a RuntimeError", err)
+			}
+			if !errors.Is(err, tt.wantKind) || runtimeErr.Line() != tt.wantLine || !strings.Contains(runtimeErr.Msg, tt.wantMsg) {
+				t.Errorf("got %v, want %s at line %d: %s", err, tt.wantKind, tt.wantLine, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func Test_interpreter_tryCatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   interface{}
+	}{
+		{"no error", `var r = 1; try { r = 2; } catch (e) { r = 3; }`, 2.0},
+		{"kind and message", `var r; try { nil + 1; } catch (e) { r = e.kind + ": " + e.message; }`, "TypeError: left: nil, right: 1 are not the same type(float or string)"},
+		{"line", "var r;\ntry {\n  [1][5];\n} catch (e) { r = e.line; }", 3.0},
+		{"error from a call", `fun f() { return {}["k"]; } var r; try { f(); } catch (e) { r = e.kind; }`, "IndexError"},
+		{"tail call inside try", `fun f() { return nil.x; } fun g() { try { return f(); } catch (e) { return e.kind; } } var r = g();`, "PropertyError"},
+		{"return inside try", `fun g() { try { return 1; } catch (e) { return 2; } } var r = g();`, 1.0},
+		{"nested rethrow", `var r; try { try { 1 / 0; } catch (e) { e.nope; } } catch (e) { r = e.kind; }`, "PropertyError"},
+		{"break inside try", `var r = 0; while (true) { try { r = r + 1; if (r == 3) break; } catch (e) {} }`, 3.0},
+		{"error is printable", `var r; try { missing; } catch (e) { r = format("{}", e); }`, "NameError at line 1: undefined variable missing"},
+		// native 的错误也带着 kind，脚本可以按 kind 分别处理。
+		{"send on closed channel", `var ch = channel(1); ch.close(); var r; try { ch.send(1); } catch (e) { r = e.kind; }`, "StateError"},
+		{"unknown channel property", `var r; try { channel().nope; } catch (e) { r = e.kind; }`, "PropertyError"},
+		{"exhausted generator", `fun* g() {} var r; try { g().next(); } catch (e) { r = e.kind; }`, "StateError"},
+		{"range step zero", `var r; try { range(0, 1, 0); } catch (e) { r = e.kind; }`, "ValueError"},
+		{"invalid json", `var r; try { json.parse("[1,"); } catch (e) { r = e.kind; }`, "ValueError"},
+		{"invalid regex", `var r; try { regex.compile("a("); } catch (e) { r = e.kind; }`, "ValueError"},
+		{"invalid format spec", `var r; try { format("{:q}", 1); } catch (e) { r = e.kind; }`, "ValueError"},
+		{"not iterable", `var r; try { for (x in 1) {} } catch (e) { r = e.kind; }`, "TypeError"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intp := runLox(t, tt.source)
+			if got := loxGlobal(t, intp, "r"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	uncatchable := []struct {
+		name   string
+		source string
+		opts   []interpreterOption
+	}{
+		{"step limit", `try { while (true) {} } catch (e) {}`, []interpreterOption{withMaxSteps(100)}},
+		{"exit", `try { exit(2); } catch (e) {}`, nil},
+		{"capability", `try { clock(); } catch (e) {}`, []interpreterOption{withCapabilities()}},
+	}
+	for _, tt := range uncatchable {
+		t.Run("uncatchable "+tt.name, func(t *testing.T) {
+			if _, err := execLox(tt.source, tt.opts...); err == nil {
+				t.Error("expect the error to escape try")
+			}
+		})
+	}
+}
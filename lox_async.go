@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errCoroutineCancelled 让 event loop 结束时还停在 await 上的 coroutine 一路返回，结束执行 body 的 goroutine。
+var errCoroutineCancelled = errors.New("coroutine cancelled")
+
+type promiseState int
+
+const (
+	promisePending promiseState = iota
+	promiseFulfilled
+	promiseRejected
+)
+
+// LoxPromise 是 async function 和 sleep 的返回值，完成之后由 event loop 执行等待它的回调。
+type LoxPromise struct {
+	name string
+	loop *eventLoop
+
+	mu        sync.Mutex
+	state     promiseState
+	value     interface{}
+	err       error
+	handled   bool // 被 await 过，reject 的时候错误已经交给了 await 的地方
+	callbacks []func() error
+}
+
+func newLoxPromise(name string, loop *eventLoop) *LoxPromise {
+	return &LoxPromise{
+		name: name,
+		loop: loop,
+	}
+}
+
+func (p *LoxPromise) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.state {
+	case promiseFulfilled:
+		return fmt.Sprintf("<promise: %s fulfilled>", p.name)
+	case promiseRejected:
+		return fmt.Sprintf("<promise: %s rejected>", p.name)
+	}
+	return fmt.Sprintf("<promise: %s pending>", p.name)
+}
+
+// settle 完成 promise，err 不为 nil 的时候是 reject，已经完成的 promise 不会再变。
+func (p *LoxPromise) settle(value interface{}, err error) {
+	p.mu.Lock()
+	if p.state != promisePending {
+		p.mu.Unlock()
+		return
+	}
+	p.value, p.err = value, err
+	p.state = promiseFulfilled
+	if err != nil {
+		p.state = promiseRejected
+	}
+	callbacks := p.callbacks
+	p.callbacks = nil
+	p.mu.Unlock()
+	if err != nil {
+		p.loop.reject(p)
+	}
+	for _, callback := range callbacks {
+		p.loop.enqueue(callback)
+	}
+}
+
+// onSettle 在 promise 完成之后由 event loop 执行 callback，已经完成的 promise 也要等到下一轮才执行。
+func (p *LoxPromise) onSettle(callback func() error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handled = true
+	if p.state == promisePending {
+		p.callbacks = append(p.callbacks, callback)
+		return
+	}
+	p.loop.enqueue(callback)
+}
+
+func (p *LoxPromise) isSettled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state != promisePending
+}
+
+func (p *LoxPromise) isHandled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.handled
+}
+
+// result 返回完成之后的值或者错误，取出错误也算是处理过了这个错误。
+func (p *LoxPromise) result() (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handled = true
+	return p.value, p.err
+}
+
+type awaitResult struct {
+	value interface{}
+	err   error
+}
+
+// coroutine 执行 async function 的 body，跟 generator 一样通过 channel 跟驱动它的一边交替执行。
+// 第一次由调用方驱动到第一个 await，之后由 event loop 在等待的 promise 完成的时候驱动。
+type coroutine struct {
+	intp    Interpreter
+	stmts   []Stmt
+	env     *Env
+	loop    *eventLoop
+	promise *LoxPromise
+	resume  chan awaitResult
+	// body 停在 await 上或者执行结束的时候通知驱动它的一边。
+	suspended chan struct{}
+}
+
+// newAsyncCall 开始执行 async function 的 body，执行到第一个 await 的时候返回 promise。
+func newAsyncCall(function *LoxFunction, intp Interpreter, env *Env) *LoxPromise {
+	loop := intp.EventLoop()
+	c := &coroutine{
+		intp:      intp,
+		stmts:     function.declaration.stmts,
+		env:       env,
+		loop:      loop,
+		promise:   newLoxPromise(function.name, loop),
+		resume:    make(chan awaitResult),
+		suspended: make(chan struct{}),
+	}
+	if v, ok := intp.(*interpreter); ok {
+		v.coroutine = c
+	}
+	go c.run()
+	<-c.suspended
+	return c.promise
+}
+
+func (c *coroutine) run() {
+	err := c.intp.ExecuteBlock(c.stmts, c.env)
+	var returnValue Return
+	switch {
+	case err == nil:
+		c.promise.settle(nil, nil)
+	case errors.As(err, &returnValue):
+		c.promise.settle(returnValue.result(c.intp))
+	case errors.Is(err, errCoroutineCancelled):
+	default:
+		c.promise.settle(nil, err)
+	}
+	c.suspended <- struct{}{}
+}
+
+// await 在 body 的 goroutine 中执行，把控制权交还给 event loop，等到 promise 完成之后再继续。
+func (c *coroutine) await(p *LoxPromise) (interface{}, error) {
+	p.onSettle(func() error {
+		c.loop.wake(c)
+		value, err := p.result()
+		c.resume <- awaitResult{value: value, err: err}
+		<-c.suspended
+		return nil
+	})
+	c.loop.suspend(c)
+	c.suspended <- struct{}{}
+	result := <-c.resume
+	return result.value, result.err
+}
+
+func (c *coroutine) cancel() {
+	c.resume <- awaitResult{err: errCoroutineCancelled}
+	<-c.suspended
+}
+
+// timerDelay 把毫秒数转换成 time.Duration，毫秒数必须是非负数。
+func timerDelay(name string, arg interface{}) (time.Duration, error) {
+	ms, ok := arg.(float64)
+	if !ok || ms < 0 {
+		return 0, newRuntimeError(ValueError, token{}, "%s delay must be a non-negative number of milliseconds, got %v", name, arg)
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// timerCallback 检查 callback 能不能不带参数调用，timer 到期的时候在新的 interpreter 中调用它。
+func timerCallback(name string, intp Interpreter, arg interface{}) (func() error, error) {
+	callee, ok := arg.(Callable)
+	if !ok || !callee.Arity().Accepts(0) {
+		return nil, newRuntimeError(TypeError, token{}, "%s expects a function without parameters, got %v", name, arg)
+	}
+	fork := intp.Fork()
+	return func() error {
+		_, err := fork.Call(callee, nil)
+		return err
+	}, nil
+}
+
+// newNativeFunctionSetTimeout 返回 setTimeout(fn, ms)，在 ms 毫秒之后调用 fn，返回 timer 的 id。
+func newNativeFunctionSetTimeout() *nativeFunction {
+	return newNativeFunction("setTimeout", fixedArity(2), func(intp Interpreter, args []interface{}) (interface{}, error) {
+		callback, err := timerCallback("setTimeout", intp, args[0])
+		if err != nil {
+			return nil, err
+		}
+		delay, err := timerDelay("setTimeout", args[1])
+		if err != nil {
+			return nil, err
+		}
+		return float64(intp.EventLoop().schedule(delay, 0, callback)), nil
+	}).requires(capabilityClock)
+}
+
+// newNativeFunctionSetInterval 返回 setInterval(fn, ms)，每隔 ms 毫秒调用一次 fn，直到被 clearTimer 取消。
+func newNativeFunctionSetInterval() *nativeFunction {
+	return newNativeFunction("setInterval", fixedArity(2), func(intp Interpreter, args []interface{}) (interface{}, error) {
+		callback, err := timerCallback("setInterval", intp, args[0])
+		if err != nil {
+			return nil, err
+		}
+		interval, err := timerDelay("setInterval", args[1])
+		if err != nil {
+			return nil, err
+		}
+		if interval == 0 {
+			return nil, newRuntimeError(ValueError, token{}, "setInterval interval must be greater than 0")
+		}
+		return float64(intp.EventLoop().schedule(interval, interval, callback)), nil
+	}).requires(capabilityClock)
+}
+
+// newNativeFunctionClearTimer 返回 clearTimer(id)，取消 setTimeout 或 setInterval 创建的 timer。
+func newNativeFunctionClearTimer() *nativeFunction {
+	return newNativeFunction("clearTimer", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+		id, ok := args[0].(float64)
+		if !ok {
+			return nil, newRuntimeError(TypeError, token{}, "clearTimer expects a timer id, got %v", args[0])
+		}
+		intp.EventLoop().cancel(int(id))
+		return nil, nil
+	}).requires(capabilityClock)
+}
+
+// newNativeFunctionSleep 返回 sleep(ms)，结果是一个 ms 毫秒之后完成的 promise。
+func newNativeFunctionSleep() *nativeFunction {
+	return newNativeFunction("sleep", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+		delay, err := timerDelay("sleep", args[0])
+		if err != nil {
+			return nil, err
+		}
+		loop := intp.EventLoop()
+		promise := newLoxPromise("sleep", loop)
+		loop.schedule(delay, 0, func() error {
+			promise.settle(nil, nil)
+			return nil
+		})
+		return promise, nil
+	}).requires(capabilityClock)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// LoxChannel 是 task 之间传递值的 channel。
+// 底层的 data 从来不会被 close，关闭是通过 close closing 来通知的，这样并发的 send 和 close 不会 panic。
+type LoxChannel struct {
+	data     chan interface{}
+	closing  chan struct{}
+	capacity int
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newLoxChannel(capacity int) *LoxChannel {
+	return &LoxChannel{
+		data:     make(chan interface{}, capacity),
+		closing:  make(chan struct{}),
+		capacity: capacity,
+	}
+}
+
+func (c *LoxChannel) String() string {
+	return fmt.Sprintf("<channel: capacity %d>", c.capacity)
+}
+
+func (c *LoxChannel) isClosed() bool {
+	select {
+	case <-c.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// Send 阻塞到值被接收（或者放进缓冲），channel 关闭之后再 send 会报错。
+// 阻塞的过程中 budget 超时或者被取消的时候返回 BudgetExceededError。
+func (c *LoxChannel) Send(budget *executionBudget, value interface{}) error {
+	if c.isClosed() {
+		return newRuntimeError(StateError, token{}, "send on closed channel")
+	}
+	select {
+	case c.data <- value:
+		return nil
+	case <-c.closing:
+		return newRuntimeError(StateError, token{}, "send on closed channel")
+	case <-budget.done():
+		return budget.exceeded()
+	}
+}
+
+// Receive 阻塞到收到一个值，channel 关闭并且缓冲中的值都被取完之后返回 false。
+func (c *LoxChannel) Receive(budget *executionBudget) (interface{}, bool, error) {
+	select {
+	case v := <-c.data:
+		return v, true, nil
+	case <-c.closing:
+		v, ok := c.drain()
+		return v, ok, nil
+	case <-budget.done():
+		return nil, false, budget.exceeded()
+	}
+}
+
+// drain 在 channel 关闭之后取出缓冲中剩下的值。
+func (c *LoxChannel) drain() (interface{}, bool) {
+	select {
+	case v := <-c.data:
+		return v, true
+	default:
+		return nil, false
+	}
+}
+
+func (c *LoxChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return newRuntimeError(StateError, token{}, "close of closed channel")
+	}
+	c.closed = true
+	close(c.closing)
+	return nil
+}
+
+func (c *LoxChannel) Get(name token) (interface{}, error) {
+	switch name.Lexeme {
+	case "send":
+		return newNativeFunction("send", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return nil, c.Send(intp.Budget(), args[0])
+		}), nil
+	case "receive":
+		return newNativeFunction("receive", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			v, _, err := c.Receive(intp.Budget())
+			return v, err
+		}), nil
+	case "close":
+		return newNativeFunction("close", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return nil, c.Close()
+		}), nil
+	case "iterator":
+		return newNativeFunction("iterator", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return c.Iterator(intp.Budget()), nil
+		}), nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in channel", name.Lexeme)
+}
+
+// Iterator 一直接收到 channel 被关闭为止。
+func (c *LoxChannel) Iterator(budget *executionBudget) *LoxIterator {
+	var next interface{}
+	var received bool
+	return newLoxIterator(func() (bool, error) {
+		if !received {
+			var ok bool
+			var err error
+			next, ok, err = c.Receive(budget)
+			if err != nil || !ok {
+				return false, err
+			}
+			received = true
+		}
+		return true, nil
+	}, func() interface{} {
+		received = false
+		return next
+	})
+}
+
+func newNativeFunctionChannel() *nativeFunction {
+	return newNativeFunction("channel", Arity{Min: 0, Max: 1}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+		var capacity float64
+		if len(args) > 0 {
+			v, ok := args[0].(float64)
+			if !ok || v < 0 || v != math.Trunc(v) {
+				return nil, newRuntimeError(ValueError, token{}, "channel capacity must be a non-negative integer, got %v", args[0])
+			}
+			capacity = v
+		}
+		return newLoxChannel(int(capacity)), nil
+	})
+}
+
+// LoxTask 是 spawn 的返回值，join() 等待 task 结束并返回它的结果。
+type LoxTask struct {
+	name  string
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// spawnTask 在新的 goroutine 中用 fork 出来的 interpreter 调用 callee。
+func spawnTask(intp Interpreter, callee Callable, args []interface{}) *LoxTask {
+	task := &LoxTask{
+		name: callee.String(),
+		done: make(chan struct{}),
+	}
+	fork := intp.Fork()
+	go func() {
+		defer close(task.done)
+		defer func() {
+			if r := recover(); r != nil {
+				task.err = newRuntimeError(InternalError, token{}, "task %s panicked: %v", task.name, r)
+			}
+		}()
+		task.value, task.err = fork.Call(callee, args)
+	}()
+	return task
+}
+
+func (t *LoxTask) String() string {
+	return fmt.Sprintf("<task: %s>", t.name)
+}
+
+// Join 等待 task 结束，task 中的错误会在 join 的地方返回。
+func (t *LoxTask) Join(budget *executionBudget) (interface{}, error) {
+	select {
+	case <-t.done:
+		return t.value, t.err
+	case <-budget.done():
+		return nil, budget.exceeded()
+	}
+}
+
+func (t *LoxTask) Get(name token) (interface{}, error) {
+	switch name.Lexeme {
+	case "join":
+		return newNativeFunction("join", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return t.Join(intp.Budget())
+		}), nil
+	case "isDone":
+		return newNativeFunction("isDone", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			select {
+			case <-t.done:
+				return true, nil
+			default:
+				return false, nil
+			}
+		}), nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in task", name.Lexeme)
+}
+
+// selectOperation 是 select 中一个 case 求值之后的结果，default case 的 channel 为 nil。
+type selectOperation struct {
+	channel *LoxChannel
+	send    bool
+	value   interface{}
+}
+
+// selectChannels 阻塞到其中一个操作可以完成，返回完成的 case 的下标和接收到的值。
+// 每个 channel 操作对应两个 reflect.SelectCase：一个是 data，一个是 closing。
+// 最后一个 SelectCase 等待 budget 结束，这时候返回 BudgetExceededError。
+func selectChannels(budget *executionBudget, operations []selectOperation) (int, interface{}, error) {
+	type owner struct {
+		operation int
+		closing   bool
+	}
+	var cases []reflect.SelectCase
+	var owners []owner
+	for idx, operation := range operations {
+		if operation.channel == nil {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+			owners = append(owners, owner{operation: idx})
+			continue
+		}
+		if operation.send {
+			value := reflect.New(reflect.TypeOf((*interface{})(nil)).Elem()).Elem()
+			if operation.value != nil {
+				value.Set(reflect.ValueOf(operation.value))
+			}
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(operation.channel.data), Send: value})
+		} else {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(operation.channel.data)})
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(operation.channel.closing)})
+		owners = append(owners, owner{operation: idx}, owner{operation: idx, closing: true})
+	}
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(budget.done())})
+	chosen, recv, _ := reflect.Select(cases)
+	if chosen == len(owners) {
+		return 0, nil, budget.exceeded()
+	}
+	idx := owners[chosen].operation
+	operation := operations[idx]
+	switch {
+	case operation.channel == nil:
+		return idx, nil, nil
+	case owners[chosen].closing:
+		if operation.send {
+			return idx, nil, newRuntimeError(StateError, token{}, "send on closed channel")
+		}
+		v, _ := operation.channel.drain()
+		return idx, v, nil
+	case operation.send:
+		return idx, nil, nil
+	default:
+		return idx, recv.Interface(), nil
+	}
+}
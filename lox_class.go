@@ -1,31 +1,60 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
 
 type LoxClass struct {
 	superclass *LoxClass
 	name       string
 	methods    map[string]*LoxFunction
+
+	// class 本身也是一个对象，static method 和 static field 都挂在 class 上，并且可以被 subclass 继承。
+	staticMethods map[string]*LoxFunction
+	staticFields  map[string]interface{}
+	// 只有 staticFields 在 class 创建之后还会被修改，所以只有它需要加锁。
+	mu sync.RWMutex
+
+	// private member 只属于声明它的 class，不会被 subclass 继承。
+	// private field 在创建 instance 的时候用 privateEnv 求初始值。
+	privateMethods map[string]*LoxFunction
+	privateFields  []VarStmt
+	privateEnv     *Env
 }
 
 func newLoxClass(name string) *LoxClass {
 	return &LoxClass{
-		name: name,
+		name:          name,
+		staticMethods: make(map[string]*LoxFunction),
+		staticFields:  make(map[string]interface{}),
+
+		privateMethods: make(map[string]*LoxFunction),
 	}
 }
 
 func newLoxClassWithMethods(name string, methods map[string]*LoxFunction) *LoxClass {
 	return &LoxClass{
-		name:    name,
-		methods: methods,
+		name:          name,
+		methods:       methods,
+		staticMethods: make(map[string]*LoxFunction),
+		staticFields:  make(map[string]interface{}),
+
+		privateMethods: make(map[string]*LoxFunction),
 	}
 }
 
 func newLoxClassWithSuperClass(name string, superclass *LoxClass, methods map[string]*LoxFunction) *LoxClass {
 	return &LoxClass{
-		name:       name,
-		methods:    methods,
-		superclass: superclass,
+		name:          name,
+		methods:       methods,
+		superclass:    superclass,
+		staticMethods: make(map[string]*LoxFunction),
+		staticFields:  make(map[string]interface{}),
+
+		privateMethods: make(map[string]*LoxFunction),
 	}
 }
 
@@ -33,19 +62,37 @@ func (c *LoxClass) String() string {
 	return fmt.Sprintf("<class: %s >", c.name)
 }
 
-func (c *LoxClass) Arity() int {
+func (c *LoxClass) Arity() Arity {
 	initFunction, err := c.FindMethod("init")
 	if err != nil {
-		return 0
+		return fixedArity(0)
 	}
 	if initFunction != nil {
 		return initFunction.Arity()
 	}
-	return 0
+	return fixedArity(0)
+}
+
+// ParamNames 返回 init 的参数名，这样构造的时候也可以使用具名参数。
+func (c *LoxClass) ParamNames() []string {
+	initFunction, err := c.FindMethod("init")
+	if err != nil || initFunction == nil {
+		return []string{}
+	}
+	return initFunction.ParamNames()
 }
 
 func (c *LoxClass) Call(intp Interpreter, args []interface{}) (interface{}, error) {
+	if missing := c.abstractMethodNames(); len(missing) > 0 {
+		return nil, newRuntimeError(TypeError, token{}, "cannot instantiate abstract class %s, unimplemented abstract methods: %s", c.name, strings.Join(missing, ", ")).withValues(c)
+	}
+	if err := intp.Memory().charge(memoryInstances, instanceOverhead); err != nil {
+		return nil, err
+	}
 	instance := newLoxInstance(c)
+	if err := c.initPrivateFields(intp, instance); err != nil {
+		return nil, err
+	}
 	initFunction, err := c.FindMethod("init")
 	if err != nil {
 		return nil, err
@@ -60,6 +107,41 @@ func (c *LoxClass) Call(intp Interpreter, args []interface{}) (interface{}, erro
 	return instance, nil
 }
 
+// initPrivateFields 从最顶层的 superclass 开始，依次初始化每个 class 声明的 private field。
+func (c *LoxClass) initPrivateFields(intp Interpreter, instance *LoxInstance) error {
+	if c.superclass != nil {
+		if err := c.superclass.initPrivateFields(intp, instance); err != nil {
+			return err
+		}
+	}
+	if len(c.privateFields) == 0 {
+		return nil
+	}
+	env := newEnvWithEnclosing(c.privateEnv)
+	env.Define("this", instance)
+	for _, field := range c.privateFields {
+		var value interface{}
+		if field.expr != nil {
+			var err error
+			value, err = intp.Evaluate(field.expr, env)
+			if err != nil {
+				return err
+			}
+		}
+		instance.setPrivateField(c, field.name.Lexeme, value)
+	}
+	return nil
+}
+
+// setter 跟 method 存在同一个 map 中，key 中带空格，不会跟任何 identifier 冲突。
+func setterKey(name string) string {
+	return "set " + name
+}
+
+func (c *LoxClass) FindSetter(name string) (*LoxFunction, error) {
+	return c.FindMethod(setterKey(name))
+}
+
 func (c *LoxClass) FindMethod(name string) (*LoxFunction, error) {
 	v, ok := c.methods[name]
 	if ok {
@@ -70,3 +152,85 @@ func (c *LoxClass) FindMethod(name string) (*LoxFunction, error) {
 	}
 	return nil, nil
 }
+
+// abstractMethodNames 返回 class（包括继承来的）还没有实现的 abstract method，按名字排序。
+func (c *LoxClass) abstractMethodNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for class := c; class != nil; class = class.superclass {
+		for name := range class.methods {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			// subclass 中的同名 method 在前面已经处理过了，所以这里就是实际会被调用的 method。
+			if class.methods[name].declaration.isAbstract {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkInterfaces 检查 class 是否实现了 interface 中的所有 method，并且参数个数兼容。
+// abstract method 也算实现了，因为 abstract class 本身不能被实例化。
+func (c *LoxClass) checkInterfaces(interfaces []*LoxInterface) error {
+	var problems []string
+	for _, iface := range interfaces {
+		for _, method := range iface.methods {
+			name := method.name.Lexeme
+			arity := method.arity()
+			implementation, err := c.FindMethod(name)
+			if err != nil {
+				return err
+			}
+			if implementation == nil || implementation.isGetter {
+				problems = append(problems, fmt.Sprintf("missing method %s of interface %s", name, iface.name))
+				continue
+			}
+			if !implementation.Arity().Covers(arity) {
+				problems = append(problems, fmt.Sprintf("method %s accepts %s arguments but interface %s requires %s", name, implementation.Arity(), iface.name, arity))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return newRuntimeError(TypeError, token{}, "class %s does not conform to its interfaces: %s", c.name, strings.Join(problems, "; ")).withValues(c)
+	}
+	return nil
+}
+
+// isSubclassOf 判断 c 是不是 other 本身或者 other 的 subclass。
+func (c *LoxClass) isSubclassOf(other *LoxClass) bool {
+	for class := c; class != nil; class = class.superclass {
+		if class == other {
+			return true
+		}
+	}
+	return false
+}
+
+// Get 读取 class 上的 static field 或 static method，找不到的时候沿着 superclass 继续找。
+// static method 中的 this 绑定的是被访问的 class，而不是定义 method 的 class。
+func (c *LoxClass) Get(name token) (interface{}, error) {
+	for class := c; class != nil; class = class.superclass {
+		class.mu.RLock()
+		v, ok := class.staticFields[name.Lexeme]
+		class.mu.RUnlock()
+		if ok {
+			return v, nil
+		}
+		if v, ok := class.staticMethods[name.Lexeme]; ok {
+			return v.Bind(c)
+		}
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in class %s", name.Lexeme, c.name).withValues(c)
+}
+
+// Set 总是写到当前 class 上，不会修改 superclass 的 static field。
+func (c *LoxClass) Set(name token, value interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.staticFields[name.Lexeme] = value
+	return nil
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"time"
+	// 内嵌 IANA 的时区数据，没有安装 tzdata 的系统上也可以转换时区。
+	_ "time/tzdata"
+)
+
+// isoLayout 是 datetime 默认的字符串形式，精确到毫秒。
+const isoLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// isoParseLayouts 是 datetime.parse 接受的 ISO-8601 格式，没有 offset 的时候使用参数中的时区。
+var isoParseLayouts = []struct {
+	layout    string
+	hasOffset bool
+}{
+	{time.RFC3339Nano, true},
+	{"2006-01-02T15:04Z07:00", true},
+	{"2006-01-02T15:04:05.999999999", false},
+	{"2006-01-02T15:04", false},
+	{"2006-01-02", false},
+}
+
+// LoxDateTime 是一个带着时区的时间点。
+type LoxDateTime struct {
+	t time.Time
+}
+
+func (d *LoxDateTime) String() string {
+	return d.t.Format(isoLayout)
+}
+
+// LoxDuration 是两个时间点之间的间隔，精确到纳秒，范围大约是正负 292 年。
+type LoxDuration struct {
+	d time.Duration
+}
+
+func (d *LoxDuration) String() string {
+	return d.d.String()
+}
+
+// loadZone 按 IANA 的名字加载时区，比如 "Asia/Shanghai"，"UTC" 和 "Local" 也可以使用。
+func loadZone(native string, arg interface{}) (*time.Location, error) {
+	name, ok := arg.(string)
+	if !ok {
+		return nil, newRuntimeError(TypeError, token{}, "%s expects a time zone name, got %v", native, arg)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, newRuntimeError(ValueError, token{}, "%s: unknown time zone %q", native, name)
+	}
+	return loc, nil
+}
+
+// optionalZone 返回第 idx 个参数表示的时区，没有传值的时候是 UTC。
+func optionalZone(native string, args []interface{}, idx int) (*time.Location, error) {
+	arg, ok := optionalArg(args, idx)
+	if !ok {
+		return time.UTC, nil
+	}
+	return loadZone(native, arg)
+}
+
+// intArg 检查参数是 [min, max] 之间的整数。
+func intArg(native, param string, arg interface{}, min, max int) (int, error) {
+	f, ok := arg.(float64)
+	if !ok || f != math.Trunc(f) || f < float64(min) || f > float64(max) {
+		return 0, newRuntimeError(ValueError, token{}, "%s: %s must be an integer between %d and %d, got %v", native, param, min, max, loxString(arg))
+	}
+	return int(f), nil
+}
+
+// durationOf 把纳秒数转换成 duration，超出范围的时候返回错误。
+func durationOf(nanos float64) (*LoxDuration, error) {
+	if math.IsNaN(nanos) || nanos >= math.MaxInt64 || nanos < math.MinInt64 {
+		return nil, newRuntimeError(ValueError, token{}, "duration out of range")
+	}
+	return &LoxDuration{d: time.Duration(nanos)}, nil
+}
+
+// newDateTimeOf 是 datetime.of，月份从 1 开始，超出范围的参数会返回错误而不是进位。
+func newDateTimeOf(args []interface{}) (interface{}, error) {
+	params := []struct {
+		name     string
+		min, max int
+	}{
+		{"year", 1, 9999},
+		{"month", 1, 12},
+		{"day", 1, 31},
+		{"hour", 0, 23},
+		{"minute", 0, 59},
+		{"second", 0, 59},
+		{"millisecond", 0, 999},
+	}
+	values := make([]int, len(params))
+	for idx, param := range params {
+		arg, ok := optionalArg(args, idx)
+		if !ok {
+			continue
+		}
+		v, err := intArg("datetime.of", param.name, arg, param.min, param.max)
+		if err != nil {
+			return nil, err
+		}
+		values[idx] = v
+	}
+	loc, err := optionalZone("datetime.of", args, len(params))
+	if err != nil {
+		return nil, err
+	}
+	t := time.Date(values[0], time.Month(values[1]), values[2], values[3], values[4], values[5], values[6]*int(time.Millisecond), loc)
+	if t.Day() != values[2] {
+		return nil, newRuntimeError(ValueError, token{}, "datetime.of: day %d out of range for %d-%02d", values[2], values[0], values[1])
+	}
+	return &LoxDateTime{t: t}, nil
+}
+
+func parseDateTime(args []interface{}) (interface{}, error) {
+	text, ok := args[0].(string)
+	if !ok {
+		return nil, newRuntimeError(TypeError, token{}, "datetime.parse expects a string, got %v", args[0])
+	}
+	loc, err := optionalZone("datetime.parse", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range isoParseLayouts {
+		var t time.Time
+		var err error
+		if l.hasOffset {
+			t, err = time.Parse(l.layout, text)
+		} else {
+			t, err = time.ParseInLocation(l.layout, text, loc)
+		}
+		if err == nil {
+			return &LoxDateTime{t: t}, nil
+		}
+	}
+	return nil, newRuntimeError(ValueError, token{}, "datetime.parse: cannot parse %q as an ISO-8601 date time", text)
+}
+
+func (d *LoxDateTime) Get(name token) (interface{}, error) {
+	fields := map[string]func() int{
+		"year":        d.t.Year,
+		"month":       func() int { return int(d.t.Month()) },
+		"day":         d.t.Day,
+		"hour":        d.t.Hour,
+		"minute":      d.t.Minute,
+		"second":      d.t.Second,
+		"millisecond": func() int { return d.t.Nanosecond() / int(time.Millisecond) },
+		// 0 是星期日。
+		"weekday": func() int { return int(d.t.Weekday()) },
+		"yearDay": d.t.YearDay,
+	}
+	if field, ok := fields[name.Lexeme]; ok {
+		return newNativeFunction(name.Lexeme, fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return float64(field()), nil
+		}), nil
+	}
+	switch name.Lexeme {
+	case "zone":
+		return newNativeFunction("zone", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return d.t.Location().String(), nil
+		}), nil
+	case "offset":
+		// offset 是相对 UTC 的偏移，单位是分钟。
+		return newNativeFunction("offset", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			_, offset := d.t.Zone()
+			return float64(offset / 60), nil
+		}), nil
+	case "unixMillis":
+		return newNativeFunction("unixMillis", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return float64(d.t.UnixMilli()), nil
+		}), nil
+	case "in":
+		return newNativeFunction("in", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			loc, err := loadZone("in", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &LoxDateTime{t: d.t.In(loc)}, nil
+		}), nil
+	case "addDate":
+		// addDate 按日历加减年、月、日，跟 go 的 AddDate 一样会进位，比如 1 月 31 日加一个月是 3 月 2 日或者 3 日。
+		return newNativeFunction("addDate", Arity{Min: 0, Max: 3}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+			var values [3]int
+			for idx, param := range []string{"years", "months", "days"} {
+				arg, ok := optionalArg(args, idx)
+				if !ok {
+					continue
+				}
+				v, err := intArg("addDate", param, arg, -1000000, 1000000)
+				if err != nil {
+					return nil, err
+				}
+				values[idx] = v
+			}
+			return &LoxDateTime{t: d.t.AddDate(values[0], values[1], values[2])}, nil
+		}).withParams("years", "months", "days"), nil
+	case "format":
+		// layout 使用 go 的参考时间 "2006-01-02 15:04:05"，没有传的时候是 ISO-8601。
+		return newNativeFunction("format", Arity{Min: 0, Max: 1}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+			if len(args) == 0 {
+				return d.String(), nil
+			}
+			layout, ok := args[0].(string)
+			if !ok {
+				return nil, newRuntimeError(TypeError, token{}, "format expects a layout string, got %v", args[0])
+			}
+			return d.t.Format(layout), nil
+		}), nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in datetime", name.Lexeme)
+}
+
+// operate 支持 datetime ± duration、datetime - datetime 和比较，比较的是时间点，跟时区无关。
+func (d *LoxDateTime) operate(operatorType uint, other interface{}, reflected bool) (interface{}, bool, error) {
+	switch o := other.(type) {
+	case *LoxDuration:
+		switch {
+		case operatorType == PLUS:
+			return &LoxDateTime{t: d.t.Add(o.d)}, true, nil
+		case operatorType == MINUS && !reflected:
+			return &LoxDateTime{t: d.t.Add(-o.d)}, true, nil
+		}
+	case *LoxDateTime:
+		left, right := d.t, o.t
+		if reflected {
+			left, right = right, left
+		}
+		if operatorType == MINUS {
+			return &LoxDuration{d: left.Sub(right)}, true, nil
+		}
+		cmp := 0
+		if left.Before(right) {
+			cmp = -1
+		} else if left.After(right) {
+			cmp = 1
+		}
+		return compare(operatorType, cmp)
+	}
+	if operatorType == EQUAL_EQUAL {
+		return false, true, nil
+	}
+	return nil, false, nil
+}
+
+// compare 把比较的结果 cmp（-1、0 或者 1）转换成比较运算符的结果。
+func compare(operatorType uint, cmp int) (interface{}, bool, error) {
+	switch operatorType {
+	case LESS:
+		return cmp < 0, true, nil
+	case LESS_EQUAL:
+		return cmp <= 0, true, nil
+	case GREATER:
+		return cmp > 0, true, nil
+	case GREATER_EQUAL:
+		return cmp >= 0, true, nil
+	case EQUAL_EQUAL:
+		return cmp == 0, true, nil
+	}
+	return nil, false, nil
+}
+
+func (d *LoxDuration) Get(name token) (interface{}, error) {
+	units := map[string]time.Duration{
+		"milliseconds": time.Millisecond,
+		"seconds":      time.Second,
+		"minutes":      time.Minute,
+		"hours":        time.Hour,
+		"days":         24 * time.Hour,
+	}
+	if unit, ok := units[name.Lexeme]; ok {
+		return newNativeFunction(name.Lexeme, fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return float64(d.d) / float64(unit), nil
+		}), nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in duration", name.Lexeme)
+}
+
+// operate 支持 duration 之间的加减和比较，duration 乘除 number，以及 duration / duration 得到比例。
+func (d *LoxDuration) operate(operatorType uint, other interface{}, reflected bool) (interface{}, bool, error) {
+	switch o := other.(type) {
+	case *LoxDuration:
+		left, right := d.d, o.d
+		if reflected {
+			left, right = right, left
+		}
+		switch operatorType {
+		case PLUS:
+			v, err := durationOf(float64(left) + float64(right))
+			return v, true, err
+		case MINUS:
+			v, err := durationOf(float64(left) - float64(right))
+			return v, true, err
+		case SLASH:
+			if right == 0 {
+				return nil, true, newRuntimeError(ZeroDivision, token{}, "division of duration by zero duration").withValues(d, o)
+			}
+			return float64(left) / float64(right), true, nil
+		}
+		cmp := 0
+		if left < right {
+			cmp = -1
+		} else if left > right {
+			cmp = 1
+		}
+		return compare(operatorType, cmp)
+	case float64:
+		switch {
+		case operatorType == STAR:
+			v, err := durationOf(float64(d.d) * o)
+			return v, true, err
+		case operatorType == SLASH && !reflected:
+			if o == 0 {
+				return nil, true, newRuntimeError(ZeroDivision, token{}, "division of duration by zero").withValues(d, o)
+			}
+			v, err := durationOf(float64(d.d) / o)
+			return v, true, err
+		}
+	}
+	if operatorType == EQUAL_EQUAL {
+		return false, true, nil
+	}
+	return nil, false, nil
+}
+
+// newDatetimeNamespace 返回 datetime namespace，时区的参数是 IANA 的名字，没有传的时候是 UTC。
+// datetime.now 使用 event loop 的 clock，测试中可以通过 withClock 注入固定的时间。
+func newDatetimeNamespace() *LoxNamespace {
+	return newLoxNamespace("datetime",
+		newNativeFunction("now", Arity{Min: 0, Max: 1}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+			loc, err := optionalZone("datetime.now", args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return &LoxDateTime{t: intp.EventLoop().clock.Now().In(loc)}, nil
+		}).withParams("zone").requires(capabilityClock),
+		newNativeFunction("of", Arity{Min: 3, Max: 8}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return newDateTimeOf(args)
+		}).withParams("year", "month", "day", "hour", "minute", "second", "millisecond", "zone"),
+		newNativeFunction("parse", Arity{Min: 1, Max: 2}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return parseDateTime(args)
+		}).withParams("text", "zone"),
+		newNativeFunction("fromUnixMillis", Arity{Min: 1, Max: 2}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+			ms, ok := args[0].(float64)
+			if !ok || ms != math.Trunc(ms) {
+				return nil, newRuntimeError(TypeError, token{}, "datetime.fromUnixMillis expects an integer, got %v", args[0])
+			}
+			loc, err := optionalZone("datetime.fromUnixMillis", args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return &LoxDateTime{t: time.UnixMilli(int64(ms)).In(loc)}, nil
+		}).withParams("millis", "zone"),
+		newNativeFunction("duration", Arity{Min: 0, Max: 5}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+			units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second, time.Millisecond}
+			var nanos float64
+			for idx, unit := range units {
+				arg, ok := optionalArg(args, idx)
+				if !ok {
+					continue
+				}
+				v, ok := arg.(float64)
+				if !ok {
+					return nil, newRuntimeError(TypeError, token{}, "datetime.duration expects numbers, got %v", loxString(arg))
+				}
+				nanos += v * float64(unit)
+			}
+			return durationOf(nanos)
+		}).withParams("days", "hours", "minutes", "seconds", "milliseconds"),
+		newNativeFunction("parseDuration", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			text, ok := args[0].(string)
+			if !ok {
+				return nil, newRuntimeError(TypeError, token{}, "datetime.parseDuration expects a string, got %v", args[0])
+			}
+			// 格式跟 go 的 time.ParseDuration 一样，比如 "1h30m" 和 "-2.5s"。
+			d, err := time.ParseDuration(strings.TrimSpace(text))
+			if err != nil {
+				return nil, newRuntimeError(ValueError, token{}, "datetime.parseDuration: cannot parse %q", text)
+			}
+			return &LoxDuration{d: d}, nil
+		}),
+	)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fsSandbox 把 fs namespace 中的路径限制在 root 目录下，root 为空的时候不能访问文件系统。
+type fsSandbox struct {
+	root string
+	// root 解析了 symlink 之后的绝对路径，用来判断路径是不是在 root 下面。
+	realRoot string
+	err      error
+}
+
+func newFSSandbox(root string) *fsSandbox {
+	s := &fsSandbox{root: root}
+	if root == "" {
+		return s
+	}
+	abs, err := filepath.Abs(root)
+	if err == nil {
+		s.realRoot, err = filepath.EvalSymlinks(abs)
+	}
+	if err != nil {
+		s.err = err
+	}
+	return s
+}
+
+// resolve 把 lox 代码中的路径转成实际的路径，相对路径相对于 root。
+// 解析 symlink 之后不在 root 下面的路径会返回错误。
+func (s *fsSandbox) resolve(path string) (string, error) {
+	if s.root == "" {
+		return "", newRuntimeError(IOError, token{}, "file system access is not configured, cannot access %s", path).withValues(path)
+	}
+	if s.err != nil {
+		return "", newRuntimeError(IOError, token{}, "invalid file system root %s: %s", s.root, s.err).withValues(path)
+	}
+	p := path
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(s.realRoot, p)
+	}
+	p = filepath.Clean(p)
+	real, err := evalExistingSymlinks(p)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(s.realRoot, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", newRuntimeError(IOError, token{}, "path %s is outside of the root directory %s", path, s.root).withValues(path)
+	}
+	return real, nil
+}
+
+// maxSymlinks 是解析一个路径的时候最多跟随的 symlink 的个数，跟 linux 的 MAXSYMLINKS 一样。
+const maxSymlinks = 40
+
+// evalExistingSymlinks 解析 path 中已经存在的部分的 symlink，不存在的部分原样拼在后面。
+// 指向不存在的路径的 symlink 也要解析，否则创建文件的时候会跟随它写到 root 外面。
+func evalExistingSymlinks(path string) (string, error) {
+	var rest []string
+	links := 0
+	for {
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{real}, rest...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if info, lstatErr := os.Lstat(path); lstatErr == nil && info.Mode()&fs.ModeSymlink != 0 {
+			links++
+			if links > maxSymlinks {
+				return "", &fs.PathError{Op: "resolve", Path: path, Err: errors.New("too many levels of symbolic links")}
+			}
+			target, err := os.Readlink(path)
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			path = filepath.Clean(target)
+			continue
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = parent
+	}
+}
+
+// ioError 把文件操作的错误转成 lox 中可以 catch 的 IOError，host 仍然可以用 errors.Is 判断原来的错误。
+func ioError(native string, path interface{}, err error) error {
+	return newRuntimeError(IOError, token{}, "%s %s: %s", native, loxString(path), err).withValues(path).withCause(err)
+}
+
+// fsPath 检查参数是一个 string，并把它解析成 root 下面的路径。
+func (s *fsSandbox) fsPath(native string, arg interface{}) (string, error) {
+	path, ok := arg.(string)
+	if !ok {
+		return "", newRuntimeError(TypeError, token{}, "%s expects a path string, got %s", native, loxString(arg)).withValues(arg)
+	}
+	real, err := s.resolve(path)
+	var runtimeErr *RuntimeError
+	if err != nil && !errors.As(err, &runtimeErr) {
+		return "", ioError(native, path, err)
+	}
+	return real, err
+}
+
+// newFSNamespace 返回 fs namespace，读操作需要 fs-read，写操作需要 fs-write。
+func newFSNamespace(s *fsSandbox) *LoxNamespace {
+	return newLoxNamespace("fs",
+		newNativeFunction("readFile", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			path, err := s.fsPath("readFile", args[0])
+			if err != nil {
+				return nil, err
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, ioError("readFile", args[0], err)
+			}
+			if err := intp.Memory().chargeString(string(content)); err != nil {
+				return nil, err
+			}
+			return string(content), nil
+		}).requires(capabilityFSRead),
+		newNativeFunction("readLines", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			path, err := s.fsPath("readLines", args[0])
+			if err != nil {
+				return nil, err
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, ioError("readLines", args[0], err)
+			}
+			text := strings.TrimSuffix(string(content), "\n")
+			var lines []interface{}
+			if text != "" {
+				for _, line := range strings.Split(text, "\n") {
+					lines = append(lines, strings.TrimSuffix(line, "\r"))
+				}
+			}
+			bytes := collectionCost + int64(len(lines))*(elementCost+stringOverhead) + int64(len(content))
+			if err := intp.Memory().charge(memoryCollections, bytes); err != nil {
+				return nil, err
+			}
+			return newLoxList(lines), nil
+		}).requires(capabilityFSRead),
+		newNativeFunction("exists", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			path, err := s.fsPath("exists", args[0])
+			if err != nil {
+				return nil, err
+			}
+			_, err = os.Stat(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				return false, nil
+			}
+			if err != nil {
+				return nil, ioError("exists", args[0], err)
+			}
+			return true, nil
+		}).requires(capabilityFSRead),
+		newNativeFunction("listDir", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			path, err := s.fsPath("listDir", args[0])
+			if err != nil {
+				return nil, err
+			}
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return nil, ioError("listDir", args[0], err)
+			}
+			names := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			sort.Strings(names)
+			elements := make([]interface{}, 0, len(names))
+			bytes := int64(collectionCost)
+			for _, name := range names {
+				elements = append(elements, name)
+				bytes += elementCost + stringOverhead + int64(len(name))
+			}
+			if err := intp.Memory().charge(memoryCollections, bytes); err != nil {
+				return nil, err
+			}
+			return newLoxList(elements), nil
+		}).requires(capabilityFSRead),
+		newNativeFunction("writeFile", fixedArity(2), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return nil, s.write("writeFile", args, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		}).requires(capabilityFSWrite),
+		newNativeFunction("appendFile", fixedArity(2), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return nil, s.write("appendFile", args, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+		}).requires(capabilityFSWrite),
+		newNativeFunction("mkdir", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			path, err := s.fsPath("mkdir", args[0])
+			if err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return nil, ioError("mkdir", args[0], err)
+			}
+			return nil, nil
+		}).requires(capabilityFSWrite),
+		newNativeFunction("remove", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			path, err := s.fsPath("remove", args[0])
+			if err != nil {
+				return nil, err
+			}
+			if path == s.realRoot {
+				return nil, newRuntimeError(IOError, token{}, "remove %s: cannot remove the root directory", loxString(args[0])).withValues(args[0])
+			}
+			// 只删除文件和空目录，不递归删除。
+			if err := os.Remove(path); err != nil {
+				return nil, ioError("remove", args[0], err)
+			}
+			return nil, nil
+		}).requires(capabilityFSWrite),
+	)
+}
+
+// write 把 args[1] 写到 args[0]，flag 决定是覆盖还是追加。
+func (s *fsSandbox) write(native string, args []interface{}, flag int) error {
+	path, err := s.fsPath(native, args[0])
+	if err != nil {
+		return err
+	}
+	content, ok := args[1].(string)
+	if !ok {
+		return newRuntimeError(TypeError, token{}, "%s expects string content, got %s", native, loxString(args[1])).withValues(args[1])
+	}
+	f, err := os.OpenFile(path, flag, 0o644)
+	if err != nil {
+		return ioError(native, args[0], err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return ioError(native, args[0], err)
+	}
+	if err := f.Close(); err != nil {
+		return ioError(native, args[0], err)
+	}
+	return nil
+}
@@ -9,9 +9,11 @@ type LoxFunction struct {
 	declaration    FunctionStmt
 	closure        *Env
 	isInitlializer bool
+	isGetter       bool // getter 在访问属性的时候直接被调用
 }
 
 func newLoxFunction(stmt FunctionStmt, env *Env, isInitlializer bool) *LoxFunction {
+	env.capture()
 	return &LoxFunction{
 		declaration:    stmt,
 		name:           stmt.name.Lexeme,
@@ -24,33 +26,101 @@ func (f *LoxFunction) String() string {
 	return "<function: " + f.name + ">"
 }
 
-func (f *LoxFunction) Arity() int {
-	return len(f.declaration.params)
+func (f *LoxFunction) Arity() Arity {
+	return f.declaration.arity()
 }
 
-func (f *LoxFunction) Call(intp Interpreter, args []interface{}) (interface{}, error) {
-	env := newEnvWithEnclosing(f.closure)
-	for i, v := range f.declaration.params {
-		env.Define(v.Lexeme, args[i])
+func (f *LoxFunction) ParamNames() []string {
+	names := make([]string, 0, len(f.declaration.params))
+	for _, param := range f.declaration.params {
+		names = append(names, param.Lexeme)
 	}
-	if err := intp.ExecuteBlock(f.declaration.stmts, env); err != nil {
-		var returnValue Return
-		if errors.As(err, &returnValue) {
+	return names
+}
+
+// Call 是一个 trampoline：body 以尾调用结束的时候，在这里的循环中调用下一个函数，
+// 这样尾递归不会让 go 的调用栈随着递归的深度增长。
+func (f *LoxFunction) Call(intp Interpreter, args []interface{}) (interface{}, error) {
+	for {
+		if f.declaration.isAbstract {
+			return nil, newRuntimeError(TypeError, token{}, "cannot call abstract method %s", f.name).withValues(f)
+		}
+		env, err := f.bindParams(intp, args)
+		if err != nil {
+			return nil, err
+		}
+		// generator 的参数在调用的时候就绑定好，body 等到第一次 next() 才开始执行。
+		if f.declaration.isGenerator {
+			return newLoxGenerator(f, intp.Fork(), env), nil
+		}
+		// async function 的 body 在自己的 coroutine 中执行，调用方拿到的是 promise。
+		if f.declaration.isAsync {
+			return newAsyncCall(f, intp.Fork(), env), nil
+		}
+		err = intp.ExecuteBlock(f.declaration.stmts, env)
+		intp.Memory().releaseEnv(env)
+		if err != nil {
+			var returnValue Return
+			if !errors.As(err, &returnValue) {
+				return nil, err
+			}
+			if returnValue.tailCall != nil {
+				f, args = returnValue.tailCall.function, returnValue.tailCall.args
+				continue
+			}
 			if f.isInitlializer {
 				return f.closure.GetAtByVarName(0, "this")
 			}
 			return returnValue.Value, nil
 		}
+		if f.isInitlializer {
+			return f.closure.GetAtByVarName(0, "this")
+		}
+		return nil, nil
+	}
+}
+
+// bindParams 创建调用用的 env，并把参数、默认值和 rest 参数定义在里面。
+func (f *LoxFunction) bindParams(intp Interpreter, args []interface{}) (*Env, error) {
+	env := newEnvWithEnclosing(f.closure)
+	variables := len(f.declaration.params)
+	if f.declaration.rest != nil {
+		variables++
+	}
+	if err := intp.Memory().chargeEnv(env, variables); err != nil {
 		return nil, err
 	}
-	if f.isInitlializer {
-		return f.closure.GetAtByVarName(0, "this")
+	for i, v := range f.declaration.params {
+		if i < len(args) && args[i] != argNotProvided {
+			env.Define(v.Lexeme, args[i])
+			continue
+		}
+		// 默认值在调用时求值，可以引用前面的参数。
+		defaultValue := f.declaration.defaults[i]
+		if defaultValue == nil {
+			return nil, newRuntimeError(ArityError, token{}, "%s missing argument for parameter '%s'", f, v.Lexeme).withValues(f)
+		}
+		value, err := intp.Evaluate(defaultValue, env)
+		if err != nil {
+			return nil, err
+		}
+		env.Define(v.Lexeme, value)
+	}
+	if f.declaration.rest != nil {
+		var rest []interface{}
+		if len(args) > len(f.declaration.params) {
+			rest = append(rest, args[len(f.declaration.params):]...)
+		}
+		env.Define(f.declaration.rest.Lexeme, newLoxList(rest))
 	}
-	return nil, nil
+	return env, nil
 }
 
-func (f *LoxFunction) Bind(instance *LoxInstance) (*LoxFunction, error) {
+// Bind 返回一个 this 绑定到 this 上的 method，instance method 绑定的是 *LoxInstance，static method 绑定的是 *LoxClass。
+func (f *LoxFunction) Bind(this interface{}) (*LoxFunction, error) {
 	env := newEnvWithEnclosing(f.closure)
-	env.Define("this", instance)
-	return newLoxFunction(f.declaration, env, f.isInitlializer), nil
+	env.Define("this", this)
+	method := newLoxFunction(f.declaration, env, f.isInitlializer)
+	method.isGetter = f.isGetter
+	return method, nil
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// errGeneratorClosed 让被放弃的 generator 从 yield 处一路返回，结束执行 body 的 goroutine。
+var errGeneratorClosed = errors.New("generator closed")
+
+type generatorResult struct {
+	value interface{}
+	done  bool
+	err   error
+}
+
+// generatorState 是 generator 跟执行 body 的 goroutine 之间共享的状态。
+// 两边通过 channel 交替执行，同一时刻只有一边在运行，所以 body 可以放心地访问 env。
+// goroutine 只引用 generatorState 而不引用 LoxGenerator，这样 LoxGenerator 不再被使用的时候可以被回收，
+// 回收时的 finalizer 会通知 goroutine 退出。引用了自己的 generator 不会被回收，interpret 结束的时候由 generatorSet 关闭。
+type generatorState struct {
+	intp    Interpreter
+	stmts   []Stmt
+	env     *Env
+	set     *generatorSet
+	resume  chan bool            // true 表示继续执行，false 表示 generator 已经被关闭
+	results chan generatorResult // 有一个缓冲，关闭之后 goroutine 不会因为没人接收而阻塞
+	exited  chan struct{}        // 执行 body 的 goroutine 结束的时候关闭
+
+	mu      sync.Mutex
+	started bool
+	done    bool
+}
+
+func (g *generatorState) run() {
+	defer close(g.exited)
+	err := g.intp.ExecuteBlock(g.stmts, g.env)
+	var returnValue Return
+	if errors.As(err, &returnValue) || errors.Is(err, errGeneratorClosed) {
+		err = nil
+	}
+	g.results <- generatorResult{done: true, err: err}
+}
+
+// step 让 generator 执行到下一个 yield 或者结束。
+func (g *generatorState) step() generatorResult {
+	g.mu.Lock()
+	if g.done {
+		g.mu.Unlock()
+		return generatorResult{done: true}
+	}
+	first := !g.started
+	g.started = true
+	g.mu.Unlock()
+	if first {
+		go g.run()
+	} else {
+		select {
+		case g.resume <- true:
+		case <-g.exited:
+		}
+	}
+	result := <-g.results
+	if result.done {
+		g.mu.Lock()
+		g.done = true
+		g.mu.Unlock()
+		g.set.remove(g)
+	}
+	return result
+}
+
+// yield 在 body 的 goroutine 中执行，把值交给调用方，然后等待下一次 step。
+func (g *generatorState) yield(value interface{}) error {
+	g.results <- generatorResult{value: value}
+	if !<-g.resume {
+		return errGeneratorClosed
+	}
+	return nil
+}
+
+// close 让停在 yield 上的 body 返回，body 正在执行的时候会等到它执行到下一个 yield 或者结束。
+func (g *generatorState) close() {
+	g.mu.Lock()
+	stop := g.started && !g.done
+	g.done = true
+	g.mu.Unlock()
+	if stop {
+		select {
+		case g.resume <- false:
+		case <-g.exited:
+		}
+	}
+	g.set.remove(g)
+}
+
+// generatorSet 记录一个程序中还没有结束的 generator，同一个 interpreter fork 出来的 interpreter 共享同一个 generatorSet。
+type generatorSet struct {
+	mu     sync.Mutex
+	states map[*generatorState]bool
+}
+
+func newGeneratorSet() *generatorSet {
+	return &generatorSet{states: map[*generatorState]bool{}}
+}
+
+func (s *generatorSet) add(g *generatorState) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[g] = true
+}
+
+func (s *generatorSet) remove(g *generatorState) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, g)
+}
+
+// closeAll 关闭所有还没有结束的 generator，结束执行它们的 body 的 goroutine。
+// body 可能正在别的 task 中执行，所以不等待它们真的退出。
+func (s *generatorSet) closeAll() {
+	s.mu.Lock()
+	states := s.states
+	s.states = map[*generatorState]bool{}
+	s.mu.Unlock()
+	for g := range states {
+		go g.close()
+	}
+}
+
+// LoxGenerator 是调用 generator function 的返回值，同时也是一个 iterator。
+// generator 可以被多个 task 共享，running 和 pending 的读写都要加锁。
+type LoxGenerator struct {
+	name  string
+	state *generatorState
+
+	mu sync.Mutex
+	// body 正在执行，这时候再驱动它会返回错误：在 body 中驱动自己会死锁，在别的 task 中驱动它会打乱 yield 的顺序。
+	running bool
+	// hasNext 需要提前执行到下一个 yield，拿到的值先存在这里。
+	pending *generatorResult
+}
+
+func newLoxGenerator(function *LoxFunction, intp Interpreter, env *Env) *LoxGenerator {
+	state := &generatorState{
+		intp:    intp,
+		stmts:   function.declaration.stmts,
+		env:     env,
+		resume:  make(chan bool),
+		results: make(chan generatorResult, 1),
+		exited:  make(chan struct{}),
+	}
+	if v, ok := intp.(*interpreter); ok {
+		v.generator = state
+		state.set = v.generators
+		state.set.add(state)
+	}
+	generator := &LoxGenerator{
+		name:  function.name,
+		state: state,
+	}
+	runtime.SetFinalizer(generator, func(g *LoxGenerator) {
+		g.state.close()
+	})
+	return generator
+}
+
+func (g *LoxGenerator) String() string {
+	return fmt.Sprintf("<generator: %s>", g.name)
+}
+
+// advance 在没有 pending 的时候执行到下一个 yield，take 为 true 的时候同时取走得到的值。
+// 执行 body 的时候不持有锁，这样 body 中驱动自己会得到错误而不是死锁。
+func (g *LoxGenerator) advance(take bool) (generatorResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.running {
+		return generatorResult{}, newRuntimeError(StateError, token{}, "generator %s is already running", g.name)
+	}
+	if g.pending == nil {
+		g.running = true
+		g.mu.Unlock()
+		result := g.state.step()
+		g.mu.Lock()
+		g.running = false
+		g.pending = &result
+	}
+	result := *g.pending
+	switch {
+	case result.err != nil:
+		// body 中的错误只返回一次，之后 generator 就结束了。
+		g.pending = &generatorResult{done: true}
+	case take && !result.done:
+		g.pending = nil
+	}
+	return result, nil
+}
+
+func (g *LoxGenerator) HasNext() (bool, error) {
+	result, err := g.advance(false)
+	if err != nil {
+		return false, err
+	}
+	if result.err != nil {
+		return false, result.err
+	}
+	return !result.done, nil
+}
+
+func (g *LoxGenerator) Next() (interface{}, error) {
+	result, err := g.advance(true)
+	if err != nil {
+		return nil, err
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	if result.done {
+		return nil, newRuntimeError(StateError, token{}, "generator %s is exhausted", g.name)
+	}
+	return result.value, nil
+}
+
+// Close 提前结束 generator，body 会从当前的 yield 处返回。
+func (g *LoxGenerator) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.running {
+		return newRuntimeError(StateError, token{}, "generator %s is already running", g.name)
+	}
+	g.state.close()
+	g.pending = &generatorResult{done: true}
+	return nil
+}
+
+func (g *LoxGenerator) Get(name token) (interface{}, error) {
+	switch name.Lexeme {
+	case "hasNext":
+		return newNativeFunction("hasNext", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return g.HasNext()
+		}), nil
+	case "next":
+		return newNativeFunction("next", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return g.Next()
+		}), nil
+	case "close":
+		return newNativeFunction("close", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return nil, g.Close()
+		}), nil
+	case "iterator":
+		return newNativeFunction("iterator", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return g, nil
+		}), nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in generator %s", name.Lexeme, g.name)
+}
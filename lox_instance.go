@@ -1,10 +1,18 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
 
 type LoxInstance struct {
-	class  *LoxClass
+	class *LoxClass
+	// instance 可以被多个 task 共享，fields 和 privateFields 的读写都要加锁。
+	mu     sync.RWMutex
 	fields map[string]interface{}
+	// private field 按声明它的 class 分开存放，subclass 中同名的 private field 不会互相覆盖。
+	privateFields map[*LoxClass]map[string]interface{}
 }
 
 func newLoxInstance(class *LoxClass) *LoxInstance {
@@ -12,15 +20,48 @@ func newLoxInstance(class *LoxClass) *LoxInstance {
 		class: class,
 	}
 	instance.fields = make(map[string]interface{})
+	instance.privateFields = make(map[*LoxClass]map[string]interface{})
 	return instance
 }
 
 func (i *LoxInstance) String() string {
-	return fmt.Sprintf("<class: %s's instance>", i.class.name)
+	return fmt.Sprintf("<%s instance>", i.class.name)
 }
 
-func (i *LoxInstance) Get(name token) (interface{}, error) {
-	v, ok := i.fields[name.Lexeme]
+func (i *LoxInstance) field(name string) (interface{}, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	v, ok := i.fields[name]
+	return v, ok
+}
+
+// hasProperty 判断 Get 能不能找到 name，包括 field、method 和 getter。
+func (i *LoxInstance) hasProperty(name string) (bool, error) {
+	if _, ok := i.field(name); ok {
+		return true, nil
+	}
+	method, err := i.class.FindMethod(name)
+	return method != nil, err
+}
+
+// Fields 返回 public field 的名字和值，名字按字典序排列。
+func (i *LoxInstance) Fields() ([]string, []interface{}) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	names := make([]string, 0, len(i.fields))
+	for name := range i.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		values = append(values, i.fields[name])
+	}
+	return names, values
+}
+
+func (i *LoxInstance) Get(intp Interpreter, name token) (interface{}, error) {
+	v, ok := i.field(name.Lexeme)
 	if ok {
 		return v, nil
 	}
@@ -28,13 +69,86 @@ func (i *LoxInstance) Get(name token) (interface{}, error) {
 	if v, err := i.class.FindMethod(name.Lexeme); err != nil {
 		return nil, err
 	} else if v != nil {
-		return v.Bind(i)
+		method, err := v.Bind(i)
+		if err != nil {
+			return nil, err
+		}
+		if method.isGetter {
+			return intp.Call(method, nil)
+		}
+		return method, nil
 	}
 
-	return nil, fmt.Errorf("%s not found in this instance", name.Lexeme)
+	return nil, newRuntimeError(PropertyError, name, "undefined property %s on %s", name.Lexeme, i).withValues(i)
 }
 
-func (i *LoxInstance) Set(name token, value interface{}) error {
+// Set 优先调用 setter，只有 getter 没有 setter 的属性是只读的。
+func (i *LoxInstance) Set(intp Interpreter, name token, value interface{}) error {
+	setter, err := i.class.FindSetter(name.Lexeme)
+	if err != nil {
+		return err
+	}
+	if setter != nil {
+		method, err := setter.Bind(i)
+		if err != nil {
+			return err
+		}
+		_, err = intp.Call(method, []interface{}{value})
+		return err
+	}
+	getter, err := i.class.FindMethod(name.Lexeme)
+	if err != nil {
+		return err
+	}
+	if getter != nil && getter.isGetter {
+		return newRuntimeError(PropertyError, name, "cannot assign to getter-only property %s of %s", name.Lexeme, i).withValues(i, value)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.fields[name.Lexeme]; !ok {
+		if err := intp.Memory().charge(memoryInstances, fieldCost+int64(len(name.Lexeme))); err != nil {
+			return err
+		}
+	}
 	i.fields[name.Lexeme] = value
 	return nil
 }
+
+// GetPrivate 读取 class 中声明的 private member，class 是访问代码所在的 class。
+func (i *LoxInstance) GetPrivate(class *LoxClass, name token) (interface{}, error) {
+	if !i.class.isSubclassOf(class) {
+		return nil, newRuntimeError(PropertyError, name, "private member %s of class %s is not accessible on %s", name.Lexeme, class.name, i).withValues(i)
+	}
+	i.mu.RLock()
+	v, ok := i.privateFields[class][name.Lexeme]
+	i.mu.RUnlock()
+	if ok {
+		return v, nil
+	}
+	if method, ok := class.privateMethods[name.Lexeme]; ok {
+		return method.Bind(i)
+	}
+	return nil, newRuntimeError(PropertyError, name, "undefined private member %s in class %s", name.Lexeme, class.name).withValues(i)
+}
+
+func (i *LoxInstance) SetPrivate(class *LoxClass, name token, value interface{}) error {
+	if !i.class.isSubclassOf(class) {
+		return newRuntimeError(PropertyError, name, "private member %s of class %s is not accessible on %s", name.Lexeme, class.name, i).withValues(i)
+	}
+	if _, ok := class.privateMethods[name.Lexeme]; ok {
+		return newRuntimeError(PropertyError, name, "cannot assign to private method %s of class %s", name.Lexeme, class.name).withValues(i, value)
+	}
+	i.setPrivateField(class, name.Lexeme, value)
+	return nil
+}
+
+func (i *LoxInstance) setPrivateField(class *LoxClass, name string, value interface{}) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	fields, ok := i.privateFields[class]
+	if !ok {
+		fields = make(map[string]interface{})
+		i.privateFields[class] = fields
+	}
+	fields[name] = value
+}
@@ -0,0 +1,20 @@
+package main
+
+import "fmt"
+
+// LoxInterface 只保存 method 的签名，用来在 class 声明的时候检查 class 是否实现了这些 method。
+type LoxInterface struct {
+	name    string
+	methods []FunctionStmt
+}
+
+func newLoxInterface(name string, methods []FunctionStmt) *LoxInterface {
+	return &LoxInterface{
+		name:    name,
+		methods: methods,
+	}
+}
+
+func (i *LoxInterface) String() string {
+	return fmt.Sprintf("<interface: %s >", i.name)
+}
@@ -0,0 +1,175 @@
+package main
+
+import "fmt"
+
+// 迭代协议：for-in 先调用对象的 iterator()，然后不断调用返回对象的 hasNext() 和 next()。
+// 内置的 list、map、range 和 channel 的 iterator() 返回 LoxIterator，用户定义的 class 可以返回任何实现了
+// hasNext() 和 next() 的 instance，也可以直接返回一个 generator。string 没有 iterator()，由 interpreter 直接按字符遍历。
+
+// LoxIterator 是内置类型的 iterator，在 lox 中跟用户定义的 iterator 一样使用。
+// hasNext 可能会阻塞，比如 channel 的 iterator 要等到收到值，这时候可能返回 BudgetExceededError。
+type LoxIterator struct {
+	hasNext func() (bool, error)
+	next    func() interface{}
+}
+
+func newLoxIterator(hasNext func() (bool, error), next func() interface{}) *LoxIterator {
+	return &LoxIterator{
+		hasNext: hasNext,
+		next:    next,
+	}
+}
+
+func (it *LoxIterator) String() string {
+	return "<iterator>"
+}
+
+func (it *LoxIterator) Get(name token) (interface{}, error) {
+	switch name.Lexeme {
+	case "hasNext":
+		return newNativeFunction("hasNext", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return it.hasNext()
+		}), nil
+	case "next":
+		return newNativeFunction("next", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			hasNext, err := it.hasNext()
+			if err != nil {
+				return nil, err
+			}
+			if !hasNext {
+				return nil, newRuntimeError(StateError, token{}, "iterator is exhausted")
+			}
+			return it.next(), nil
+		}), nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in iterator", name.Lexeme)
+}
+
+// LoxRange 是 range() 的返回值，只保存边界，遍历的时候才计算每个值。
+type LoxRange struct {
+	start, end, step float64
+}
+
+func newLoxRange(start, end, step float64) (*LoxRange, error) {
+	if step == 0 {
+		return nil, newRuntimeError(ValueError, token{}, "range step cannot be zero")
+	}
+	return &LoxRange{
+		start: start,
+		end:   end,
+		step:  step,
+	}, nil
+}
+
+func (r *LoxRange) String() string {
+	return fmt.Sprintf("range(%v, %v, %v)", r.start, r.end, r.step)
+}
+
+func (r *LoxRange) contains(v float64) bool {
+	if r.step > 0 {
+		return v < r.end
+	}
+	return v > r.end
+}
+
+func (r *LoxRange) Iterator() *LoxIterator {
+	current := r.start
+	return newLoxIterator(func() (bool, error) {
+		return r.contains(current), nil
+	}, func() interface{} {
+		v := current
+		current += r.step
+		return v
+	})
+}
+
+func (r *LoxRange) Get(name token) (interface{}, error) {
+	if name.Lexeme == "iterator" {
+		return newNativeFunction("iterator", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return r.Iterator(), nil
+		}), nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in range", name.Lexeme)
+}
+
+// iteratorOf 返回遍历 value 的函数，第二个返回值为 false 的时候表示遍历结束。
+func (i *interpreter) iteratorOf(value interface{}) (func() (interface{}, bool, error), error) {
+	switch v := value.(type) {
+	case string:
+		chars := []rune(v)
+		var idx int
+		return func() (interface{}, bool, error) {
+			if idx >= len(chars) {
+				return nil, false, nil
+			}
+			idx++
+			return string(chars[idx-1]), true, nil
+		}, nil
+	case *LoxList:
+		return i.builtinIterator(v.Iterator()), nil
+	case *LoxMap:
+		return i.builtinIterator(v.Iterator()), nil
+	case *LoxRange:
+		return i.builtinIterator(v.Iterator()), nil
+	case *LoxIterator:
+		return i.builtinIterator(v), nil
+	case *LoxChannel:
+		return i.builtinIterator(v.Iterator(i.budget)), nil
+	case *LoxGenerator:
+		return func() (interface{}, bool, error) {
+			hasNext, err := v.HasNext()
+			if err != nil || !hasNext {
+				return nil, false, err
+			}
+			next, err := v.Next()
+			return next, err == nil, err
+		}, nil
+	case *LoxInstance:
+		it, ok, err := i.callSpecialMethod(v, "iterator")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, newRuntimeError(TypeError, token{}, "%s is not iterable, it has no iterator() method", v)
+		}
+		switch it.(type) {
+		case *LoxIterator, *LoxGenerator:
+			return i.iteratorOf(it)
+		}
+		instance, ok := it.(*LoxInstance)
+		if !ok {
+			return nil, newRuntimeError(TypeError, token{}, "iterator() of %s must return an iterator, got %v", v, it)
+		}
+		return func() (interface{}, bool, error) {
+			hasNext, ok, err := i.callSpecialMethod(instance, "hasNext")
+			if err != nil {
+				return nil, false, err
+			}
+			if !ok {
+				return nil, false, newRuntimeError(TypeError, token{}, "iterator %s has no hasNext() method", instance)
+			}
+			if !i.isTruthy(hasNext) {
+				return nil, false, nil
+			}
+			next, ok, err := i.callSpecialMethod(instance, "next")
+			if err != nil {
+				return nil, false, err
+			}
+			if !ok {
+				return nil, false, newRuntimeError(TypeError, token{}, "iterator %s has no next() method", instance)
+			}
+			return next, true, nil
+		}, nil
+	}
+	return nil, newRuntimeError(TypeError, token{}, "%v is not iterable", value)
+}
+
+func (i *interpreter) builtinIterator(it *LoxIterator) func() (interface{}, bool, error) {
+	return func() (interface{}, bool, error) {
+		hasNext, err := it.hasNext()
+		if err != nil || !hasNext {
+			return nil, false, err
+		}
+		return it.next(), true, nil
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxJSONDepth 限制 json.parse 和 json.stringify 中嵌套的层数，避免恶意的输入耗尽 go 的调用栈。
+const maxJSONDepth = 1000
+
+// jsonParser 把 json 解析成 lox 的值：object 是 LoxMap，array 是 LoxList，number 是 float64。
+// 出错的时候返回出错位置的行号和列号。
+type jsonParser struct {
+	src   string
+	pos   int
+	depth int
+	// 解析出来的值大概占用的内存，用来记账。
+	bytes int64
+}
+
+func parseJSON(src string) (interface{}, int64, error) {
+	p := &jsonParser{src: src}
+	v, err := p.value()
+	if err != nil {
+		return nil, 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, 0, p.errorf(p.pos, "unexpected %q after JSON value", p.current())
+	}
+	return v, p.bytes, nil
+}
+
+// errorf 返回 offset 处的错误，行号和列号从 1 开始，列号按字符而不是字节计算。
+func (p *jsonParser) errorf(offset int, format string, args ...interface{}) error {
+	line := 1 + strings.Count(p.src[:offset], "\n")
+	column := 1 + utf8.RuneCountInString(p.src[strings.LastIndex(p.src[:offset], "\n")+1:offset])
+	return newRuntimeError(ValueError, token{}, "json.parse: %s at line %d, column %d", fmt.Sprintf(format, args...), line, column)
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *jsonParser) value() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf(p.pos, "unexpected end of input")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array()
+	case c == '"':
+		return p.string()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.number()
+	case c == 't':
+		return true, p.literal("true")
+	case c == 'f':
+		return false, p.literal("false")
+	case c == 'n':
+		return nil, p.literal("null")
+	default:
+		return nil, p.errorf(p.pos, "unexpected %q", p.current())
+	}
+}
+
+// current 返回当前位置的字符，错误中不能只输出多字节字符的第一个字节。
+func (p *jsonParser) current() rune {
+	r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+	return r
+}
+
+func (p *jsonParser) literal(word string) error {
+	if !strings.HasPrefix(p.src[p.pos:], word) {
+		return p.errorf(p.pos, "invalid literal, expect %s", word)
+	}
+	p.pos += len(word)
+	return nil
+}
+
+func (p *jsonParser) enter() error {
+	p.depth++
+	if p.depth > maxJSONDepth {
+		return p.errorf(p.pos, "nesting deeper than %d levels", maxJSONDepth)
+	}
+	return nil
+}
+
+func (p *jsonParser) object() (interface{}, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	p.pos++ // '{'
+	p.bytes += collectionCost
+	m := newLoxMap()
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == '}' {
+		p.pos++
+		return m, nil
+	}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != '"' {
+			return nil, p.expect("object key string")
+		}
+		key, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != ':' {
+			return nil, p.expect("':' after object key")
+		}
+		p.pos++
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		p.bytes += mapEntryCost
+		m.Store(key, value)
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == '}' {
+			p.pos++
+			return m, nil
+		}
+		return nil, p.expect("',' or '}' after object value")
+	}
+}
+
+func (p *jsonParser) array() (interface{}, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	p.pos++ // '['
+	p.bytes += collectionCost
+	var elements []interface{}
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == ']' {
+		p.pos++
+		return newLoxList(elements), nil
+	}
+	for {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		p.bytes += elementCost
+		elements = append(elements, value)
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == ']' {
+			p.pos++
+			return newLoxList(elements), nil
+		}
+		return nil, p.expect("',' or ']' after array element")
+	}
+}
+
+// expect 返回当前位置缺少 what 的错误。
+func (p *jsonParser) expect(what string) error {
+	if p.pos >= len(p.src) {
+		return p.errorf(p.pos, "unexpected end of input, expect %s", what)
+	}
+	return p.errorf(p.pos, "unexpected %q, expect %s", p.current(), what)
+}
+
+func (p *jsonParser) string() (string, error) {
+	start := p.pos
+	p.pos++ // '"'
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == '\\':
+			if err := p.escape(); err != nil {
+				return "", err
+			}
+		case c == '"':
+			p.pos++
+			// 转义已经检查过了，交给 encoding/json 处理。
+			var s string
+			if err := json.Unmarshal([]byte(p.src[start:p.pos]), &s); err != nil {
+				return "", p.errorf(start, "invalid string: %v", err)
+			}
+			p.bytes += stringOverhead + int64(len(s))
+			return s, nil
+		case c < 0x20:
+			return "", p.errorf(p.pos, "control character %q in string", c)
+		default:
+			p.pos++
+		}
+	}
+	return "", p.errorf(start, "unterminated string")
+}
+
+// escape 检查并跳过 string 中的一个转义。
+func (p *jsonParser) escape() error {
+	start := p.pos
+	p.pos++ // '\\'
+	if p.pos >= len(p.src) {
+		return p.errorf(start, "unterminated escape in string")
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+		return nil
+	case 'u':
+		for n := 0; n < 4; n++ {
+			if p.pos >= len(p.src) || !isHexDigit(p.src[p.pos]) {
+				return p.errorf(start, "invalid escape '\\u' in string")
+			}
+			p.pos++
+		}
+		return nil
+	}
+	return p.errorf(start, "invalid escape '\\%c' in string", c)
+}
+
+func isHexDigit(c byte) bool {
+	return isDigital(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func (p *jsonParser) number() (interface{}, error) {
+	start := p.pos
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+			n++
+		}
+		return n
+	}
+	intStart := p.pos
+	if digits() == 0 {
+		return nil, p.expect("digit")
+	}
+	if p.src[intStart] == '0' && p.pos-intStart > 1 {
+		return nil, p.errorf(intStart, "leading zero in number")
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		p.pos++
+		if digits() == 0 {
+			return nil, p.expect("digit after decimal point")
+		}
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		if digits() == 0 {
+			return nil, p.expect("digit in exponent")
+		}
+	}
+	f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+	if err != nil {
+		return nil, p.errorf(start, "invalid number %s", p.src[start:p.pos])
+	}
+	return f, nil
+}
+
+// jsonWriter 把 lox 的值写成紧凑的 json，instance 写成它的 public field 组成的 object。
+type jsonWriter struct {
+	buf bytes.Buffer
+	// 正在写的 list、map 和 instance，再次遇到说明有环。
+	visiting map[interface{}]bool
+}
+
+func stringifyJSON(value interface{}, indent string) (string, error) {
+	w := &jsonWriter{visiting: map[interface{}]bool{}}
+	if err := w.write(value); err != nil {
+		return "", err
+	}
+	if indent == "" {
+		return w.buf.String(), nil
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, w.buf.Bytes(), "", indent); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func (w *jsonWriter) write(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		w.buf.WriteString("null")
+	case bool:
+		w.buf.WriteString(strconv.FormatBool(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return newRuntimeError(ValueError, token{}, "json.stringify: cannot serialize number %v", v)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		w.buf.Write(b)
+	case string:
+		w.writeString(v)
+	case *LoxList:
+		return w.container(v, "list", func() error {
+			w.buf.WriteByte('[')
+			for idx, element := range v.Elements() {
+				if idx > 0 {
+					w.buf.WriteByte(',')
+				}
+				if err := w.write(element); err != nil {
+					return err
+				}
+			}
+			w.buf.WriteByte(']')
+			return nil
+		})
+	case *LoxMap:
+		return w.container(v, "map", func() error {
+			keys, values := v.Entries()
+			names := make([]string, 0, len(keys))
+			for _, key := range keys {
+				name, ok := key.(string)
+				if !ok {
+					return newRuntimeError(TypeError, token{}, "json.stringify: object keys must be strings, got %v", key)
+				}
+				names = append(names, name)
+			}
+			return w.object(names, values)
+		})
+	case *LoxInstance:
+		return w.container(v, "instance of "+v.class.name, func() error {
+			return w.object(v.Fields())
+		})
+	default:
+		return newRuntimeError(TypeError, token{}, "json.stringify: cannot serialize %v", v)
+	}
+	return nil
+}
+
+// container 在写 list、map 和 instance 的时候检查有没有环，同一个值在不同的分支中出现多次不算环。
+// 有环的值不能用 loxString 输出，错误中只带上它的种类。visiting 中的值就是当前嵌套的层数。
+func (w *jsonWriter) container(v interface{}, kind string, write func() error) error {
+	if w.visiting[v] {
+		return newRuntimeError(ValueError, token{}, "json.stringify: cycle detected, a %s contains itself", kind)
+	}
+	if len(w.visiting) >= maxJSONDepth {
+		return newRuntimeError(ValueError, token{}, "json.stringify: nesting deeper than %d levels", maxJSONDepth)
+	}
+	w.visiting[v] = true
+	defer delete(w.visiting, v)
+	return write()
+}
+
+func (w *jsonWriter) object(names []string, values []interface{}) error {
+	w.buf.WriteByte('{')
+	for idx, name := range names {
+		if idx > 0 {
+			w.buf.WriteByte(',')
+		}
+		w.writeString(name)
+		w.buf.WriteByte(':')
+		if err := w.write(values[idx]); err != nil {
+			return err
+		}
+	}
+	w.buf.WriteByte('}')
+	return nil
+}
+
+func (w *jsonWriter) writeString(s string) {
+	enc := json.NewEncoder(&w.buf)
+	enc.SetEscapeHTML(false)
+	// 写 string 不会失败，Encode 会在后面加一个换行，去掉它。
+	_ = enc.Encode(s)
+	w.buf.Truncate(w.buf.Len() - 1)
+}
+
+// jsonIndent 把 stringify 的 indent 参数转换成缩进用的字符串，number 表示空格的个数。
+// string 只能由空格和 tab 组成，其它字符会让输出不再是合法的 json。
+func jsonIndent(arg interface{}) (string, error) {
+	switch v := arg.(type) {
+	case nil:
+		return "", nil
+	case string:
+		if strings.Trim(v, " \t") != "" {
+			return "", newRuntimeError(ValueError, token{}, "json.stringify: indent string must contain only spaces and tabs, got %q", v)
+		}
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) || v < 0 || v > 10 {
+			return "", newRuntimeError(ValueError, token{}, "json.stringify: indent must be an integer between 0 and 10, got %v", v)
+		}
+		return strings.Repeat(" ", int(v)), nil
+	}
+	return "", newRuntimeError(TypeError, token{}, "json.stringify: indent must be a number or a string, got %v", arg)
+}
+
+// newJSONNamespace 返回 json namespace，包括 json.parse(str) 和 json.stringify(value[, indent])。
+func newJSONNamespace() *LoxNamespace {
+	return newLoxNamespace("json",
+		newNativeFunction("parse", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			src, ok := args[0].(string)
+			if !ok {
+				return nil, newRuntimeError(TypeError, token{}, "json.parse expects a string, got %v", args[0])
+			}
+			v, bytes, err := parseJSON(src)
+			if err != nil {
+				return nil, err
+			}
+			if err := intp.Memory().charge(memoryCollections, bytes); err != nil {
+				return nil, err
+			}
+			return v, nil
+		}),
+		newNativeFunction("stringify", Arity{Min: 1, Max: 2}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+			var indent string
+			if len(args) == 2 {
+				var err error
+				if indent, err = jsonIndent(args[1]); err != nil {
+					return nil, err
+				}
+			}
+			s, err := stringifyJSON(args[0], indent)
+			if err != nil {
+				return nil, err
+			}
+			if err := intp.Memory().chargeString(s); err != nil {
+				return nil, err
+			}
+			return s, nil
+		}),
+	)
+}
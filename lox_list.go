@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// LoxList 是 lox 中的 list，`[1, 2]` 和 rest 参数都会创建 list。
+// list 可以被多个 task 共享，所以 elements 的读写都要加锁。
+type LoxList struct {
+	mu       sync.RWMutex
+	elements []interface{}
+}
+
+func newLoxList(elements []interface{}) *LoxList {
+	return &LoxList{
+		elements: elements,
+	}
+}
+
+func (l *LoxList) String() string {
+	return loxString(l)
+}
+
+// loxString 是值在 lox 中的字符串形式，list 和 map 中的元素也使用它。
+// 它不会调用 instance 的 toString，需要的时候使用 interpreter 的 stringify。
+func loxString(v interface{}) string {
+	return loxStringVisiting(v, map[interface{}]bool{})
+}
+
+func loxStringVisiting(v interface{}, visiting map[interface{}]bool) string {
+	s, ok, _ := stringifyCollection(v, visiting, func(element interface{}) (string, error) {
+		return loxStringVisiting(element, visiting), nil
+	})
+	if ok {
+		return s
+	}
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case float64:
+		return formatNumber(v)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// stringifyCollection 输出 list 和 map，value 不是 list 或 map 的时候 ok 为 false，element 输出其中的每个值。
+// visiting 是正在输出的 list 和 map，包含自身的部分输出成 `[...]` 或者 `{...}`，
+// loxString 和 interpreter 的 stringify 都通过它输出 collection，所以有环的值也能出现在错误信息中。
+func stringifyCollection(value interface{}, visiting map[interface{}]bool, element func(interface{}) (string, error)) (s string, ok bool, err error) {
+	switch v := value.(type) {
+	case *LoxList:
+		if visiting[v] {
+			return "[...]", true, nil
+		}
+		visiting[v] = true
+		defer delete(visiting, v)
+		var elements []string
+		for _, e := range v.Elements() {
+			s, err := element(e)
+			if err != nil {
+				return "", true, err
+			}
+			elements = append(elements, s)
+		}
+		return "[" + strings.Join(elements, ", ") + "]", true, nil
+	case *LoxMap:
+		if visiting[v] {
+			return "{...}", true, nil
+		}
+		visiting[v] = true
+		defer delete(visiting, v)
+		var entries []string
+		keys, values := v.Entries()
+		for idx, key := range keys {
+			k, err := element(key)
+			if err != nil {
+				return "", true, err
+			}
+			value, err := element(values[idx])
+			if err != nil {
+				return "", true, err
+			}
+			entries = append(entries, k+": "+value)
+		}
+		return "{" + strings.Join(entries, ", ") + "}", true, nil
+	}
+	return "", false, nil
+}
+
+// Elements 返回当前元素的一份拷贝。
+func (l *LoxList) Elements() []interface{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]interface{}(nil), l.elements...)
+}
+
+func (l *LoxList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.elements)
+}
+
+// Index 是 `l[index]`。
+func (l *LoxList) Index(index interface{}) (interface{}, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	idx, err := listIndex(index, len(l.elements))
+	if err != nil {
+		return nil, err
+	}
+	return l.elements[idx], nil
+}
+
+func (l *LoxList) SetIndex(index, value interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	idx, err := listIndex(index, len(l.elements))
+	if err != nil {
+		return err
+	}
+	l.elements[idx] = value
+	return nil
+}
+
+func (l *LoxList) Push(value interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.elements = append(l.elements, value)
+}
+
+// Iterator 每次都读取当前的长度，遍历过程中 push 的元素也会被遍历到。
+func (l *LoxList) Iterator() *LoxIterator {
+	var idx int
+	return newLoxIterator(func() (bool, error) {
+		return idx < l.Len(), nil
+	}, func() interface{} {
+		idx++
+		v, _ := l.Index(float64(idx - 1))
+		return v
+	})
+}
+
+func (l *LoxList) Get(name token) (interface{}, error) {
+	switch name.Lexeme {
+	case "length":
+		return newNativeFunction("length", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return float64(l.Len()), nil
+		}), nil
+	case "get":
+		return newNativeFunction("get", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return l.Index(args[0])
+		}), nil
+	case "set":
+		return newNativeFunction("set", fixedArity(2), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return nil, l.SetIndex(args[0], args[1])
+		}), nil
+	case "iterator":
+		return newNativeFunction("iterator", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return l.Iterator(), nil
+		}), nil
+	case "push":
+		return newNativeFunction("push", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			if err := intp.Memory().charge(memoryCollections, elementCost); err != nil {
+				return nil, err
+			}
+			l.Push(args[0])
+			return nil, nil
+		}), nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in list", name.Lexeme).withValues(l)
+}
+
+// listIndex 把 lox 中的 number 转成合法的下标。
+func listIndex(v interface{}, length int) (int, error) {
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) {
+		return 0, newRuntimeError(TypeError, token{}, "list index %s is not an integer", loxString(v)).withValues(v)
+	}
+	idx := int(f)
+	if idx < 0 || idx >= length {
+		return 0, newRuntimeError(IndexError, token{}, "list index %d out of range [0, %d)", idx, length).withValues(v)
+	}
+	return idx, nil
+}
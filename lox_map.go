@@ -0,0 +1,171 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+)
+
+// LoxMap 是 lox 中的 map，遍历的时候按照 key 插入的顺序。
+// key 可以是任意可以比较的值，instance 按引用比较。map 可以被多个 task 共享，读写都要加锁。
+type LoxMap struct {
+	mu     sync.RWMutex
+	keys   []interface{}
+	values map[interface{}]interface{}
+}
+
+func newLoxMap() *LoxMap {
+	return &LoxMap{
+		values: make(map[interface{}]interface{}),
+	}
+}
+
+func (m *LoxMap) String() string {
+	return loxString(m)
+}
+
+// Entries 按插入顺序返回 key 和 value 的拷贝。
+func (m *LoxMap) Entries() ([]interface{}, []interface{}) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := append([]interface{}(nil), m.keys...)
+	values := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, m.values[key])
+	}
+	return keys, values
+}
+
+// isHashable 判断 v 能不能作为 map 的 key，float64 的 NaN 不等于自身，所以不能作为 key。
+func isHashable(v interface{}) bool {
+	if f, ok := v.(float64); ok {
+		return f == f
+	}
+	return v == nil || reflect.TypeOf(v).Comparable()
+}
+
+func (m *LoxMap) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.keys)
+}
+
+func (m *LoxMap) Load(key interface{}) (interface{}, bool) {
+	if !isHashable(key) {
+		return nil, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.values[key]
+	return v, ok
+}
+
+func (m *LoxMap) Store(key, value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *LoxMap) Delete(key interface{}) bool {
+	if !isHashable(key) {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.values[key]; !ok {
+		return false
+	}
+	delete(m.values, key)
+	for idx, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:idx], m.keys[idx+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Index 是 `m[key]`，key 不存在的时候报错，需要默认值的时候用 get。
+func (m *LoxMap) Index(key interface{}) (interface{}, error) {
+	v, ok := m.Load(key)
+	if !ok {
+		return nil, newRuntimeError(IndexError, token{}, "key %s not found in map", loxString(key)).withValues(key)
+	}
+	return v, nil
+}
+
+func (m *LoxMap) Get(name token) (interface{}, error) {
+	switch name.Lexeme {
+	case "length":
+		return newNativeFunction("length", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return float64(m.Len()), nil
+		}), nil
+	case "get":
+		return newNativeFunction("get", Arity{Min: 1, Max: 2}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+			if v, ok := m.Load(args[0]); ok {
+				return v, nil
+			}
+			if len(args) > 1 {
+				return args[1], nil
+			}
+			return nil, nil
+		}), nil
+	case "set":
+		return newNativeFunction("set", fixedArity(2), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			if !isHashable(args[0]) {
+				return nil, newRuntimeError(TypeError, token{}, "%s cannot be used as a map key", loxString(args[0])).withValues(args[0])
+			}
+			if _, ok := m.Load(args[0]); !ok {
+				if err := intp.Memory().charge(memoryCollections, mapEntryCost); err != nil {
+					return nil, err
+				}
+			}
+			m.Store(args[0], args[1])
+			return nil, nil
+		}), nil
+	case "has":
+		return newNativeFunction("has", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			_, ok := m.Load(args[0])
+			return ok, nil
+		}), nil
+	case "remove":
+		return newNativeFunction("remove", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return m.Delete(args[0]), nil
+		}), nil
+	case "keys":
+		return newNativeFunction("keys", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			keys, _ := m.Entries()
+			if err := intp.Memory().charge(memoryCollections, collectionCost+int64(len(keys))*elementCost); err != nil {
+				return nil, err
+			}
+			return newLoxList(keys), nil
+		}), nil
+	case "values":
+		return newNativeFunction("values", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			_, values := m.Entries()
+			if err := intp.Memory().charge(memoryCollections, collectionCost+int64(len(values))*elementCost); err != nil {
+				return nil, err
+			}
+			return newLoxList(values), nil
+		}), nil
+	case "iterator":
+		return newNativeFunction("iterator", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return m.Iterator(), nil
+		}), nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in map", name.Lexeme).withValues(m)
+}
+
+// Iterator 遍历创建 iterator 时的 key，遍历过程中修改 map 不会影响这次遍历。
+func (m *LoxMap) Iterator() *LoxIterator {
+	keys, _ := m.Entries()
+	var idx int
+	return newLoxIterator(func() (bool, error) {
+		return idx < len(keys), nil
+	}, func() interface{} {
+		idx++
+		return keys[idx-1]
+	})
+}
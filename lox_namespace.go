@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+)
+
+// LoxNamespace 把一组 native function 放在同一个名字下面，比如 fs.readFile。
+type LoxNamespace struct {
+	name    string
+	members map[string]interface{}
+}
+
+func newLoxNamespace(name string, members ...*nativeFunction) *LoxNamespace {
+	ns := &LoxNamespace{
+		name:    name,
+		members: make(map[string]interface{}, len(members)),
+	}
+	for _, member := range members {
+		ns.members[member.name] = member
+	}
+	return ns
+}
+
+func (n *LoxNamespace) String() string {
+	return fmt.Sprintf("<namespace: %s>", n.name)
+}
+
+func (n *LoxNamespace) Get(name token) (interface{}, error) {
+	if v, ok := n.members[name.Lexeme]; ok {
+		return v, nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in namespace %s", name.Lexeme, n.name).withValues(n)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// stdinReader 是 os.Stdin 唯一的 bufio.Reader，所有的 interpreter 和 REPL 都使用它。
+// 每个使用方各自创建 bufio.Reader 的话，先读的一方会把其它使用方需要的输入读进自己的缓冲区。
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// newLoxArgs 把命令行中脚本后面的参数转成 lox 的 list。
+func newLoxArgs(args []string) *LoxList {
+	elements := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		elements = append(elements, arg)
+	}
+	return newLoxList(elements)
+}
+
+// newNativeFunctionReadLine 返回 readLine()，从 stdin 读一行，不包括换行符，读到结尾的时候返回 nil。
+func newNativeFunctionReadLine(stdin *bufio.Reader) *nativeFunction {
+	return newNativeFunction("readLine", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+		line, err := stdin.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			return nil, nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, newRuntimeError(IOError, token{}, "readLine: %s", err).withCause(err)
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if err := intp.Memory().chargeString(line); err != nil {
+			return nil, err
+		}
+		return line, nil
+	}).requires(capabilityProcess)
+}
+
+// newNativeFunctionReadAll 返回 readAll()，读出 stdin 中剩下的所有内容。
+func newNativeFunctionReadAll(stdin *bufio.Reader) *nativeFunction {
+	return newNativeFunction("readAll", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+		content, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, newRuntimeError(IOError, token{}, "readAll: %s", err).withCause(err)
+		}
+		if err := intp.Memory().chargeString(string(content)); err != nil {
+			return nil, err
+		}
+		return string(content), nil
+	}).requires(capabilityProcess)
+}
+
+// newNativeFunctionGetenv 返回 getenv(name)，读取环境变量，没有设置的时候返回 nil。
+func newNativeFunctionGetenv(lookup func(string) (string, bool)) *nativeFunction {
+	return newNativeFunction("getenv", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+		name, ok := args[0].(string)
+		if !ok {
+			return nil, newRuntimeError(TypeError, token{}, "getenv: name must be a string, got %s", loxString(args[0])).withValues(args[0])
+		}
+		value, ok := lookup(name)
+		if !ok {
+			return nil, nil
+		}
+		if err := intp.Memory().chargeString(value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}).requires(capabilityEnv)
+}
+
+// newNativeFunctionExit 返回 exit(code)，结束脚本的执行，code 是 0 到 255 之间的整数，默认为 0。
+func newNativeFunctionExit() *nativeFunction {
+	return newNativeFunction("exit", Arity{Min: 0, Max: 1}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+		code := 0.0
+		if len(args) == 1 {
+			v, ok := args[0].(float64)
+			if !ok || v != math.Trunc(v) || v < 0 || v > 255 {
+				return nil, newRuntimeError(ValueError, token{}, "exit code must be an integer between 0 and 255, got %v", args[0])
+			}
+			code = v
+		}
+		return nil, &ExitError{Code: int(code)}
+	}).requires(capabilityProcess)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"unicode/utf8"
+)
+
+// LoxRegex 是 regex.compile 返回的编译好的 pattern，可以保存在变量中反复使用。
+type LoxRegex struct {
+	re *regexp.Regexp
+}
+
+func compileRegex(pattern string) (*LoxRegex, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, newRuntimeError(ValueError, token{}, "regex: invalid pattern %q: %s", pattern, err).withValues(pattern).withCause(err)
+	}
+	return &LoxRegex{re: re}, nil
+}
+
+// toRegex 把 string 编译成 LoxRegex，已经编译好的直接返回。
+func toRegex(native string, arg interface{}) (*LoxRegex, error) {
+	switch v := arg.(type) {
+	case *LoxRegex:
+		return v, nil
+	case string:
+		return compileRegex(v)
+	}
+	return nil, newRuntimeError(TypeError, token{}, "%s expects a pattern string or a regex, got %v", native, arg)
+}
+
+func (r *LoxRegex) String() string {
+	return fmt.Sprintf("<regex: %s>", r.re)
+}
+
+// regexOp 是 regex 上的一个操作，args 不包括 pattern。
+type regexOp struct {
+	name  string
+	arity Arity
+	fn    func(r *LoxRegex, intp Interpreter, args []interface{}) (interface{}, error)
+}
+
+var regexOps = []regexOp{
+	{"test", fixedArity(1), (*LoxRegex).test},
+	{"find", fixedArity(1), (*LoxRegex).find},
+	{"findAll", fixedArity(1), (*LoxRegex).findAll},
+	{"replace", fixedArity(2), (*LoxRegex).replace},
+	{"split", Arity{Min: 1, Max: 2}, (*LoxRegex).split},
+}
+
+func (r *LoxRegex) Get(name token) (interface{}, error) {
+	if name.Lexeme == "source" {
+		return newNativeFunction("source", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return r.re.String(), nil
+		}), nil
+	}
+	for _, op := range regexOps {
+		if op.name == name.Lexeme {
+			fn := op.fn
+			return newNativeFunction(op.name, op.arity, func(intp Interpreter, args []interface{}) (interface{}, error) {
+				return fn(r, intp, args)
+			}), nil
+		}
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in regex", name.Lexeme)
+}
+
+func regexSubject(native string, arg interface{}) (string, error) {
+	s, ok := arg.(string)
+	if !ok {
+		return "", newRuntimeError(TypeError, token{}, "%s expects a string, got %v", native, arg)
+	}
+	return s, nil
+}
+
+func (r *LoxRegex) test(intp Interpreter, args []interface{}) (interface{}, error) {
+	s, err := regexSubject("test", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return r.re.MatchString(s), nil
+}
+
+func (r *LoxRegex) find(intp Interpreter, args []interface{}) (interface{}, error) {
+	s, err := regexSubject("find", args[0])
+	if err != nil {
+		return nil, err
+	}
+	loc := r.re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil, nil
+	}
+	if err := intp.Memory().charge(memoryCollections, matchCost+int64(len(loc)/2)*elementCost); err != nil {
+		return nil, err
+	}
+	return r.match(s, loc, &runeCounter{s: s}), nil
+}
+
+func (r *LoxRegex) findAll(intp Interpreter, args []interface{}) (interface{}, error) {
+	s, err := regexSubject("findAll", args[0])
+	if err != nil {
+		return nil, err
+	}
+	locs := r.re.FindAllStringSubmatchIndex(s, -1)
+	counter := &runeCounter{s: s}
+	matches := make([]interface{}, 0, len(locs))
+	bytes := int64(collectionCost)
+	for _, loc := range locs {
+		matches = append(matches, r.match(s, loc, counter))
+		bytes += elementCost + matchCost + int64(len(loc)/2)*elementCost
+	}
+	if err := intp.Memory().charge(memoryCollections, bytes); err != nil {
+		return nil, err
+	}
+	return newLoxList(matches), nil
+}
+
+// replace 的 replacement 是 string 的时候支持 $1 和 ${name}，是 callable 的时候用 match 调用它，返回值作为替换的内容。
+func (r *LoxRegex) replace(intp Interpreter, args []interface{}) (interface{}, error) {
+	s, err := regexSubject("replace", args[0])
+	if err != nil {
+		return nil, err
+	}
+	var replace func(dst []byte, loc []int) ([]byte, error)
+	switch repl := args[1].(type) {
+	case string:
+		replace = func(dst []byte, loc []int) ([]byte, error) {
+			return r.re.ExpandString(dst, repl, s, loc), nil
+		}
+	case Callable:
+		if !repl.Arity().Accepts(1) {
+			return nil, newRuntimeError(TypeError, token{}, "replace expects a function with one parameter, got %v", repl)
+		}
+		counter := &runeCounter{s: s}
+		replace = func(dst []byte, loc []int) ([]byte, error) {
+			v, err := intp.Call(repl, []interface{}{r.match(s, loc, counter)})
+			if err != nil {
+				return nil, err
+			}
+			text, ok := v.(string)
+			if !ok {
+				return nil, newRuntimeError(TypeError, token{}, "replace function must return a string, got %v", loxString(v))
+			}
+			return append(dst, text...), nil
+		}
+	default:
+		return nil, newRuntimeError(TypeError, token{}, "replace expects a replacement string or function, got %v", args[1])
+	}
+	var out []byte
+	last := 0
+	for _, loc := range r.re.FindAllStringSubmatchIndex(s, -1) {
+		out = append(out, s[last:loc[0]]...)
+		if out, err = replace(out, loc); err != nil {
+			return nil, err
+		}
+		last = loc[1]
+	}
+	result := string(append(out, s[last:]...))
+	if err := intp.Memory().chargeString(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// split 的 limit 跟 go 的 regexp.Split 一样，最多返回 limit 个部分，负数表示不限个数。
+func (r *LoxRegex) split(intp Interpreter, args []interface{}) (interface{}, error) {
+	s, err := regexSubject("split", args[0])
+	if err != nil {
+		return nil, err
+	}
+	limit := -1
+	if len(args) == 2 {
+		f, ok := args[1].(float64)
+		if !ok || f != math.Trunc(f) {
+			return nil, newRuntimeError(TypeError, token{}, "split limit must be an integer, got %v", args[1])
+		}
+		limit = int(f)
+	}
+	parts := r.re.Split(s, limit)
+	elements := make([]interface{}, 0, len(parts))
+	bytes := int64(collectionCost)
+	for _, part := range parts {
+		elements = append(elements, part)
+		bytes += elementCost + stringOverhead + int64(len(part))
+	}
+	if err := intp.Memory().charge(memoryCollections, bytes); err != nil {
+		return nil, err
+	}
+	return newLoxList(elements), nil
+}
+
+// runeCounter 把 byte 的下标转换成字符的下标，下标递增的时候只需要数新增的部分。
+type runeCounter struct {
+	s     string
+	bytes int
+	runes int
+}
+
+func (c *runeCounter) at(i int) int {
+	if i < c.bytes {
+		c.bytes, c.runes = 0, 0
+	}
+	c.runes += utf8.RuneCountInString(c.s[c.bytes:i])
+	c.bytes = i
+	return c.runes
+}
+
+// match 用 FindStringSubmatchIndex 返回的 loc 创建 LoxMatch。
+func (r *LoxRegex) match(s string, loc []int, counter *runeCounter) *LoxMatch {
+	m := &LoxMatch{
+		start:  counter.at(loc[0]),
+		groups: make([]interface{}, len(loc)/2),
+		names:  r.re.SubexpNames(),
+	}
+	m.end = counter.at(loc[1])
+	for idx := range m.groups {
+		// 没有参与匹配的 group 是 nil。
+		if loc[2*idx] >= 0 {
+			m.groups[idx] = s[loc[2*idx]:loc[2*idx+1]]
+		}
+	}
+	return m
+}
+
+// LoxMatch 是一次匹配的结果，start 和 end 是字符的下标，group 0 是匹配到的整个文本。
+type LoxMatch struct {
+	start  int
+	end    int
+	groups []interface{}
+	names  []string
+}
+
+func (m *LoxMatch) String() string {
+	return fmt.Sprintf("<match: %s>", m.groups[0])
+}
+
+// group 按下标或者名字返回 capture group。
+func (m *LoxMatch) group(key interface{}) (interface{}, error) {
+	switch k := key.(type) {
+	case float64:
+		if k != math.Trunc(k) || k < 0 || int(k) >= len(m.groups) {
+			return nil, newRuntimeError(IndexError, token{}, "group index %v out of range [0, %d)", k, len(m.groups))
+		}
+		return m.groups[int(k)], nil
+	case string:
+		for idx, name := range m.names {
+			if name != "" && name == k {
+				return m.groups[idx], nil
+			}
+		}
+		return nil, newRuntimeError(IndexError, token{}, "no group named %s", k)
+	}
+	return nil, newRuntimeError(TypeError, token{}, "group expects an index or a name, got %v", key)
+}
+
+func (m *LoxMatch) Get(name token) (interface{}, error) {
+	switch name.Lexeme {
+	case "text":
+		return newNativeFunction("text", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return m.groups[0], nil
+		}), nil
+	case "start":
+		return newNativeFunction("start", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return float64(m.start), nil
+		}), nil
+	case "end":
+		return newNativeFunction("end", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return float64(m.end), nil
+		}), nil
+	case "group":
+		return newNativeFunction("group", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			return m.group(args[0])
+		}), nil
+	case "groups":
+		// groups 不包括 group 0。
+		return newNativeFunction("groups", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			if err := intp.Memory().charge(memoryCollections, collectionCost+int64(len(m.groups)-1)*elementCost); err != nil {
+				return nil, err
+			}
+			return newLoxList(append([]interface{}(nil), m.groups[1:]...)), nil
+		}), nil
+	case "named":
+		return newNativeFunction("named", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			named := newLoxMap()
+			for idx, name := range m.names {
+				if name != "" {
+					named.Store(name, m.groups[idx])
+				}
+			}
+			if err := intp.Memory().charge(memoryCollections, collectionCost+int64(named.Len())*mapEntryCost); err != nil {
+				return nil, err
+			}
+			return named, nil
+		}), nil
+	}
+	return nil, newRuntimeError(PropertyError, name, "%s not found in match", name.Lexeme)
+}
+
+// newRegexNamespace 返回 regex namespace，regex.compile(pattern) 返回编译好的 regex，
+// 其它的函数第一个参数是 pattern string 或者 regex，比如 regex.test(pattern, s) 等价于 regex.compile(pattern).test(s)。
+func newRegexNamespace() *LoxNamespace {
+	members := []*nativeFunction{
+		newNativeFunction("compile", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			pattern, ok := args[0].(string)
+			if !ok {
+				return nil, newRuntimeError(TypeError, token{}, "compile expects a pattern string, got %v", args[0])
+			}
+			return compileRegex(pattern)
+		}),
+		newNativeFunction("escape", fixedArity(1), func(intp Interpreter, args []interface{}) (interface{}, error) {
+			s, err := regexSubject("escape", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return regexp.QuoteMeta(s), nil
+		}),
+	}
+	for _, op := range regexOps {
+		op := op
+		arity := Arity{Min: op.arity.Min + 1, Max: op.arity.Max + 1}
+		members = append(members, newNativeFunction(op.name, arity, func(intp Interpreter, args []interface{}) (interface{}, error) {
+			r, err := toRegex(op.name, args[0])
+			if err != nil {
+				return nil, err
+			}
+			return op.fn(r, intp, args[1:])
+		}))
+	}
+	return newLoxNamespace("regex", members...)
+}
@@ -0,0 +1,40 @@
+package main
+
+import "fmt"
+
+// LoxTrait 只保存 method 的声明，混入 class 的时候才创建对应的 LoxFunction，
+// 这样 trait method 中的 super 指向的是混入它的 class 的 superclass。
+type LoxTrait struct {
+	name    string
+	methods []FunctionStmt
+	closure *Env
+}
+
+func newLoxTrait(name string, methods []FunctionStmt, closure *Env) *LoxTrait {
+	return &LoxTrait{
+		name:    name,
+		methods: methods,
+		closure: closure,
+	}
+}
+
+func (t *LoxTrait) String() string {
+	return fmt.Sprintf("<trait: %s >", t.name)
+}
+
+// methodsFor 创建混入 class 之后的 method，env 的结构跟 class 中声明的 method 保持一致。
+func (t *LoxTrait) methodsFor(class *LoxClass) map[string]*LoxFunction {
+	env := newEnvWithEnclosing(t.closure)
+	if class.superclass != nil {
+		env.Define("super", class.superclass)
+	} else {
+		env.Define("super", nil)
+	}
+	env = newEnvWithEnclosing(env)
+	env.Define("#class", class)
+	methods := make(map[string]*LoxFunction)
+	for _, method := range t.methods {
+		methods[method.name.Lexeme] = newLoxFunction(method, env, false)
+	}
+	return methods
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"strings"
@@ -14,11 +17,67 @@ var (
 
 var hasErr bool
 
-func run(source string) error {
+// 进程的退出码沿用 sysexits.h 的约定，65 和 70 跟 clox 一致。
+const (
+	exitSyntaxError  = 65 // scan 或者 parse 的错误
+	exitNoInput      = 66 // 读不到脚本文件
+	exitResolveError = 67 // resolve 的错误
+	exitRuntimeError = 70 // 执行时的错误
+	exitIOError      = 74 // 执行时读写文件失败
+)
+
+// runtimeExitCode 按 RuntimeError 的 kind 选择退出码，调用方可以区分脚本自身的错误和读写文件的失败。
+func runtimeExitCode(err error) int {
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		return exitRuntimeError
+	}
+	switch runtimeErr.Kind {
+	case IOError:
+		return exitIOError
+	case InternalError:
+		fmt.Println("Internal Error: this is a bug in golox, please report it")
+	}
+	return exitRuntimeError
+}
+
+// runError 带着 run 失败的时候进程应该使用的退出码。
+type runError struct {
+	code int
+	err  error
+	// 错误在发生的地方已经输出过了，不需要再输出一次。
+	reported bool
+}
+
+func (e *runError) Error() string {
+	return e.err.Error()
+}
+
+func (e *runError) Unwrap() error {
+	return e.err
+}
+
+var (
+	maxCallDepth = flag.Int("max-call-depth", defaultMaxCallDepth, "maximum depth of nested calls, 0 means no limit")
+	maxSteps     = flag.Int64("max-steps", 0, "maximum number of loop iterations and calls, 0 means no limit")
+	timeout      = flag.Duration("timeout", 0, "maximum execution time, 0 means no limit")
+	maxMemory    = flag.Int64("max-memory", 0, "maximum bytes of approximately accounted memory, 0 means no limit")
+	memoryStats  = flag.Bool("memory-stats", false, "print memory statistics after running")
+	fsRoot       = flag.String("fs-root", "", "directory the fs natives are confined to, empty means no file system access")
+	// 默认不授予任何 capability，脚本需要的 capability 通过 -allow 显式地授予。
+	allow = flag.String("allow", "", "comma separated capabilities granted to the script: fs-read, fs-write, env, clock, process, net-loopback or all; none are granted by default")
+)
+
+func run(source string, opts ...interpreterOption) error {
+	hasErr = false
 	scanner := newScanner(source)
 	tokens, err := scanner.scanTokens()
 	if err != nil {
-		return err
+		return &runError{code: exitSyntaxError, err: err}
+	}
+	// scanner 遇到错误的时候通过 printError 输出并继续扫描，这里不再继续执行。
+	if hasErr {
+		return &runError{code: exitSyntaxError, err: errors.New("scan failed"), reported: true}
 	}
 	if !disableDebugScanner {
 		fmt.Println(strings.ToUpper("[debug scanner]"))
@@ -30,20 +89,31 @@ func run(source string) error {
 	parser := newParser(tokens)
 	stmts, err := parser.parse()
 	if err != nil {
-		return err
+		return &runError{code: exitSyntaxError, err: err}
 	}
 
 	fmt.Println(strings.ToUpper("[debug execute stmts]"))
-	intp := newInterpreter()
+	intp := newInterpreter(opts...)
 	resolver := newResolver(intp)
 	if err := resolver.resolveStmts(stmts); err != nil {
-		return err
+		return &runError{code: exitResolveError, err: err}
 	}
 	if !disableDebugResolveLocals {
-		fmt.Printf("interpreter locals: %+v\n", intp.locals)
+		fmt.Printf("interpreter locals: %+v\n", intp.locals.distances)
+	}
+	err = intp.interpret(context.Background(), stmts)
+	if *memoryStats {
+		fmt.Println(intp.MemoryStats())
+	}
+	var exit *ExitError
+	if errors.As(err, &exit) {
+		return &runError{code: exit.Code, err: err, reported: true}
+	}
+	if err != nil {
+		// 顶层语句的错误 interpret 已经输出过了，event loop 的错误还没有。
+		var loopErr *EventLoopError
+		return &runError{code: runtimeExitCode(err), err: err, reported: !errors.As(err, &loopErr)}
 	}
-	intp.interpret(stmts)
-
 	return nil
 }
 
@@ -52,22 +122,22 @@ func printError(line int, msg string) {
 	hasErr = true
 }
 
-func runFile(fileName string) error {
+func runFile(fileName string, opts ...interpreterOption) error {
 	bytes, err := os.ReadFile(fileName)
 	if err != nil {
-		return err
-	}
-	if err := run(string(bytes)); err != nil {
-		return err
-	}
-	if hasErr {
-		os.Exit(1)
+		return &runError{code: exitNoInput, err: err}
 	}
-	return nil
+	return run(string(bytes), opts...)
+}
+
+func runPrompt(opts ...interpreterOption) error {
+	return runREPL(stdinReader, opts...)
 }
 
-func runPrompt() error {
-	reader := bufio.NewReader(os.Stdin)
+// runREPL 从 reader 中读取每一行执行，readLine 和 readAll 也从同一个 reader 中读取，
+// 这样 REPL 读进缓冲区、但是还没有用到的输入不会丢失。
+func runREPL(reader *bufio.Reader, opts ...interpreterOption) error {
+	opts = append(opts, withStdin(reader))
 	for {
 		fmt.Printf("golox > ")
 		line, err := reader.ReadString('\n')
@@ -75,27 +145,46 @@ func runPrompt() error {
 			return err
 		}
 		fmt.Print("Input: ", line)
-		if err := run(line); err != nil {
-			hasErr = true
+		err = run(line, opts...)
+		var exit *ExitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.Code)
+		}
+		var runErr *runError
+		if errors.As(err, &runErr) && !runErr.reported {
 			fmt.Printf("Error: %+v\n", err)
-		} else {
-			hasErr = false
 		}
 	}
 }
 func main() {
 	fmt.Println(strings.ToUpper("welcome to go lox!"))
-	args := os.Args
-	lenArgs := len(args)
-	// fmt.Println("args:", lenArgs)
-	if lenArgs > 2 {
+	flag.Parse()
+	caps, err := parseCapabilities(*allow)
+	if err != nil {
+		fmt.Println("Error: ", err)
 		os.Exit(1)
-	} else if lenArgs == 2 {
-		if err := runFile(args[1]); err != nil {
-			fmt.Println("Run File Error: ", err)
+	}
+	opts := []interpreterOption{
+		withCapabilities(caps...),
+		withFSRoot(*fsRoot),
+		withMaxCallDepth(*maxCallDepth),
+		withMaxSteps(*maxSteps),
+		withTimeout(*timeout),
+		withMemoryLimit(*maxMemory),
+	}
+	// 脚本后面的参数通过 args 交给脚本
+	args := flag.Args()
+	if len(args) > 0 {
+		err := runFile(args[0], append(opts, withArgs(args[1:]))...)
+		var runErr *runError
+		if errors.As(err, &runErr) {
+			if !runErr.reported {
+				fmt.Println("Run File Error: ", err)
+			}
+			os.Exit(runErr.code)
 		}
 	} else {
-		if err := runPrompt(); err != nil {
+		if err := runPrompt(opts...); err != nil {
 			fmt.Println("Error: ", err)
 		}
 	}
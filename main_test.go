@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runFile_exitCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		opts     []interpreterOption
+		wantCode int // 0 表示成功
+	}{
+		{"success", `print 1;`, nil, 0},
+		{"scan error", `print 1 @ 2;`, nil, exitSyntaxError},
+		{"parse error", `print (1;`, nil, exitSyntaxError},
+		{"resolve error", `return 1;`, nil, exitResolveError},
+		{"runtime error", `print nil + 1;`, nil, exitRuntimeError},
+		{"event loop error", `async fun bad() { return nil + 1; } bad();`, nil, exitRuntimeError},
+		{"io error", `fs.readFile("missing.txt");`, []interpreterOption{withFSRoot(os.TempDir())}, exitIOError},
+		{"caught io error", `try { fs.readFile("missing.txt"); } catch (e) { print e.kind; }`, []interpreterOption{withFSRoot(os.TempDir())}, 0},
+		{"exit code from script", `exit(9);`, nil, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := run(tt.source, tt.opts...)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Errorf("got err %v, want success", err)
+				}
+				return
+			}
+			var runErr *runError
+			if !errors.As(err, &runErr) || runErr.code != tt.wantCode {
+				t.Errorf("got err %v, want exit code %d", err, tt.wantCode)
+			}
+		})
+	}
+
+	t.Run("event loop error is reported by main", func(t *testing.T) {
+		err := run(`async fun bad() { return nil + 1; } bad();`)
+		var runErr *runError
+		if !errors.As(err, &runErr) || runErr.reported {
+			t.Errorf("got err %v, want an unreported runError", err)
+		}
+		if !errors.Is(err, TypeError) {
+			t.Errorf("got err %v, want a TypeError inside", err)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		err := runFile(filepath.Join(t.TempDir(), "missing.lox"))
+		var runErr *runError
+		if !errors.As(err, &runErr) || runErr.code != exitNoInput {
+			t.Errorf("got err %v, want exit code %d", err, exitNoInput)
+		}
+	})
+}
+
+func Test_allowFlag_deniesByDefault(t *testing.T) {
+	caps, err := parseCapabilities(flag.Lookup("allow").DefValue)
+	if err != nil || len(caps) != 0 {
+		t.Fatalf("got %v, %v, want no capabilities by default", caps, err)
+	}
+	var capErr *CapabilityError
+	if err := run(`print clock();`, withCapabilities(caps...)); !errors.As(err, &capErr) {
+		t.Errorf("got err %v, want a capability error", err)
+	}
+	if err := run(`print 1 + 2;`, withCapabilities(caps...)); err != nil {
+		t.Errorf("got err %v, want natives without capabilities to run", err)
+	}
+}
+
+func Test_run_runtimeErrorKind(t *testing.T) {
+	err := run("var a = 1;\nprint a + nil;")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("got err %v, want a RuntimeError", err)
+	}
+	if runtimeErr.Kind != TypeError || runtimeErr.Line() != 2 || runtimeErr.Token.Lexeme != "+" {
+		t.Errorf("got %s at line %d on %q, want TypeError at line 2 on \"+\"", runtimeErr.Kind, runtimeErr.Line(), runtimeErr.Token.Lexeme)
+	}
+	if len(runtimeErr.Values) != 2 || runtimeErr.Values[0] != 1.0 || runtimeErr.Values[1] != nil {
+		t.Errorf("got values %v, want [1 nil]", runtimeErr.Values)
+	}
+}
+
+func Test_runREPL_sharesStdin(t *testing.T) {
+	var out strings.Builder
+	input := "print readLine();\nread by the script\nprint readAll();\nrest\n"
+	err := runREPL(bufio.NewReader(strings.NewReader(input)), withStdout(&out))
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("got err %v, want io.EOF at the end of input", err)
+	}
+	if want := "read by the script\nrest\n\n"; out.String() != want {
+		t.Errorf("got output %q, want %q", out.String(), want)
+	}
+}
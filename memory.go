@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// MemoryLimitError 在记账的内存超过上限的时候返回。
+type MemoryLimitError struct {
+	Limit     int64
+	InUse     int64
+	Requested int64
+}
+
+func (e *MemoryLimitError) Error() string {
+	return fmt.Sprintf("memory limit exceeded: allocating %d bytes with %d bytes in use exceeds the limit of %d bytes", e.Requested, e.InUse, e.Limit)
+}
+
+type memoryKind int
+
+const (
+	memoryStrings memoryKind = iota
+	memoryInstances
+	memoryEnvironments
+	memoryCollections
+	memoryKinds
+)
+
+func (k memoryKind) String() string {
+	return [...]string{"strings", "instances", "environments", "collections"}[k]
+}
+
+// 各种值大概占用的字节数，只用来估算，不追求跟 go 实际的内存分配一致。
+const (
+	stringOverhead   = 16
+	instanceOverhead = 64
+	fieldCost        = 48
+	envOverhead      = 64
+	variableCost     = 48
+	collectionCost   = 48
+	elementCost      = 16
+	mapEntryCost     = 48
+	rangeCost        = 48
+	matchCost        = 64
+)
+
+// MemoryStats 是一次执行的内存统计。
+type MemoryStats struct {
+	Allocated int64 // 累计分配的字节数
+	InUse     int64 // 还在使用的字节数，是一个估计值，接近上限的时候会用 GC 之后存活的 heap 校正
+	Peak      int64 // InUse 的最大值
+	ByKind    map[string]int64
+}
+
+func (s MemoryStats) String() string {
+	return fmt.Sprintf("memory: peak %d bytes, in use %d bytes, allocated %d bytes (strings %d, instances %d, environments %d, collections %d)",
+		s.Peak, s.InUse, s.Allocated,
+		s.ByKind[memoryStrings.String()], s.ByKind[memoryInstances.String()], s.ByKind[memoryEnvironments.String()], s.ByKind[memoryCollections.String()])
+}
+
+// memoryAccount 近似地记录 Lox 代码使用的内存，同一个 interpreter fork 出来的 interpreter 共享同一个 memoryAccount。
+// 分配的时候按估计的大小记账；env 在 block 或者调用结束、而且没有被 closure 捕获的时候释放，
+// 但是没法知道 string、instance 和 collection 什么时候变成了垃圾，所以记账得到的 InUse 只会偏大。
+// 记账超过上限的时候先执行一次 GC，用 heap 中实际存活的字节数（减去开始执行时的基准）校正 InUse，
+// 校正之后还是超过上限才返回 MemoryLimitError，这样长时间运行、但是存活的数据很少的程序不会被误杀。
+// GC 会暂停整个进程，所以只有距离上一次校正又分配了 limit/reconcileDivisor 字节之后才再校正一次。
+// heap 是整个进程共享的，包括同时运行的其它 interpreter 使用的内存，所以校正只会让 InUse 变小，不会让它变大。
+type memoryAccount struct {
+	mu        sync.Mutex
+	limit     int64 // 为 0 的时候不限制
+	allocated int64
+	inUse     int64
+	peak      int64
+	byKind    [memoryKinds]int64
+	// baseline 是开始执行的时候 heap 中存活的字节数，包括 AST 和 interpreter 自己的数据。
+	baseline int64
+	// reconciledAt 是上一次校正的时候 allocated 的值。
+	reconciledAt int64
+	// liveHeap 返回 GC 之后 heap 中存活的字节数。
+	liveHeap func() int64
+}
+
+// reconcileDivisor 决定两次校正之间至少要分配多少字节，值越大校正越频繁。
+const reconcileDivisor = 4
+
+func newMemoryAccount() *memoryAccount {
+	return &memoryAccount{liveHeap: liveHeap}
+}
+
+func liveHeap() int64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.HeapAlloc)
+}
+
+// start 在开始执行的时候记录 heap 的基准，没有上限的时候不需要校正，也就不需要执行 GC。
+func (m *memoryAccount) start() {
+	m.mu.Lock()
+	limit := m.limit
+	m.mu.Unlock()
+	if limit == 0 {
+		return
+	}
+	baseline := m.liveHeap()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.baseline = baseline
+	m.reconciledAt = m.allocated
+}
+
+// reconcile 用 heap 中实际存活的字节数校正 inUse，调用的时候持有 m.mu，GC 的时候会暂时释放它，
+// 这样 GC 的时候其它 goroutine 中的 task 和 generator 不会阻塞在记账上。
+// 距离上一次校正分配的字节数不够的时候不校正。
+func (m *memoryAccount) reconcile() {
+	if m.allocated-m.reconciledAt < m.limit/reconcileDivisor {
+		return
+	}
+	m.reconciledAt = m.allocated
+	baseline := m.baseline
+	m.mu.Unlock()
+	live := m.liveHeap() - baseline
+	m.mu.Lock()
+	if live < 0 {
+		live = 0
+	}
+	if live < m.inUse {
+		m.inUse = live
+	}
+}
+
+// charge 记录分配了 bytes 字节，校正之后还是超过上限的时候返回 MemoryLimitError，这时不会记录这次分配。
+func (m *memoryAccount) charge(kind memoryKind, bytes int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.limit > 0 && m.inUse+bytes > m.limit {
+		m.reconcile()
+		if m.inUse+bytes > m.limit {
+			return &MemoryLimitError{Limit: m.limit, InUse: m.inUse, Requested: bytes}
+		}
+	}
+	m.allocated += bytes
+	m.inUse += bytes
+	m.byKind[kind] += bytes
+	if m.inUse > m.peak {
+		m.peak = m.inUse
+	}
+	return nil
+}
+
+func (m *memoryAccount) release(bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 校正之后 inUse 不再是记账的总和，释放的时候不能小于 0。
+	m.inUse -= bytes
+	if m.inUse < 0 {
+		m.inUse = 0
+	}
+}
+
+// chargeString 记录新创建的 string。
+func (m *memoryAccount) chargeString(s string) error {
+	return m.charge(memoryStrings, stringOverhead+int64(len(s)))
+}
+
+// chargeEnv 记录 env 和其中的 variables 的分配，释放的时候按照记在 env 上的字节数释放。
+func (m *memoryAccount) chargeEnv(env *Env, variables int) error {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	bytes := int64(variables) * variableCost
+	if env.charged == 0 {
+		bytes += envOverhead
+	}
+	if err := m.charge(memoryEnvironments, bytes); err != nil {
+		return err
+	}
+	env.charged += bytes
+	return nil
+}
+
+// releaseEnv 释放不再使用的 env，被 closure 捕获的 env 还可能被用到，不释放。
+func (m *memoryAccount) releaseEnv(env *Env) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.captured || env.charged == 0 {
+		return
+	}
+	m.release(env.charged)
+	env.charged = 0
+}
+
+func (m *memoryAccount) stats() MemoryStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byKind := make(map[string]int64, memoryKinds)
+	for kind := memoryKind(0); kind < memoryKinds; kind++ {
+		byKind[kind.String()] = m.byKind[kind]
+	}
+	return MemoryStats{
+		Allocated: m.allocated,
+		InUse:     m.inUse,
+		Peak:      m.peak,
+		ByKind:    byKind,
+	}
+}
@@ -1,21 +1,87 @@
 package main
 
-import "time"
+// nativeFunction 把一个 go 函数包装成 Callable，用来实现内置的函数和方法。
+type nativeFunction struct {
+	name  string
+	arity Arity
+	fn    func(intp Interpreter, args []interface{}) (interface{}, error)
+	// 调用之前 interpreter 要被授予这个 capability，为空的时候不需要授权。
+	capability capability
+	// 参数名，设置了之后可以使用具名参数调用，中间没有传值的参数是 argNotProvided。
+	params []string
+}
+
+func newNativeFunction(name string, arity Arity, fn func(intp Interpreter, args []interface{}) (interface{}, error)) *nativeFunction {
+	return &nativeFunction{
+		name:  name,
+		arity: arity,
+		fn:    fn,
+	}
+}
+
+func (f *nativeFunction) String() string {
+	return "<native function: " + f.name + ">"
+}
+
+func (f *nativeFunction) Arity() Arity {
+	return f.arity
+}
+
+// requires 声明调用 f 需要的 capability。
+func (f *nativeFunction) requires(c capability) *nativeFunction {
+	f.capability = c
+	return f
+}
 
-type nativeFunctionClock struct{}
+// withParams 声明 f 的参数名，让 f 可以使用具名参数调用。
+func (f *nativeFunction) withParams(names ...string) *nativeFunction {
+	f.params = names
+	return f
+}
+
+func (f *nativeFunction) ParamNames() []string {
+	return f.params
+}
 
-func newNativeFunctionClock() *nativeFunctionClock {
-	return &nativeFunctionClock{}
+// optionalArg 返回第 idx 个参数，没有传值的时候第二个返回值是 false。
+func optionalArg(args []interface{}, idx int) (interface{}, bool) {
+	if idx >= len(args) || args[idx] == argNotProvided {
+		return nil, false
+	}
+	return args[idx], true
 }
 
-func (nativeFunctionClock) String() string {
-	return "clock"
+func (f *nativeFunction) Call(intp Interpreter, args []interface{}) (interface{}, error) {
+	if f.capability != "" && !intp.HasCapability(f.capability) {
+		return nil, &CapabilityError{Native: f.name, Capability: f.capability}
+	}
+	return f.fn(intp, args)
 }
 
-func (nativeFunctionClock) Arity() int {
-	return 0
+// newNativeFunctionClock 返回 clock()，结果是 event loop 的 clock 的当前时间，单位是毫秒。
+func newNativeFunctionClock() *nativeFunction {
+	return newNativeFunction("clock", fixedArity(0), func(intp Interpreter, args []interface{}) (interface{}, error) {
+		return float64(intp.EventLoop().clock.Now().UnixMilli()), nil
+	}).requires(capabilityClock)
 }
 
-func (nativeFunctionClock) Call(intp Interpreter, args []interface{}) (interface{}, error) {
-	return time.Now().UnixMilli(), nil
+// newNativeFunctionRange 返回 range(end)、range(start, end) 或 range(start, end, step)，结果是惰性求值的。
+func newNativeFunctionRange() *nativeFunction {
+	return newNativeFunction("range", Arity{Min: 1, Max: 3}, func(intp Interpreter, args []interface{}) (interface{}, error) {
+		bounds := []float64{0, 0, 1}
+		for idx, arg := range args {
+			v, ok := arg.(float64)
+			if !ok {
+				return nil, newRuntimeError(TypeError, token{}, "range arguments must be numbers, got %v", arg)
+			}
+			bounds[idx] = v
+		}
+		if len(args) == 1 {
+			bounds[0], bounds[1] = 0, bounds[0]
+		}
+		if err := intp.Memory().charge(memoryCollections, rangeCost); err != nil {
+			return nil, err
+		}
+		return newLoxRange(bounds[0], bounds[1], bounds[2])
+	})
 }
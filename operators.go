@@ -0,0 +1,113 @@
+package main
+
+// specialMethods 是二元运算符对应的 special method。
+// 左边的操作数没有实现的时候，调用右边操作数的 reflected method，比如 `1 + v` 调用的是 `v.__radd(1)`。
+// 比较运算符的 reflected method 是反过来的比较，`1 < v` 等价于 `v > 1`。
+var specialMethods = map[uint]struct {
+	method    string
+	reflected string
+}{
+	PLUS:          {"__add", "__radd"},
+	MINUS:         {"__sub", "__rsub"},
+	STAR:          {"__mul", "__rmul"},
+	SLASH:         {"__div", "__rdiv"},
+	LESS:          {"__lt", "__gt"},
+	LESS_EQUAL:    {"__le", "__ge"},
+	GREATER:       {"__gt", "__lt"},
+	GREATER_EQUAL: {"__ge", "__le"},
+	EQUAL_EQUAL:   {"__eq", "__eq"},
+}
+
+// nativeOperand 是支持二元运算符的内置类型，比如 datetime 和 duration。
+// reflected 表示自己是右边的操作数，第二个返回值表示是否支持这个运算。
+type nativeOperand interface {
+	operate(operatorType uint, other interface{}, reflected bool) (interface{}, bool, error)
+}
+
+// binaryOperator 在操作数是 LoxInstance 的时候调用对应的 special method，是 nativeOperand 的时候调用 operate，
+// 第二个返回值表示是否找到了 special method，没有找到的时候按普通的运算符处理。
+func (i *interpreter) binaryOperator(operatorType uint, left, right interface{}) (interface{}, bool, error) {
+	methods, ok := specialMethods[operatorType]
+	if !ok {
+		return nil, false, nil
+	}
+	if instance, ok := left.(*LoxInstance); ok {
+		if v, ok, err := i.callSpecialMethod(instance, methods.method, right); ok || err != nil {
+			return v, ok, err
+		}
+	}
+	if instance, ok := right.(*LoxInstance); ok {
+		if v, ok, err := i.callSpecialMethod(instance, methods.reflected, left); ok || err != nil {
+			return v, ok, err
+		}
+	}
+	if operand, ok := left.(nativeOperand); ok {
+		if v, ok, err := operand.operate(operatorType, right, false); ok || err != nil {
+			return v, ok, err
+		}
+	}
+	if operand, ok := right.(nativeOperand); ok {
+		if v, ok, err := operand.operate(operatorType, left, true); ok || err != nil {
+			return v, ok, err
+		}
+	}
+	return nil, false, nil
+}
+
+// callSpecialMethod 调用 instance 上名为 name 的 method，getter 不算 special method。
+func (i *interpreter) callSpecialMethod(instance *LoxInstance, name string, args ...interface{}) (interface{}, bool, error) {
+	method, err := instance.class.FindMethod(name)
+	if err != nil {
+		return nil, false, err
+	}
+	if method == nil || method.isGetter {
+		return nil, false, nil
+	}
+	bound, err := method.Bind(instance)
+	if err != nil {
+		return nil, false, err
+	}
+	args, err = bindArguments(bound, args, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	v, err := i.call(bound, args, 0)
+	return v, true, err
+}
+
+// stringify 返回值在 lox 中的字符串形式，print、string 的拼接和 format 都使用它。
+// instance 可以通过 `__str` 或者 `toString` 自定义，list 和 map 中的 instance 也一样。
+func (i *interpreter) stringify(value interface{}) (string, error) {
+	return i.stringifyValue(value, map[interface{}]bool{})
+}
+
+func (i *interpreter) Stringify(value interface{}) (string, error) {
+	return i.stringify(value)
+}
+
+// stringifyValue 中的 visiting 是正在输出的 list 和 map，见 stringifyCollection。
+func (i *interpreter) stringifyValue(value interface{}, visiting map[interface{}]bool) (string, error) {
+	switch v := value.(type) {
+	case *LoxInstance:
+		for _, name := range []string{"__str", "toString"} {
+			str, ok, err := i.callSpecialMethod(v, name)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				s, isString := str.(string)
+				if !isString {
+					return "", newRuntimeError(TypeError, token{}, "%s of %s must return a string, got %v", name, v, loxString(str))
+				}
+				return s, nil
+			}
+		}
+	}
+	s, ok, err := stringifyCollection(value, visiting, func(element interface{}) (string, error) {
+		return i.stringifyValue(element, visiting)
+	})
+	if ok {
+		return s, err
+	}
+	return loxString(value), nil
+}
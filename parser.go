@@ -7,8 +7,14 @@ import (
 const (
 	maxArgsCount = 128
 
-	typeFunction = "function"
-	typeMethod   = "method"
+	typeFunction        = "function"
+	typeMethod          = "method"
+	typeStaticMethod    = "static method"
+	typeGetter          = "getter"
+	typeSetter          = "setter"
+	typePrivateMethod   = "private method"
+	typeAbstractMethod  = "abstract method"
+	typeInterfaceMethod = "interface method"
 )
 
 // syntactic analysis
@@ -81,7 +87,7 @@ func (p *parser) and() (Expr, error) {
 }
 
 func (p *parser) assignment() (Expr, error) {
-	expr, err := p.or()
+	expr, err := p.conditional()
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +101,7 @@ func (p *parser) assignment() (Expr, error) {
 		case *VarExpr:
 			return newAssignExpr(v.name, value), nil
 		case *GetExpr:
-			return newSetExpr(v, v.name, value), nil
+			return newSetExpr(v.object, v.name, value), nil
 		default:
 			return nil, fmt.Errorf("token: %s, invalid assign target", equalToken)
 		}
@@ -103,14 +109,72 @@ func (p *parser) assignment() (Expr, error) {
 	return expr, nil
 }
 
+// `cond ? a : b` 是右结合的，`a ? b : c ? d : e` 等价于 `a ? b : (c ? d : e)`。
+func (p *parser) conditional() (Expr, error) {
+	expr, err := p.coalesce()
+	if err != nil {
+		return nil, err
+	}
+	if p.match(QUESTION) {
+		thenBranch, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		token, ok := p.consume(COLON)
+		if !ok {
+			p.parseErr(token, "expect ':' after then branch of conditional expression")
+			return nil, fmt.Errorf("expect ':' after then branch of conditional expression")
+		}
+		elseBranch, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		expr = newConditionalExpr(expr, thenBranch, elseBranch)
+	}
+	return expr, nil
+}
+
+// `??` 的优先级比 or 低，`a ?? b or c` 等价于 `a ?? (b or c)`。
+func (p *parser) coalesce() (Expr, error) {
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	for p.match(QUESTION_QUESTION) {
+		operator := p.previous()
+		right, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		expr = newLogicalExpr(operator, expr, right)
+	}
+	return expr, nil
+}
+
 func (p *parser) declaration() (Stmt, error) {
 	// 这里可以单独处理下错误，如果当前语句解析出错，还可以继续解析。
 	if p.match(CLASS) {
 		return p.classDeclaration()
 	}
+	if p.match(TRAIT) {
+		return p.traitDeclaration()
+	}
+	if p.match(INTERFACE) {
+		return p.interfaceDeclaration()
+	}
 	if p.match(FUN) {
+		if p.match(STAR) {
+			return p.generator(typeFunction)
+		}
 		return p.function(typeFunction)
 	}
+	if p.match(ASYNC) {
+		if token, ok := p.consume(FUN); !ok {
+			p.parseErr(token, "expect 'fun' after 'async'")
+			return nil, fmt.Errorf("token: %s, expect 'fun' after 'async'", token)
+		}
+		return p.asyncFunction(typeFunction)
+	}
 	if p.match(VAR) {
 		return p.varDeclaration()
 	}
@@ -132,14 +196,138 @@ func (p *parser) classDeclaration() (Stmt, error) {
 		}
 		superclass = newVarExpr(superclassName)
 	}
+	var traits []*VarExpr
+	if p.matchModifier("with") {
+		for {
+			traitName, ok := p.consume(IDENTIFIER)
+			if !ok {
+				p.parseErr(traitName, "expect trait name")
+				return nil, fmt.Errorf("expect trait name")
+			}
+			traits = append(traits, newVarExpr(traitName))
+			if !p.match(COMMA) {
+				break
+			}
+		}
+	}
+	var interfaces []*VarExpr
+	if p.matchModifier("implements") {
+		for {
+			interfaceName, ok := p.consume(IDENTIFIER)
+			if !ok {
+				p.parseErr(interfaceName, "expect interface name")
+				return nil, fmt.Errorf("expect interface name")
+			}
+			interfaces = append(interfaces, newVarExpr(interfaceName))
+			if !p.match(COMMA) {
+				break
+			}
+		}
+	}
 	token, ok := p.consume(LEFT_BRACE)
 	if !ok {
 		p.parseErr(token, fmt.Sprintf("expect '{' before class body"))
 		return nil, fmt.Errorf("expect '{' before class body")
 	}
-	var methods []FunctionStmt
+	var methods, staticMethods, getters, setters, privateMethods, abstractMethods []FunctionStmt
+	var staticFields, privateFields []VarStmt
 	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
-		methodStmt, err := p.function(typeMethod)
+		// `#name(...) {}` 是 private method，`#name = value;` 是 private field。
+		if p.check(PRIVATE_IDENTIFIER) {
+			if !p.checkNext(LEFT_PAREN) {
+				name := p.advance()
+				var value Expr
+				if p.match(EQUAL) {
+					var err error
+					value, err = p.expression()
+					if err != nil {
+						return nil, err
+					}
+				}
+				token, ok := p.consume(SEMICOLON)
+				if !ok {
+					p.parseErr(token, "expect ';' after private field declaration")
+					return nil, fmt.Errorf("expect ';' after private field declaration")
+				}
+				privateFields = append(privateFields, newVarStmt(name, value).(VarStmt))
+				continue
+			}
+			methodStmt, err := p.function(typePrivateMethod)
+			if err != nil {
+				return nil, err
+			}
+			method, ok := methodStmt.(FunctionStmt)
+			if !ok {
+				return nil, errCastStmt2FunctionStmt
+			}
+			privateMethods = append(privateMethods, method)
+			continue
+		}
+		// 没有参数列表的 `name { ... }` 是 getter。
+		if p.check(IDENTIFIER) && p.checkNext(LEFT_BRACE) {
+			name := p.advance()
+			p.advance()
+			block, err := p.block()
+			if err != nil {
+				return nil, err
+			}
+			getters = append(getters, newFunctionStmt(name, nil, nil, nil, block).(FunctionStmt))
+			continue
+		}
+		if p.matchModifier("set") {
+			setterStmt, err := p.function(typeSetter)
+			if err != nil {
+				return nil, err
+			}
+			setter, ok := setterStmt.(FunctionStmt)
+			if !ok {
+				return nil, errCastStmt2FunctionStmt
+			}
+			if len(setter.params) != 1 || setter.defaults[0] != nil || setter.rest != nil {
+				p.parseErr(setter.name, "setter must have exactly one parameter")
+				return nil, fmt.Errorf("setter %s must have exactly one parameter", setter.name)
+			}
+			setters = append(setters, setter)
+			continue
+		}
+		if p.matchModifier("abstract") {
+			method, err := p.abstractFunction(typeAbstractMethod)
+			if err != nil {
+				return nil, err
+			}
+			abstractMethods = append(abstractMethods, method)
+			continue
+		}
+		if p.matchModifier("static") {
+			// `static name(...) {}` 是 static method，`static name = value;` 是 static field。
+			if !p.checkNext(LEFT_PAREN) {
+				field, err := p.varDeclaration()
+				if err != nil {
+					return nil, err
+				}
+				staticFields = append(staticFields, field.(VarStmt))
+				continue
+			}
+			methodStmt, err := p.function(typeStaticMethod)
+			if err != nil {
+				return nil, err
+			}
+			method, ok := methodStmt.(FunctionStmt)
+			if !ok {
+				return nil, errCastStmt2FunctionStmt
+			}
+			staticMethods = append(staticMethods, method)
+			continue
+		}
+		var methodStmt Stmt
+		var err error
+		if p.match(STAR) {
+			methodStmt, err = p.generator(typeMethod)
+		} else if p.match(ASYNC) {
+			methodStmt, err = p.asyncFunction(typeMethod)
+		} else {
+			methodStmt, err = p.function(typeMethod)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -155,32 +343,192 @@ func (p *parser) classDeclaration() (Stmt, error) {
 		return nil, fmt.Errorf("expect '}' after class body")
 	}
 
-	return newClassStmt(name, superclass, methods), nil
+	classStmt := newClassStmt(name, superclass, methods)
+	classStmt.traits = traits
+	classStmt.staticMethods = staticMethods
+	classStmt.staticFields = staticFields
+	classStmt.getters = getters
+	classStmt.setters = setters
+	classStmt.privateMethods = privateMethods
+	classStmt.privateFields = privateFields
+	classStmt.abstractMethods = abstractMethods
+	classStmt.interfaces = interfaces
+	return classStmt, nil
 }
 
-func (p *parser) function(kind string) (Stmt, error) {
+func (p *parser) traitDeclaration() (Stmt, error) {
 	name, ok := p.consume(IDENTIFIER)
+	if !ok {
+		p.parseErr(name, "expect trait name")
+		return nil, fmt.Errorf("expect trait name")
+	}
+	token, ok := p.consume(LEFT_BRACE)
+	if !ok {
+		p.parseErr(token, "expect '{' before trait body")
+		return nil, fmt.Errorf("expect '{' before trait body")
+	}
+	var methods []FunctionStmt
+	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		methodStmt, err := p.function(typeMethod)
+		if err != nil {
+			return nil, err
+		}
+		method, ok := methodStmt.(FunctionStmt)
+		if !ok {
+			return nil, errCastStmt2FunctionStmt
+		}
+		methods = append(methods, method)
+	}
+	token, ok = p.consume(RIGHT_BRACE)
+	if !ok {
+		p.parseErr(token, "expect '}' after trait body")
+		return nil, fmt.Errorf("expect '}' after trait body")
+	}
+	return newTraitStmt(name, methods), nil
+}
+
+func (p *parser) interfaceDeclaration() (Stmt, error) {
+	name, ok := p.consume(IDENTIFIER)
+	if !ok {
+		p.parseErr(name, "expect interface name")
+		return nil, fmt.Errorf("expect interface name")
+	}
+	token, ok := p.consume(LEFT_BRACE)
+	if !ok {
+		p.parseErr(token, "expect '{' before interface body")
+		return nil, fmt.Errorf("expect '{' before interface body")
+	}
+	var methods []FunctionStmt
+	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		method, err := p.abstractFunction(typeInterfaceMethod)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, method)
+	}
+	token, ok = p.consume(RIGHT_BRACE)
+	if !ok {
+		p.parseErr(token, "expect '}' after interface body")
+		return nil, fmt.Errorf("expect '}' after interface body")
+	}
+	return newInterfaceStmt(name, methods), nil
+}
+
+// generator 解析 `fun*` 声明的函数和 `*name()` 声明的 method。
+func (p *parser) generator(kind string) (Stmt, error) {
+	stmt, err := p.function(kind)
+	if err != nil {
+		return nil, err
+	}
+	function, ok := stmt.(FunctionStmt)
+	if !ok {
+		return nil, errCastStmt2FunctionStmt
+	}
+	function.isGenerator = true
+	return function, nil
+}
+
+// asyncFunction 解析 `async fun` 声明的函数和 `async name()` 声明的 method。
+func (p *parser) asyncFunction(kind string) (Stmt, error) {
+	stmt, err := p.function(kind)
+	if err != nil {
+		return nil, err
+	}
+	function, ok := stmt.(FunctionStmt)
+	if !ok {
+		return nil, errCastStmt2FunctionStmt
+	}
+	function.isAsync = true
+	return function, nil
+}
+
+func (p *parser) function(kind string) (Stmt, error) {
+	function, err := p.functionSignature(kind)
+	if err != nil {
+		return nil, err
+	}
+	if token, ok := p.consume(LEFT_BRACE); !ok {
+		p.parseErr(token, fmt.Sprintf("expect '{' after %s name", kind))
+		return nil, fmt.Errorf("expect ')' after %s name", kind)
+	}
+	block, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	// block 中已经检查过 } 了，所以这里不需要再检查。
+	function.stmts = block
+	return function, nil
+}
+
+// abstractFunction 解析没有 body 的 method 声明，比如 abstract method 和 interface 中的 method。
+func (p *parser) abstractFunction(kind string) (FunctionStmt, error) {
+	function, err := p.functionSignature(kind)
+	if err != nil {
+		return FunctionStmt{}, err
+	}
+	if token, ok := p.consume(SEMICOLON); !ok {
+		p.parseErr(token, fmt.Sprintf("expect ';' after %s declaration", kind))
+		return FunctionStmt{}, fmt.Errorf("expect ';' after %s declaration", kind)
+	}
+	function.isAbstract = true
+	return function, nil
+}
+
+// functionSignature 解析 function 的名字和参数列表，不包括 body。
+func (p *parser) functionSignature(kind string) (FunctionStmt, error) {
+	var nameType uint = IDENTIFIER
+	if kind == typePrivateMethod {
+		nameType = PRIVATE_IDENTIFIER
+	}
+	name, ok := p.consume(nameType)
 	if !ok {
 		p.parseErr(name, fmt.Sprintf("expect '%s' name", kind))
-		return nil, fmt.Errorf("expect '%s' name", kind)
+		return FunctionStmt{}, fmt.Errorf("expect '%s' name", kind)
 	}
 	if token, ok := p.consume(LEFT_PAREN); !ok {
 		p.parseErr(token, fmt.Sprintf("expect '(' after %s name", kind))
-		return nil, fmt.Errorf("expect '(' after %s name", kind)
+		return FunctionStmt{}, fmt.Errorf("expect '(' after %s name", kind)
 	}
 	var args []token
+	var defaults []Expr
+	var rest *token
 	ok = p.check(RIGHT_PAREN)
 	if !ok {
 		for {
 			if len(args) > maxArgsCount {
-				return nil, fmt.Errorf("token: %v cannot have more than %d args", p.peek(), maxArgsCount)
+				return FunctionStmt{}, fmt.Errorf("token: %v cannot have more than %d args", p.peek(), maxArgsCount)
+			}
+			if p.match(ELLIPSIS) {
+				restName, ok := p.consume(IDENTIFIER)
+				if !ok {
+					p.parseErr(restName, "expect rest parameter name after '...'")
+					return FunctionStmt{}, fmt.Errorf("expect rest parameter name after '...'")
+				}
+				if p.check(COMMA) {
+					p.parseErr(restName, "rest parameter must be the last parameter")
+					return FunctionStmt{}, fmt.Errorf("rest parameter %s must be the last parameter", restName)
+				}
+				rest = &restName
+				break
 			}
-			if token, ok := p.consume(IDENTIFIER); !ok {
+			token, ok := p.consume(IDENTIFIER)
+			if !ok {
 				p.parseErr(token, "expect parameter name")
-				return nil, fmt.Errorf("expect parameter name")
-			} else {
-				args = append(args, token)
+				return FunctionStmt{}, fmt.Errorf("expect parameter name")
+			}
+			var defaultValue Expr
+			if p.match(EQUAL) {
+				var err error
+				defaultValue, err = p.expression()
+				if err != nil {
+					return FunctionStmt{}, err
+				}
+			} else if len(defaults) > 0 && defaults[len(defaults)-1] != nil {
+				p.parseErr(token, "parameter without default value cannot follow a parameter with default value")
+				return FunctionStmt{}, fmt.Errorf("parameter %s without default value cannot follow a parameter with default value", token)
 			}
+			args = append(args, token)
+			defaults = append(defaults, defaultValue)
 			if !p.match(COMMA) {
 				break
 			}
@@ -189,19 +537,9 @@ func (p *parser) function(kind string) (Stmt, error) {
 
 	if token, ok := p.consume(RIGHT_PAREN); !ok {
 		p.parseErr(token, fmt.Sprintf("expect ')' after %s name", kind))
-		return nil, fmt.Errorf("expect ')' after %s name", kind)
-	}
-
-	if token, ok := p.consume(LEFT_BRACE); !ok {
-		p.parseErr(token, fmt.Sprintf("expect '{' after %s name", kind))
-		return nil, fmt.Errorf("expect ')' after %s name", kind)
+		return FunctionStmt{}, fmt.Errorf("expect ')' after %s name", kind)
 	}
-	block, err := p.block()
-	if err != nil {
-		return nil, err
-	}
-	// block 中已经检查过 } 了，所以这里不需要再检查。
-	return newFunctionStmt(name, args, block), nil
+	return newFunctionStmt(name, args, defaults, rest, nil).(FunctionStmt), nil
 }
 
 func (p *parser) statement() (Stmt, error) {
@@ -220,6 +558,21 @@ func (p *parser) statement() (Stmt, error) {
 	if p.match(FOR) {
 		return p.forStatement()
 	}
+	if p.match(MATCH) {
+		return p.matchStatement()
+	}
+	if p.match(BREAK, CONTINUE) {
+		return p.loopControlStatement()
+	}
+	if p.match(YIELD) {
+		return p.yieldStatement()
+	}
+	if p.match(SELECT) {
+		return p.selectStatement()
+	}
+	if p.match(TRY) {
+		return p.tryStatement()
+	}
 	if p.match(LEFT_BRACE) {
 		stmts, err := p.block()
 		if err != nil {
@@ -230,6 +583,38 @@ func (p *parser) statement() (Stmt, error) {
 	return p.expressionStatement()
 }
 
+// loopControlStatement 解析 break 和 continue，是否在循环中由 resolver 检查。
+func (p *parser) loopControlStatement() (Stmt, error) {
+	keyword := p.previous()
+	token, ok := p.consume(SEMICOLON)
+	if !ok {
+		p.parseErr(token, fmt.Sprintf("expect ';' after '%s'", keyword.Lexeme))
+		return nil, fmt.Errorf("expect ';' after '%s'", keyword.Lexeme)
+	}
+	if keyword.Type == BREAK {
+		return newBreakStmt(keyword), nil
+	}
+	return newContinueStmt(keyword), nil
+}
+
+func (p *parser) yieldStatement() (Stmt, error) {
+	keyword := p.previous()
+	var value Expr
+	if !p.check(SEMICOLON) {
+		var err error
+		value, err = p.expression()
+		if err != nil {
+			return nil, err
+		}
+	}
+	token, ok := p.consume(SEMICOLON)
+	if !ok {
+		p.parseErr(token, "expect ';' after yield value")
+		return nil, fmt.Errorf("expect ';' after yield value")
+	}
+	return newYieldStmt(keyword, value), nil
+}
+
 func (p *parser) returnStatement() (Stmt, error) {
 	keyword := p.previous()
 	var value Expr
@@ -307,6 +692,14 @@ func (p *parser) forStatement() (Stmt, error) {
 		p.parseErr(token, "expect '(' after expression")
 		return nil, fmt.Errorf("expect '(' after expression")
 	}
+	// `for (x in xs)` 和 `for (var x in xs)`，in 不是 keyword，只在这个位置有特殊含义。
+	if p.check(VAR) && p.checkNextN(1, IDENTIFIER) && p.isContextual(2, "in") {
+		p.advance()
+		return p.forInStatement()
+	}
+	if p.check(IDENTIFIER) && p.isContextual(1, "in") {
+		return p.forInStatement()
+	}
 	var initializer Stmt
 	if p.match(SEMICOLON) {
 
@@ -358,19 +751,37 @@ func (p *parser) forStatement() (Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	if increment != nil {
-		body = newBlockStmt([]Stmt{body, newExpressionStmt(increment)})
-	}
 	if condition == nil {
 		condition = newLiteralExpr(true)
 	}
-	body = newWhileStmt(condition, body)
+	loop := newWhileStmt(condition, body).(WhileStmt)
+	loop.increment = increment
+	body = loop
 	if initializer != nil {
 		body = newBlockStmt([]Stmt{initializer, body})
 	}
 	return body, nil
 }
 
+func (p *parser) forInStatement() (Stmt, error) {
+	name := p.advance()
+	// 跳过 in
+	p.advance()
+	iterable, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if token, ok := p.consume(RIGHT_PAREN); !ok {
+		p.parseErr(token, "expect ')' after for-in iterable")
+		return nil, fmt.Errorf("expect ')' after for-in iterable")
+	}
+	body, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	return newForInStmt(name, iterable, body), nil
+}
+
 func (p *parser) whileStatement() (Stmt, error) {
 	token, ok := p.consume(LEFT_PAREN)
 	if !ok {
@@ -423,6 +834,262 @@ func (p *parser) ifStatement() (Stmt, error) {
 	return newIFStmt(condition, thenBranch, elseBranch), nil
 }
 
+func (p *parser) matchStatement() (Stmt, error) {
+	keyword := p.previous()
+	token, ok := p.consume(LEFT_PAREN)
+	if !ok {
+		p.parseErr(token, "expect '(' after 'match'")
+		return nil, fmt.Errorf("expect '(' after 'match'")
+	}
+	subject, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	token, ok = p.consume(RIGHT_PAREN)
+	if !ok {
+		p.parseErr(token, "expect ')' after match subject")
+		return nil, fmt.Errorf("expect ')' after match subject")
+	}
+	token, ok = p.consume(LEFT_BRACE)
+	if !ok {
+		p.parseErr(token, "expect '{' before match body")
+		return nil, fmt.Errorf("expect '{' before match body")
+	}
+	var cases []MatchCase
+	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		token, ok := p.consume(CASE)
+		if !ok {
+			p.parseErr(p.peek(), "expect 'case' in match body")
+			return nil, fmt.Errorf("token: %s, expect 'case' in match body", p.peek())
+		}
+		var patterns []Pattern
+		for {
+			pattern, err := p.pattern()
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, pattern)
+			if !p.match(COMMA) {
+				break
+			}
+		}
+		var guard Expr
+		if p.match(IF) {
+			guard, err = p.expression()
+			if err != nil {
+				return nil, err
+			}
+		}
+		token, ok = p.consume(FAT_ARROW)
+		if !ok {
+			p.parseErr(token, "expect '=>' after case pattern")
+			return nil, fmt.Errorf("expect '=>' after case pattern")
+		}
+		body, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, MatchCase{
+			patterns: patterns,
+			guard:    guard,
+			body:     body,
+		})
+	}
+	token, ok = p.consume(RIGHT_BRACE)
+	if !ok {
+		p.parseErr(token, "expect '}' after match body")
+		return nil, fmt.Errorf("expect '}' after match body")
+	}
+	return newMatchStmt(keyword, subject, cases), nil
+}
+
+// tryStatement 解析 `try { ... } catch (e) { ... }`。
+func (p *parser) tryStatement() (Stmt, error) {
+	keyword := p.previous()
+	token, ok := p.consume(LEFT_BRACE)
+	if !ok {
+		p.parseErr(token, "expect '{' after 'try'")
+		return nil, fmt.Errorf("expect '{' after 'try'")
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	token, ok = p.consume(CATCH)
+	if !ok {
+		p.parseErr(token, "expect 'catch' after try block")
+		return nil, fmt.Errorf("expect 'catch' after try block")
+	}
+	token, ok = p.consume(LEFT_PAREN)
+	if !ok {
+		p.parseErr(token, "expect '(' after 'catch'")
+		return nil, fmt.Errorf("expect '(' after 'catch'")
+	}
+	name, ok := p.consume(IDENTIFIER)
+	if !ok {
+		p.parseErr(name, "expect error name in catch")
+		return nil, fmt.Errorf("expect error name in catch")
+	}
+	token, ok = p.consume(RIGHT_PAREN)
+	if !ok {
+		p.parseErr(token, "expect ')' after error name")
+		return nil, fmt.Errorf("expect ')' after error name")
+	}
+	token, ok = p.consume(LEFT_BRACE)
+	if !ok {
+		p.parseErr(token, "expect '{' after catch clause")
+		return nil, fmt.Errorf("expect '{' after catch clause")
+	}
+	handler, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	return newTryStmt(keyword, newBlockStmt(body), name, newBlockStmt(handler)), nil
+}
+
+func (p *parser) selectStatement() (Stmt, error) {
+	keyword := p.previous()
+	token, ok := p.consume(LEFT_BRACE)
+	if !ok {
+		p.parseErr(token, "expect '{' after 'select'")
+		return nil, fmt.Errorf("expect '{' after 'select'")
+	}
+	var cases []SelectCase
+	var hasDefault bool
+	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		caseKeyword, ok := p.consume(CASE)
+		if !ok {
+			p.parseErr(p.peek(), "expect 'case' in select body")
+			return nil, fmt.Errorf("token: %s, expect 'case' in select body", p.peek())
+		}
+		selectCase, err := p.selectCase(caseKeyword)
+		if err != nil {
+			return nil, err
+		}
+		if selectCase.channel == nil {
+			if hasDefault {
+				p.parseErr(caseKeyword, "select cannot have more than one default case")
+				return nil, fmt.Errorf("token: %s, select cannot have more than one default case", caseKeyword)
+			}
+			hasDefault = true
+		}
+		cases = append(cases, selectCase)
+	}
+	token, ok = p.consume(RIGHT_BRACE)
+	if !ok {
+		p.parseErr(token, "expect '}' after select body")
+		return nil, fmt.Errorf("expect '}' after select body")
+	}
+	return newSelectStmt(keyword, cases), nil
+}
+
+func (p *parser) selectCase(keyword token) (SelectCase, error) {
+	selectCase := SelectCase{keyword: keyword}
+	if p.check(IDENTIFIER) && p.peek().Lexeme == "_" && p.checkNext(FAT_ARROW) {
+		p.advance()
+	} else {
+		if p.check(IDENTIFIER) && p.checkNext(EQUAL) {
+			name := p.advance()
+			p.advance()
+			selectCase.name = &name
+		}
+		expr, err := p.call()
+		if err != nil {
+			return SelectCase{}, err
+		}
+		// 只支持 `ch.receive()` 和 `ch.send(x)` 这两种操作。
+		call, ok := expr.(*CallExpr)
+		var get *GetExpr
+		if ok {
+			get, ok = call.callee.(*GetExpr)
+		}
+		if !ok || len(call.namedArgs) > 0 ||
+			!(get.name.Lexeme == "receive" && len(call.args) == 0 || get.name.Lexeme == "send" && len(call.args) == 1) {
+			p.parseErr(keyword, "expect 'ch.receive()' or 'ch.send(value)' in select case")
+			return SelectCase{}, fmt.Errorf("token: %s, expect 'ch.receive()' or 'ch.send(value)' in select case", keyword)
+		}
+		selectCase.channel = get.object
+		if get.name.Lexeme == "send" {
+			if selectCase.name != nil {
+				p.parseErr(*selectCase.name, "cannot bind the result of send")
+				return SelectCase{}, fmt.Errorf("token: %s, cannot bind the result of send", *selectCase.name)
+			}
+			selectCase.send = true
+			selectCase.value = call.args[0]
+		}
+	}
+	if token, ok := p.consume(FAT_ARROW); !ok {
+		p.parseErr(token, "expect '=>' after select case")
+		return SelectCase{}, fmt.Errorf("expect '=>' after select case")
+	}
+	body, err := p.statement()
+	if err != nil {
+		return SelectCase{}, err
+	}
+	selectCase.body = body
+	return selectCase, nil
+}
+
+func (p *parser) pattern() (Pattern, error) {
+	if p.match(FALSE) {
+		return newLiteralPattern(false), nil
+	} else if p.match(TRUE) {
+		return newLiteralPattern(true), nil
+	} else if p.match(NIL) {
+		return newLiteralPattern(nil), nil
+	} else if p.match(STRING, NUMBER) {
+		return newLiteralPattern(p.previous().literal), nil
+	} else if p.match(MINUS) {
+		number, ok := p.consume(NUMBER)
+		if !ok {
+			p.parseErr(number, "expect number after '-' in pattern")
+			return nil, fmt.Errorf("expect number after '-' in pattern")
+		}
+		return newLiteralPattern(-number.literal.(float64)), nil
+	} else if p.match(IDENTIFIER) {
+		name := p.previous()
+		if name.Lexeme == "_" {
+			return newWildcardPattern(name), nil
+		}
+		if !p.match(LEFT_PAREN) {
+			return newBindingPattern(name), nil
+		}
+		var fields []FieldPattern
+		if !p.check(RIGHT_PAREN) {
+			for {
+				fieldName, ok := p.consume(IDENTIFIER)
+				if !ok {
+					p.parseErr(fieldName, "expect field name in class pattern")
+					return nil, fmt.Errorf("expect field name in class pattern")
+				}
+				var fieldPattern Pattern = newBindingPattern(fieldName)
+				if p.match(COLON) {
+					var err error
+					fieldPattern, err = p.pattern()
+					if err != nil {
+						return nil, err
+					}
+				}
+				fields = append(fields, FieldPattern{
+					name:    fieldName,
+					pattern: fieldPattern,
+				})
+				if !p.match(COMMA) {
+					break
+				}
+			}
+		}
+		token, ok := p.consume(RIGHT_PAREN)
+		if !ok {
+			p.parseErr(token, "expect ')' after class pattern fields")
+			return nil, fmt.Errorf("expect ')' after class pattern fields")
+		}
+		return newClassPattern(newVarExpr(name), fields), nil
+	}
+	token := p.peek()
+	return nil, fmt.Errorf("token: %+v, expect pattern", token)
+}
+
 func (p *parser) expressionStatement() (Stmt, error) {
 	expr, err := p.expression()
 	if err != nil {
@@ -433,7 +1100,7 @@ func (p *parser) expressionStatement() (Stmt, error) {
 		p.parseErr(token, "expect ';' after expression")
 		return nil, fmt.Errorf("expect ';' after expression")
 	}
-	return newPrintStmt(expr), nil
+	return newBarePrintStmt(expr), nil
 }
 
 func (p *parser) equality() (Expr, error) {
@@ -501,6 +1168,27 @@ func (p *parser) factor() (Expr, error) {
 }
 
 func (p *parser) unary() (Expr, error) {
+	if p.match(SPAWN) {
+		keyword := p.previous()
+		expr, err := p.call()
+		if err != nil {
+			return nil, err
+		}
+		call, ok := expr.(*CallExpr)
+		if !ok {
+			p.parseErr(keyword, "expect a call after 'spawn'")
+			return nil, fmt.Errorf("token: %s, expect a call after 'spawn'", keyword)
+		}
+		return newSpawnExpr(keyword, call), nil
+	}
+	if p.match(AWAIT) {
+		keyword := p.previous()
+		value, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return newAwaitExpr(keyword, value), nil
+	}
 	if p.match(BANG, MINUS) {
 		operator := p.previous()
 		right, err := p.unary()
@@ -517,6 +1205,7 @@ func (p *parser) call() (Expr, error) {
 	if err != nil {
 		return nil, err
 	}
+	var isOptionalChain bool
 	for {
 		if p.match(LEFT_PAREN) {
 			expr, err = p.finishCall(expr)
@@ -524,33 +1213,86 @@ func (p *parser) call() (Expr, error) {
 				return nil, err
 			}
 		} else if p.match(DOT) {
-			name, ok := p.consume(IDENTIFIER)
+			name, ok := p.consumePropertyName()
 			if !ok {
 				p.parseErr(name, "expect property name after '.'")
 				return nil, fmt.Errorf("expect property name after '.'")
 			}
 			expr = newGetExpr(expr, name)
+		} else if p.match(QUESTION_DOT) {
+			name, ok := p.consumePropertyName()
+			if !ok {
+				p.parseErr(name, "expect property name after '?.'")
+				return nil, fmt.Errorf("expect property name after '?.'")
+			}
+			expr = newOptionalGetExpr(expr, name)
+			isOptionalChain = true
+		} else if p.match(LEFT_BRACKET) {
+			bracket := p.previous()
+			index, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if token, ok := p.consume(RIGHT_BRACKET); !ok {
+				p.parseErr(token, "expect ']' after index")
+				return nil, fmt.Errorf("expect ']' after index")
+			}
+			expr = newIndexExpr(expr, bracket, index)
 		} else {
 			break
 		}
 	}
+	// 整条 chain 包一层，短路的时候从这里返回 nil。
+	if isOptionalChain {
+		expr = newOptionalChainExpr(expr)
+	}
 	return expr, nil
 }
 
+// consumePropertyName 消费 `.` 后面的属性名，private member 的名字以 `#` 开头。
+func (p *parser) consumePropertyName() (token, bool) {
+	if p.match(IDENTIFIER, PRIVATE_IDENTIFIER) {
+		return p.previous(), true
+	}
+	return token{}, false
+}
+
 func (p *parser) finishCall(callee Expr) (Expr, error) {
 	var args []Expr
+	var namedArgs []NamedArg
 	ok := p.check(RIGHT_PAREN)
 	// 这里实际上处理了空参数的 case
 	if !ok {
 		for {
-			if len(args) > maxArgsCount {
+			if len(args)+len(namedArgs) > maxArgsCount {
 				return nil, fmt.Errorf("token: %v cannot have more than %d args", p.peek(), maxArgsCount)
 			}
-			expr, err := p.expression()
-			if err != nil {
-				return nil, err
+			// `name: value` 是具名参数，具名参数只能出现在位置参数的后面。
+			if p.check(IDENTIFIER) && p.checkNext(COLON) {
+				name := p.advance()
+				p.advance()
+				for _, namedArg := range namedArgs {
+					if namedArg.name.Lexeme == name.Lexeme {
+						p.parseErr(name, "duplicate named argument")
+						return nil, fmt.Errorf("duplicate named argument %s", name)
+					}
+				}
+				value, err := p.expression()
+				if err != nil {
+					return nil, err
+				}
+				namedArgs = append(namedArgs, NamedArg{name: name, value: value})
+			} else {
+				if len(namedArgs) > 0 {
+					p.parseErr(p.peek(), "positional argument cannot follow named arguments")
+					return nil, fmt.Errorf("token: %v, positional argument cannot follow named arguments", p.peek())
+				}
+				expr, err := p.expression()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, expr)
 			}
-			args = append(args, expr)
 			if !p.match(COMMA) {
 				break
 			}
@@ -561,7 +1303,7 @@ func (p *parser) finishCall(callee Expr) (Expr, error) {
 		p.parseErr(paren, "expect ')' after arguments")
 		return nil, fmt.Errorf("expect ')' after arguments")
 	}
-	return newCallExpr(callee, paren, args), nil
+	return newCallExpr(callee, paren, args, namedArgs), nil
 }
 
 func (p *parser) primary() (Expr, error) {
@@ -603,11 +1345,65 @@ func (p *parser) primary() (Expr, error) {
 			return nil, fmt.Errorf("expect ')' after expression")
 		}
 		return newGroupingExpr(expr), nil
+	} else if p.match(LEFT_BRACKET) {
+		return p.listLiteral()
+	} else if p.match(LEFT_BRACE) {
+		return p.mapLiteral()
 	}
 	token := p.peek()
 	return nil, fmt.Errorf("token: %+v, expect expression", token)
 }
 
+func (p *parser) listLiteral() (Expr, error) {
+	bracket := p.previous()
+	var elements []Expr
+	for !p.check(RIGHT_BRACKET) && !p.isAtEnd() {
+		element, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		if !p.match(COMMA) {
+			break
+		}
+	}
+	if token, ok := p.consume(RIGHT_BRACKET); !ok {
+		p.parseErr(token, "expect ']' after list elements")
+		return nil, fmt.Errorf("expect ']' after list elements")
+	}
+	return newListExpr(bracket, elements), nil
+}
+
+// mapLiteral 只会在表达式的位置被调用，语句开头的 `{` 依旧是 block。
+func (p *parser) mapLiteral() (Expr, error) {
+	brace := p.previous()
+	var keys, values []Expr
+	for !p.check(RIGHT_BRACE) && !p.isAtEnd() {
+		key, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if token, ok := p.consume(COLON); !ok {
+			p.parseErr(token, "expect ':' after map key")
+			return nil, fmt.Errorf("expect ':' after map key")
+		}
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+		if !p.match(COMMA) {
+			break
+		}
+	}
+	if token, ok := p.consume(RIGHT_BRACE); !ok {
+		p.parseErr(token, "expect '}' after map entries")
+		return nil, fmt.Errorf("expect '}' after map entries")
+	}
+	return newMapExpr(brace, keys, values), nil
+}
+
 func (p *parser) consume(tokenType uint) (token, bool) {
 	if p.check(tokenType) {
 		token := p.advance()
@@ -637,6 +1433,33 @@ func (p *parser) check(tokenType uint) bool {
 	return p.peek().Type == tokenType
 }
 
+// matchModifier 匹配 class body 中类似 `static` 这样的修饰符。
+// 修饰符不是 keyword，只有后面紧跟 identifier 的时候才算，所以依旧可以用作普通的名字。
+func (p *parser) matchModifier(modifier string) bool {
+	if p.check(IDENTIFIER) && p.peek().Lexeme == modifier && p.checkNext(IDENTIFIER) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) checkNext(tokenType uint) bool {
+	return p.checkNextN(1, tokenType)
+}
+
+// checkNextN 检查当前 token 后面第 n 个 token 的类型。
+func (p *parser) checkNextN(n int, tokenType uint) bool {
+	if p.isAtEnd() || p.current+n >= len(p.tokens) {
+		return false
+	}
+	return p.tokens[p.current+n].Type == tokenType
+}
+
+// isContextual 判断当前 token 后面第 n 个 token 是不是名为 word 的 identifier，比如 for-in 中的 in。
+func (p *parser) isContextual(n int, word string) bool {
+	return p.checkNextN(n, IDENTIFIER) && p.tokens[p.current+n].Lexeme == word
+}
+
 func (p *parser) isAtEnd() bool {
 	return p.peek().Type == EOF
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pattern 是 match 语句里 case 后面的模式。
+// 模式不需要被求值，interpreter 和 resolver 直接按类型处理，所以没有走 visitor。
+type Pattern interface {
+	fmt.Stringer
+}
+
+// LiteralPattern 匹配跟字面量相等的值，比如 `case 1` 和 `case "x"`。
+type LiteralPattern struct {
+	value interface{}
+}
+
+func newLiteralPattern(value interface{}) *LiteralPattern {
+	return &LiteralPattern{
+		value: value,
+	}
+}
+
+func (p *LiteralPattern) String() string {
+	if p.value == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%v", p.value)
+}
+
+// WildcardPattern 是 `_`，匹配任意值并且不绑定名字。
+type WildcardPattern struct {
+	keyword token
+}
+
+func newWildcardPattern(keyword token) *WildcardPattern {
+	return &WildcardPattern{
+		keyword: keyword,
+	}
+}
+
+func (p *WildcardPattern) String() string {
+	return "_"
+}
+
+// BindingPattern 匹配任意值，并把值绑定到 name 上。
+type BindingPattern struct {
+	name token
+}
+
+func newBindingPattern(name token) *BindingPattern {
+	return &BindingPattern{
+		name: name,
+	}
+}
+
+func (p *BindingPattern) String() string {
+	return p.name.Lexeme
+}
+
+// ClassPattern 匹配 class（或者它的 subclass）的 instance，比如 `case Point(x, y: 0)`。
+// `x` 是 `x: x` 的简写，也就是把 field x 绑定到同名的变量上。
+type ClassPattern struct {
+	class  *VarExpr
+	fields []FieldPattern
+}
+
+type FieldPattern struct {
+	name    token
+	pattern Pattern
+}
+
+func newClassPattern(class *VarExpr, fields []FieldPattern) *ClassPattern {
+	return &ClassPattern{
+		class:  class,
+		fields: fields,
+	}
+}
+
+func (p *ClassPattern) String() string {
+	var fields []string
+	for _, field := range p.fields {
+		fields = append(fields, fmt.Sprintf("%s: %s", field.name.Lexeme, field.pattern))
+	}
+	return fmt.Sprintf("%s(%s)", p.class.name.Lexeme, strings.Join(fields, ", "))
+}
+
+// patternBindings 按出现顺序返回 pattern 中所有会被绑定的名字。
+func patternBindings(pattern Pattern) []token {
+	switch v := pattern.(type) {
+	case *BindingPattern:
+		return []token{v.name}
+	case *ClassPattern:
+		var names []token
+		for _, field := range v.fields {
+			names = append(names, patternBindings(field.pattern)...)
+		}
+		return names
+	default:
+		return nil
+	}
+}
@@ -44,29 +44,68 @@ func (p *PrettyPrinter) visitVarExpr(expr *VarExpr) string {
 }
 
 func (p *PrettyPrinter) visitAssignExpr(expr *AssignExpr) string {
-	return fmt.Sprint("%s = %v", expr.name.Lexeme, expr.expr)
+	return p.parenthesize("= "+expr.name.Lexeme, expr.expr)
 }
 
 func (p *PrettyPrinter) visitLogicalExpr(expr *LogicalExpr) string {
-	return fmt.Sprint("%v %s %v", expr.left, expr.operator.Lexeme, expr.right)
+	return p.parenthesize(expr.operator.Lexeme, expr.left, expr.right)
 }
 
 func (p *PrettyPrinter) visitCallExpr(expr *CallExpr) string {
-	return fmt.Sprint("%v %v %v", expr.callee, expr.paren, expr.args)
+	exprs := append([]Expr{expr.callee}, expr.args...)
+	for _, namedArg := range expr.namedArgs {
+		exprs = append(exprs, namedArg.value)
+	}
+	return p.parenthesize("call", exprs...)
 }
 
 func (p *PrettyPrinter) visitGetExpr(expr *GetExpr) string {
-	return fmt.Sprint("%v %v", expr.object, expr.name)
+	if expr.optional {
+		return p.parenthesize("?. "+expr.name.Lexeme, expr.object)
+	}
+	return p.parenthesize(". "+expr.name.Lexeme, expr.object)
+}
+
+func (p *PrettyPrinter) visitIndexExpr(expr *IndexExpr) string {
+	return p.parenthesize("[]", expr.object, expr.index)
+}
+
+func (p *PrettyPrinter) visitListExpr(expr *ListExpr) string {
+	return p.parenthesize("list", expr.elements...)
+}
+
+func (p *PrettyPrinter) visitMapExpr(expr *MapExpr) string {
+	var exprs []Expr
+	for idx, key := range expr.keys {
+		exprs = append(exprs, key, expr.values[idx])
+	}
+	return p.parenthesize("map", exprs...)
+}
+
+func (p *PrettyPrinter) visitSpawnExpr(expr *SpawnExpr) string {
+	return p.parenthesize("spawn", expr.call)
+}
+
+func (p *PrettyPrinter) visitAwaitExpr(expr *AwaitExpr) string {
+	return p.parenthesize("await", expr.value)
 }
 
 func (p *PrettyPrinter) visitSetExpr(expr *SetExpr) string {
-	return fmt.Sprint("%v %v %v", expr.object, expr.name, expr.value)
+	return p.parenthesize("= . "+expr.name.Lexeme, expr.object, expr.value)
 }
 
 func (p *PrettyPrinter) visitThisExpr(expr *ThisExpr) string {
-	return fmt.Sprint("%v", expr.keyword)
+	return expr.keyword.Lexeme
 }
 
 func (p *PrettyPrinter) visitSuperExpr(expr *SuperExpr) string {
-	return fmt.Sprint("%v %v", expr.keyword, expr.method)
+	return fmt.Sprintf("(super %s)", expr.method.Lexeme)
+}
+
+func (p *PrettyPrinter) visitConditionalExpr(expr *ConditionalExpr) string {
+	return p.parenthesize("?:", expr.condition, expr.thenBranch, expr.elseBranch)
+}
+
+func (p *PrettyPrinter) visitOptionalChainExpr(expr *OptionalChainExpr) string {
+	return p.parenthesize("optional-chain", expr.expr)
 }
@@ -9,6 +9,7 @@ const (
 	FuntionTypeFunction
 	FunctionTypeInitializer
 	FuntionTypeMethod
+	FunctionTypeStaticMethod
 )
 
 type ClassType int
@@ -17,6 +18,7 @@ const (
 	ClassTypeNone = iota
 	ClassTypeClass
 	ClassTypeSubClass
+	ClassTypeTrait
 )
 
 // 主要是为了做 semantic analysis
@@ -25,6 +27,18 @@ type resolver struct {
 	scopes              *stack
 	currentFunctionType FunctionType
 	currentClassType    ClassType
+	// 是否处于 static method 或 static field 的初始化中，这里的 this 是 class 而不是 instance。
+	inStaticContext bool
+	// 当前 class body 中声明的 private member，不在 class body 中的时候为 nil。
+	currentPrivateNames map[string]bool
+	// 已经声明的 trait 和它们提供的 method，用来在 resolve 阶段发现 trait 之间的冲突。
+	traitMethods map[string][]string
+	// 当前函数中嵌套的循环层数，break 和 continue 只能出现在循环中。
+	loopDepth int
+	// 当前函数是不是 generator，yield 只能出现在 generator 中。
+	inGenerator bool
+	// 当前函数是不是 async function，await 只能出现在 async function 或者顶层代码中。
+	inAsync bool
 }
 
 func newResolver(intp Interpreter) *resolver {
@@ -33,6 +47,7 @@ func newResolver(intp Interpreter) *resolver {
 		scopes:              newStack(),
 		currentFunctionType: FunctionTypeNone,
 		currentClassType:    ClassTypeNone,
+		traitMethods:        make(map[string][]string),
 	}
 }
 
@@ -185,6 +200,23 @@ func (r *resolver) visitLogicalExpr(expr *LogicalExpr) (interface{}, error) {
 	return nil, nil
 }
 
+func (r *resolver) visitConditionalExpr(expr *ConditionalExpr) (interface{}, error) {
+	if err := r.resolveExpr(expr.condition); err != nil {
+		return nil, err
+	}
+	if err := r.resolveExpr(expr.thenBranch); err != nil {
+		return nil, err
+	}
+	if err := r.resolveExpr(expr.elseBranch); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (r *resolver) visitOptionalChainExpr(expr *OptionalChainExpr) (interface{}, error) {
+	return nil, r.resolveExpr(expr.expr)
+}
+
 func (r *resolver) visitThisExpr(expr *ThisExpr) (interface{}, error) {
 	if r.currentClassType == ClassTypeNone {
 		return nil, fmt.Errorf("cannot use 'this' outside of a class")
@@ -201,25 +233,94 @@ func (r *resolver) visitCallExpr(expr *CallExpr) (interface{}, error) {
 			return nil, err
 		}
 	}
+	for _, namedArg := range expr.namedArgs {
+		if err := r.resolveExpr(namedArg.value); err != nil {
+			return nil, err
+		}
+	}
 	return nil, nil
 }
 
 func (r *resolver) visitGetExpr(expr *GetExpr) (interface{}, error) {
+	if expr.name.Type == PRIVATE_IDENTIFIER {
+		if err := r.resolvePrivateName(expr, expr.name); err != nil {
+			return nil, err
+		}
+	}
 	return nil, r.resolveExpr(expr.object)
 }
 
+func (r *resolver) visitIndexExpr(expr *IndexExpr) (interface{}, error) {
+	if err := r.resolveExpr(expr.object); err != nil {
+		return nil, err
+	}
+	return nil, r.resolveExpr(expr.index)
+}
+
+func (r *resolver) visitListExpr(expr *ListExpr) (interface{}, error) {
+	for _, element := range expr.elements {
+		if err := r.resolveExpr(element); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (r *resolver) visitMapExpr(expr *MapExpr) (interface{}, error) {
+	for idx, key := range expr.keys {
+		if err := r.resolveExpr(key); err != nil {
+			return nil, err
+		}
+		if err := r.resolveExpr(expr.values[idx]); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (r *resolver) visitSpawnExpr(expr *SpawnExpr) (interface{}, error) {
+	return nil, r.resolveExpr(expr.call)
+}
+
+func (r *resolver) visitAwaitExpr(expr *AwaitExpr) (interface{}, error) {
+	if r.currentFunctionType != FunctionTypeNone && !r.inAsync {
+		return nil, fmt.Errorf("keyword: %s, cannot await outside of an async function", expr.keyword)
+	}
+	return nil, r.resolveExpr(expr.value)
+}
+
 func (r *resolver) visitSetExpr(expr *SetExpr) (interface{}, error) {
+	if expr.name.Type == PRIVATE_IDENTIFIER {
+		if err := r.resolvePrivateName(expr, expr.name); err != nil {
+			return nil, err
+		}
+	}
 	if err := r.resolveExpr(expr.value); err != nil {
 		return nil, err
 	}
 	return nil, r.resolveExpr(expr.object)
 }
 
+// resolvePrivateName 检查 private member 只在声明它的 class body 中被访问，
+// 并且把 "#class" 的距离记录在 expr 上，interpreter 通过它找到声明 private member 的 class。
+func (r *resolver) resolvePrivateName(expr Expr, name token) error {
+	if r.currentPrivateNames == nil {
+		return fmt.Errorf("token: %s, private member can only be accessed inside a class body", name)
+	}
+	if !r.currentPrivateNames[name.Lexeme] {
+		return fmt.Errorf("token: %s, private member %s is not declared in the enclosing class", name, name.Lexeme)
+	}
+	return r.resolveLocal(expr, newToken(IDENTIFIER, "#class", nil, name.line))
+}
+
 func (r *resolver) visitSuperExpr(expr *SuperExpr) (interface{}, error) {
 	if r.currentClassType == ClassTypeNone {
 		return nil, fmt.Errorf("cannot use 'super' outside a class")
-	} else if r.currentClassType != ClassTypeSubClass {
+	} else if r.currentClassType != ClassTypeSubClass && r.currentClassType != ClassTypeTrait {
+		// trait 中的 super 指向混入 trait 的 class 的 superclass，只能在运行时检查。
 		return nil, fmt.Errorf("cannot use 'super' in a class with no super class")
+	} else if r.inStaticContext {
+		return nil, fmt.Errorf("keyword: %s, cannot use 'super' in a static method", expr.keyword)
 	}
 	return nil, r.resolveLocal(expr, expr.keyword)
 }
@@ -279,18 +380,142 @@ func (r *resolver) visitWhileStmt(stmt WhileStmt) error {
 	if err := r.resolveExpr(stmt.condition); err != nil {
 		return err
 	}
+	r.loopDepth++
+	err := r.resolveStmt(stmt.body)
+	r.loopDepth--
+	if err != nil {
+		return err
+	}
+	if stmt.increment != nil {
+		return r.resolveExpr(stmt.increment)
+	}
+	return nil
+}
+
+func (r *resolver) visitForInStmt(stmt ForInStmt) error {
+	if err := r.resolveExpr(stmt.iterable); err != nil {
+		return err
+	}
+	if err := r.beginScope(); err != nil {
+		return err
+	}
+	if err := r.declare(stmt.name); err != nil {
+		return err
+	}
+	if err := r.define(stmt.name); err != nil {
+		return err
+	}
+	r.loopDepth++
+	err := r.resolveStmt(stmt.body)
+	r.loopDepth--
+	if err != nil {
+		return err
+	}
+	return r.endScope()
+}
+
+// catch 的错误名字只在 handler 中可见。
+func (r *resolver) visitTryStmt(stmt TryStmt) error {
 	if err := r.resolveStmt(stmt.body); err != nil {
 		return err
 	}
+	if err := r.beginScope(); err != nil {
+		return err
+	}
+	if err := r.declare(stmt.name); err != nil {
+		return err
+	}
+	if err := r.define(stmt.name); err != nil {
+		return err
+	}
+	if err := r.resolveStmt(stmt.handler); err != nil {
+		return err
+	}
+	return r.endScope()
+}
+
+// select 中每个 case 都有自己的 scope，接收到的值绑定的名字只在 body 中可见。
+func (r *resolver) visitSelectStmt(stmt SelectStmt) error {
+	for _, selectCase := range stmt.cases {
+		if selectCase.channel != nil {
+			if err := r.resolveExpr(selectCase.channel); err != nil {
+				return err
+			}
+		}
+		if selectCase.value != nil {
+			if err := r.resolveExpr(selectCase.value); err != nil {
+				return err
+			}
+		}
+		if err := r.beginScope(); err != nil {
+			return err
+		}
+		if selectCase.name != nil {
+			if err := r.declare(*selectCase.name); err != nil {
+				return err
+			}
+			if err := r.define(*selectCase.name); err != nil {
+				return err
+			}
+		}
+		if err := r.resolveStmt(selectCase.body); err != nil {
+			return err
+		}
+		if err := r.endScope(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) visitYieldStmt(stmt YieldStmt) error {
+	if !r.inGenerator {
+		return fmt.Errorf("keyword: %s, cannot yield outside of a generator", stmt.keyword)
+	}
+	if stmt.value != nil {
+		return r.resolveExpr(stmt.value)
+	}
+	return nil
+}
+
+func (r *resolver) visitBreakStmt(stmt BreakStmt) error {
+	if r.loopDepth == 0 {
+		return fmt.Errorf("keyword: %s, cannot break outside of a loop", stmt.keyword)
+	}
+	return nil
+}
+
+func (r *resolver) visitContinueStmt(stmt ContinueStmt) error {
+	if r.loopDepth == 0 {
+		return fmt.Errorf("keyword: %s, cannot continue outside of a loop", stmt.keyword)
+	}
 	return nil
 }
 
 func (r *resolver) visitClassStmt(stmt ClassStmt) error {
 	enclosingClass := r.currentClassType
+	enclosingStaticContext := r.inStaticContext
+	enclosingPrivateNames := r.currentPrivateNames
 	r.currentClassType = ClassTypeClass
+	r.inStaticContext = false
+	r.currentPrivateNames = make(map[string]bool)
 	defer func() {
 		r.currentClassType = enclosingClass
+		r.inStaticContext = enclosingStaticContext
+		r.currentPrivateNames = enclosingPrivateNames
 	}()
+	for _, function := range stmt.privateMethods {
+		if r.currentPrivateNames[function.name.Lexeme] {
+			return fmt.Errorf("token: %s, private member %s is already declared", function.name, function.name.Lexeme)
+		}
+		r.currentPrivateNames[function.name.Lexeme] = true
+	}
+	for _, field := range stmt.privateFields {
+		if r.currentPrivateNames[field.name.Lexeme] {
+			return fmt.Errorf("token: %s, private member %s is already declared", field.name, field.name.Lexeme)
+		}
+		r.currentPrivateNames[field.name.Lexeme] = true
+	}
 	if err := r.declare(stmt.name); err != nil {
 		return err
 	}
@@ -307,6 +532,12 @@ func (r *resolver) visitClassStmt(stmt ClassStmt) error {
 			return err
 		}
 	}
+	if err := r.resolveTraits(stmt); err != nil {
+		return err
+	}
+	if err := r.resolveInterfaces(stmt); err != nil {
+		return err
+	}
 
 	// 处理 super 调用需要的 context
 	if stmt.superclass != nil {
@@ -316,6 +547,11 @@ func (r *resolver) visitClassStmt(stmt ClassStmt) error {
 		r.put("super", true)
 	}
 
+	if err := r.beginScope(); err != nil {
+		return err
+	}
+	r.put("#class", true)
+
 	if err := r.beginScope(); err != nil {
 		return err
 	}
@@ -330,6 +566,54 @@ func (r *resolver) visitClassStmt(stmt ClassStmt) error {
 			return err
 		}
 	}
+	for _, function := range stmt.abstractMethods {
+		if err := r.resolveFunction(function, FuntionTypeMethod); err != nil {
+			return err
+		}
+	}
+	for _, function := range stmt.privateMethods {
+		if err := r.resolveFunction(function, FuntionTypeMethod); err != nil {
+			return err
+		}
+	}
+	for _, field := range stmt.privateFields {
+		if field.expr != nil {
+			if err := r.resolveExpr(field.expr); err != nil {
+				return err
+			}
+		}
+	}
+	for _, accessors := range [][]FunctionStmt{stmt.getters, stmt.setters} {
+		for _, function := range accessors {
+			if function.name.Lexeme == "init" {
+				return fmt.Errorf("token: %s, initializer cannot be a getter or setter", function.name)
+			}
+			if err := r.resolveFunction(function, FuntionTypeMethod); err != nil {
+				return err
+			}
+		}
+	}
+	r.inStaticContext = true
+	for _, function := range stmt.staticMethods {
+		if function.name.Lexeme == "init" {
+			return fmt.Errorf("token: %s, initializer cannot be static", function.name)
+		}
+		if err := r.resolveFunction(function, FunctionTypeStaticMethod); err != nil {
+			return err
+		}
+	}
+	for _, field := range stmt.staticFields {
+		if field.expr != nil {
+			if err := r.resolveExpr(field.expr); err != nil {
+				return err
+			}
+		}
+	}
+	r.inStaticContext = false
+	if err := r.endScope(); err != nil {
+		return err
+	}
+	// 闭合 "#class" 的 scope
 	if err := r.endScope(); err != nil {
 		return err
 	}
@@ -342,6 +626,206 @@ func (r *resolver) visitClassStmt(stmt ClassStmt) error {
 	return nil
 }
 
+// 每个 case 都有自己的 scope，pattern 绑定的名字只在 guard 和 body 中可见。
+func (r *resolver) visitMatchStmt(stmt MatchStmt) error {
+	if err := r.resolveExpr(stmt.subject); err != nil {
+		return err
+	}
+	for _, matchCase := range stmt.cases {
+		if len(matchCase.patterns) > 1 {
+			for _, pattern := range matchCase.patterns {
+				if len(patternBindings(pattern)) > 0 {
+					return fmt.Errorf("keyword: %s, cannot bind names in a case with alternative patterns", stmt.keyword)
+				}
+			}
+		}
+		if err := r.beginScope(); err != nil {
+			return err
+		}
+		for _, pattern := range matchCase.patterns {
+			if err := r.resolvePattern(pattern); err != nil {
+				return err
+			}
+		}
+		if matchCase.guard != nil {
+			if err := r.resolveExpr(matchCase.guard); err != nil {
+				return err
+			}
+		}
+		if err := r.resolveStmt(matchCase.body); err != nil {
+			return err
+		}
+		if err := r.endScope(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) resolvePattern(pattern Pattern) error {
+	switch v := pattern.(type) {
+	case *BindingPattern:
+		if err := r.declare(v.name); err != nil {
+			return err
+		}
+		return r.define(v.name)
+	case *ClassPattern:
+		if err := r.resolveExpr(v.class); err != nil {
+			return err
+		}
+		for _, field := range v.fields {
+			if err := r.resolvePattern(field.pattern); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveTraits 检查 class 混入的 trait，两个 trait 提供同名的 method 并且 class 自己没有 override 的时候报错。
+// 在别的地方声明（比如通过参数传进来）的 trait 只能在运行时检查。
+func (r *resolver) resolveTraits(stmt ClassStmt) error {
+	declared := make(map[string]bool)
+	for _, methods := range [][]FunctionStmt{stmt.methods, stmt.getters} {
+		for _, method := range methods {
+			declared[method.name.Lexeme] = true
+		}
+	}
+	providedBy := make(map[string]string)
+	used := make(map[string]bool)
+	for _, trait := range stmt.traits {
+		if trait.name.Lexeme == stmt.name.Lexeme {
+			return fmt.Errorf("token: %s, a class cannot use itself as a trait", trait.name)
+		}
+		if used[trait.name.Lexeme] {
+			return fmt.Errorf("token: %s, trait %s is used more than once", trait.name, trait.name.Lexeme)
+		}
+		used[trait.name.Lexeme] = true
+		if err := r.resolveExpr(trait); err != nil {
+			return err
+		}
+		methods, ok := r.traitMethods[trait.name.Lexeme]
+		if !ok {
+			continue
+		}
+		for _, name := range methods {
+			if declared[name] {
+				continue
+			}
+			if other, ok := providedBy[name]; ok {
+				return fmt.Errorf("token: %s, method %s is provided by both trait %s and trait %s, override it in class %s", stmt.name, name, other, trait.name.Lexeme, stmt.name.Lexeme)
+			}
+			providedBy[name] = trait.name.Lexeme
+		}
+	}
+	return nil
+}
+
+// resolveInterfaces 检查 abstract method 的声明和 class 实现的 interface，
+// 是否满足 interface 要等到运行时 class 的 method 都确定之后才能检查。
+func (r *resolver) resolveInterfaces(stmt ClassStmt) error {
+	declared := make(map[string]bool)
+	for _, methods := range [][]FunctionStmt{stmt.methods, stmt.getters} {
+		for _, method := range methods {
+			declared[method.name.Lexeme] = true
+		}
+	}
+	for _, method := range stmt.abstractMethods {
+		if method.name.Lexeme == "init" {
+			return fmt.Errorf("token: %s, initializer cannot be abstract", method.name)
+		}
+		if declared[method.name.Lexeme] {
+			return fmt.Errorf("token: %s, method %s is declared both abstract and concrete", method.name, method.name.Lexeme)
+		}
+		declared[method.name.Lexeme] = true
+	}
+	used := make(map[string]bool)
+	for _, iface := range stmt.interfaces {
+		if iface.name.Lexeme == stmt.name.Lexeme {
+			return fmt.Errorf("token: %s, a class cannot implement itself", iface.name)
+		}
+		if used[iface.name.Lexeme] {
+			return fmt.Errorf("token: %s, interface %s is implemented more than once", iface.name, iface.name.Lexeme)
+		}
+		used[iface.name.Lexeme] = true
+		if err := r.resolveExpr(iface); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) visitInterfaceStmt(stmt InterfaceStmt) error {
+	if err := r.declare(stmt.name); err != nil {
+		return err
+	}
+	if err := r.define(stmt.name); err != nil {
+		return err
+	}
+	declared := make(map[string]bool)
+	for _, method := range stmt.methods {
+		if method.name.Lexeme == "init" {
+			return fmt.Errorf("token: %s, interface cannot declare an initializer", method.name)
+		}
+		if declared[method.name.Lexeme] {
+			return fmt.Errorf("token: %s, method %s is already declared in interface %s", method.name, method.name.Lexeme, stmt.name.Lexeme)
+		}
+		declared[method.name.Lexeme] = true
+		// interface method 没有 body，只需要处理参数的默认值。
+		if err := r.resolveFunction(method, FuntionTypeMethod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trait 中 method 的 scope 跟 class 中的 method 保持一致：super、"#class"、this。
+func (r *resolver) visitTraitStmt(stmt TraitStmt) error {
+	if err := r.declare(stmt.name); err != nil {
+		return err
+	}
+	if err := r.define(stmt.name); err != nil {
+		return err
+	}
+	var names []string
+	for _, method := range stmt.methods {
+		if method.name.Lexeme == "init" {
+			return fmt.Errorf("token: %s, trait cannot declare an initializer", method.name)
+		}
+		names = append(names, method.name.Lexeme)
+	}
+	r.traitMethods[stmt.name.Lexeme] = names
+
+	enclosingClass := r.currentClassType
+	enclosingStaticContext := r.inStaticContext
+	enclosingPrivateNames := r.currentPrivateNames
+	r.currentClassType = ClassTypeTrait
+	r.inStaticContext = false
+	r.currentPrivateNames = nil
+	defer func() {
+		r.currentClassType = enclosingClass
+		r.inStaticContext = enclosingStaticContext
+		r.currentPrivateNames = enclosingPrivateNames
+	}()
+	for _, name := range []string{"super", "#class", "this"} {
+		if err := r.beginScope(); err != nil {
+			return err
+		}
+		r.put(name, true)
+	}
+	for _, method := range stmt.methods {
+		if err := r.resolveFunction(method, FuntionTypeMethod); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := r.endScope(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *resolver) visitFunctionStmt(stmt FunctionStmt) error {
 	if err := r.declare(stmt.name); err != nil {
 		return err
@@ -360,24 +844,48 @@ func (r *resolver) visitReturnStmt(stmt ReturnStmt) error {
 		return fmt.Errorf("cannot return from top-level code")
 	}
 	if stmt.value != nil {
-		if r.currentClassType == FunctionTypeInitializer {
+		if r.currentFunctionType == FunctionTypeInitializer {
 			return fmt.Errorf("keyword: %s cannot return from a initializar", stmt.keyword)
 		}
+		if r.inGenerator {
+			return fmt.Errorf("keyword: %s, cannot return a value from a generator", stmt.keyword)
+		}
 		return r.resolveExpr(stmt.value)
 	}
 	return nil
 }
 
 func (r *resolver) resolveFunction(stmt FunctionStmt, functionType FunctionType) error {
+	if stmt.isGenerator && functionType == FunctionTypeInitializer {
+		return fmt.Errorf("token: %s, initializer cannot be a generator", stmt.name)
+	}
+	if stmt.isAsync && functionType == FunctionTypeInitializer {
+		return fmt.Errorf("token: %s, initializer cannot be async", stmt.name)
+	}
 	enclosingFunction := r.currentFunctionType
+	enclosingLoopDepth := r.loopDepth
+	enclosingGenerator := r.inGenerator
+	enclosingAsync := r.inAsync
 	r.currentFunctionType = functionType
+	r.loopDepth = 0
+	r.inGenerator = stmt.isGenerator
+	r.inAsync = stmt.isAsync
 	defer func() {
 		r.currentFunctionType = enclosingFunction
+		r.loopDepth = enclosingLoopDepth
+		r.inGenerator = enclosingGenerator
+		r.inAsync = enclosingAsync
 	}()
 	if err := r.beginScope(); err != nil {
 		return err
 	}
-	for _, param := range stmt.params {
+	for idx, param := range stmt.params {
+		// 默认值只能看到它前面的参数
+		if stmt.defaults[idx] != nil {
+			if err := r.resolveExpr(stmt.defaults[idx]); err != nil {
+				return err
+			}
+		}
 		if err := r.declare(param); err != nil {
 			return err
 		}
@@ -385,6 +893,14 @@ func (r *resolver) resolveFunction(stmt FunctionStmt, functionType FunctionType)
 			return err
 		}
 	}
+	if stmt.rest != nil {
+		if err := r.declare(*stmt.rest); err != nil {
+			return err
+		}
+		if err := r.define(*stmt.rest); err != nil {
+			return err
+		}
+	}
 	if err := r.resolveStmts(stmt.stmts); err != nil {
 		return err
 	}
@@ -52,7 +52,35 @@ const (
 	VAR    // 36
 	WHILE  // 37
 
-	EOF // 38
+	// Operators beyond the book's Lox.
+	QUESTION          // 38
+	QUESTION_DOT      // 39
+	QUESTION_QUESTION // 40
+	COLON             // 41
+	FAT_ARROW         // 42
+	ELLIPSIS          // 43
+	LEFT_BRACKET      // 44
+	RIGHT_BRACKET     // 45
+
+	// Literals beyond the book's Lox.
+	PRIVATE_IDENTIFIER // 46
+
+	// Keywords beyond the book's Lox.
+	MATCH     // 47
+	CASE      // 48
+	TRAIT     // 49
+	INTERFACE // 50
+	BREAK     // 51
+	CONTINUE  // 52
+	YIELD     // 53
+	SPAWN     // 54
+	SELECT    // 55
+	ASYNC     // 56
+	AWAIT     // 57
+	TRY       // 58
+	CATCH     // 59
+
+	EOF // 60
 )
 
 func typeToString(a uint) string {
@@ -73,6 +101,20 @@ func typeToString(a uint) string {
 		TRUE:   "true",
 		VAR:    "var",
 		WHILE:  "while",
+		MATCH:  "match",
+		CASE:   "case",
+		TRAIT:  "trait",
+
+		INTERFACE: "interface",
+		BREAK:     "break",
+		CONTINUE:  "continue",
+		YIELD:     "yield",
+		SPAWN:     "spawn",
+		SELECT:    "select",
+		ASYNC:     "async",
+		AWAIT:     "await",
+		TRY:       "try",
+		CATCH:     "catch",
 	}
 	if v, ok := keywordMap[a]; ok {
 		return fmt.Sprintf("[KEYWORD] %s", v)
@@ -96,9 +138,10 @@ func typeToString(a uint) string {
 	}
 
 	identifierMap := map[uint]string{
-		IDENTIFIER: "IDENTIFIER",
-		STRING:     "STRING",
-		NUMBER:     "NUMBER",
+		IDENTIFIER:         "IDENTIFIER",
+		STRING:             "STRING",
+		NUMBER:             "NUMBER",
+		PRIVATE_IDENTIFIER: "PRIVATE IDENTIFIER",
 	}
 	if v, ok := identifierMap[a]; ok {
 		return fmt.Sprintf("[%s]", v)
@@ -117,6 +160,20 @@ func typeToString(a uint) string {
 	if v, ok := oneOrTwoCharMap[a]; ok {
 		return fmt.Sprintf("[ONE OR TWO CHAR] %s", v)
 	}
+
+	operatorMap := map[uint]string{
+		QUESTION:          "?",
+		QUESTION_DOT:      "?.",
+		QUESTION_QUESTION: "??",
+		COLON:             ":",
+		FAT_ARROW:         "=>",
+		ELLIPSIS:          "...",
+		LEFT_BRACKET:      "[",
+		RIGHT_BRACKET:     "]",
+	}
+	if v, ok := operatorMap[a]; ok {
+		return fmt.Sprintf("[OPERATOR] %s", v)
+	}
 	return "[EOF]"
 }
 
@@ -217,10 +274,20 @@ func (s *scanner) scanToken() {
 		s.addToken(LEFT_BRACE, nil)
 	case '}':
 		s.addToken(RIGHT_BRACE, nil)
+	case '[':
+		s.addToken(LEFT_BRACKET, nil)
+	case ']':
+		s.addToken(RIGHT_BRACKET, nil)
 	case ',':
 		s.addToken(COMMA, nil)
 	case '.':
-		s.addToken(DOT, nil)
+		if s.peek() == '.' && s.peekNext() == '.' {
+			s.advance()
+			s.advance()
+			s.addToken(ELLIPSIS, nil)
+		} else {
+			s.addToken(DOT, nil)
+		}
 	case '+':
 		s.addToken(PLUS, nil)
 	case '-':
@@ -229,6 +296,18 @@ func (s *scanner) scanToken() {
 		s.addToken(STAR, nil)
 	case ';':
 		s.addToken(SEMICOLON, nil)
+	case ':':
+		s.addToken(COLON, nil)
+	case '?':
+		// `a?.5:1` 里的 `?.` 不是 optional chaining，所以要看一下后面是不是数字。
+		if s.peek() == '.' && !isDigital(s.peekNext()) {
+			s.advance()
+			s.addToken(QUESTION_DOT, nil)
+		} else if s.match('?') {
+			s.addToken(QUESTION_QUESTION, nil)
+		} else {
+			s.addToken(QUESTION, nil)
+		}
 	case '/':
 		if s.match('/') {
 			// 一行注释
@@ -250,6 +329,8 @@ func (s *scanner) scanToken() {
 	case '=':
 		if s.match('=') {
 			s.addToken(EQUAL_EQUAL, nil)
+		} else if s.match('>') {
+			s.addToken(FAT_ARROW, nil)
 		} else {
 			s.addToken(EQUAL, nil)
 		}
@@ -270,6 +351,16 @@ func (s *scanner) scanToken() {
 		s.line += 1
 	case '"':
 		s.string()
+	case '#':
+		// `#name` 是 class 的 private member
+		if isAlpha(s.peek()) {
+			for isAlphaNumeric(s.peek()) {
+				s.advance()
+			}
+			s.addToken(PRIVATE_IDENTIFIER, nil)
+		} else {
+			printError(s.line, "expect private member name after '#'")
+		}
 	default:
 		if isDigital(c) {
 			s.number()
@@ -299,6 +390,20 @@ func isKeyword(text string) (uint, bool) {
 		"true":   TRUE,
 		"var":    VAR,
 		"while":  WHILE,
+		"match":  MATCH,
+		"case":   CASE,
+		"trait":  TRAIT,
+
+		"interface": INTERFACE,
+		"break":     BREAK,
+		"continue":  CONTINUE,
+		"yield":     YIELD,
+		"spawn":     SPAWN,
+		"select":    SELECT,
+		"async":     ASYNC,
+		"await":     AWAIT,
+		"try":       TRY,
+		"catch":     CATCH,
 	}
 	v, ok := keywordMap[text]
 	return v, ok
@@ -328,7 +433,8 @@ func (s *scanner) number() {
 	}
 	float64Value, err := parseFloat(s.source[s.start:s.current])
 	if err != nil {
-		customPanic(err)
+		printError(s.line, "invalid number "+s.source[s.start:s.current])
+		return
 	}
 	s.addToken(NUMBER, float64Value)
 }
@@ -365,9 +471,3 @@ func parseFloat(s string) (float64, error) {
 func isAlpha(c uint8) bool {
 	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
 }
-
-func customPanic(e interface{}) {
-	var a any
-	a = e
-	panic(a)
-}
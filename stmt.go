@@ -12,6 +12,15 @@ type StmtVisitor interface {
 	visitFunctionStmt(FunctionStmt) error
 	visitReturnStmt(ReturnStmt) error
 	visitClassStmt(ClassStmt) error
+	visitMatchStmt(MatchStmt) error
+	visitTraitStmt(TraitStmt) error
+	visitInterfaceStmt(InterfaceStmt) error
+	visitForInStmt(ForInStmt) error
+	visitBreakStmt(BreakStmt) error
+	visitContinueStmt(ContinueStmt) error
+	visitYieldStmt(YieldStmt) error
+	visitSelectStmt(SelectStmt) error
+	visitTryStmt(TryStmt) error
 }
 
 type Stmt interface {
@@ -20,12 +29,18 @@ type Stmt interface {
 
 type PrintStmt struct {
 	expr Expr
+	// bare 表示这是没有写 print 的 expression statement，它的值是 nil 的时候不输出，跟以前的输出保持一致。
+	bare bool
 }
 
 func newPrintStmt(expr Expr) Stmt {
 	return PrintStmt{expr: expr}
 }
 
+func newBarePrintStmt(expr Expr) Stmt {
+	return PrintStmt{expr: expr, bare: true}
+}
+
 func (stmt PrintStmt) acceptStmtVisitor(visitor StmtVisitor) error {
 	return visitor.visitPrintStmt(stmt)
 }
@@ -118,6 +133,8 @@ func (stmt IFStmt) String() string {
 type WhileStmt struct {
 	condition Expr
 	body      Stmt
+	// for 循环的 increment 单独存放，这样 continue 之后依旧会执行它。
+	increment Expr
 }
 
 func newWhileStmt(condition Expr, body Stmt) Stmt {
@@ -135,17 +152,101 @@ func (stmt WhileStmt) String() string {
 	return fmt.Sprintf("while stmt, condition:(%s), body:{%s}", stmt.condition, stmt.body)
 }
 
+// ForInStmt 是 `for (name in iterable) body`，每次循环 name 都是一个新的变量。
+type ForInStmt struct {
+	name     token
+	iterable Expr
+	body     Stmt
+}
+
+func newForInStmt(name token, iterable Expr, body Stmt) Stmt {
+	return ForInStmt{
+		name:     name,
+		iterable: iterable,
+		body:     body,
+	}
+}
+
+func (stmt ForInStmt) acceptStmtVisitor(visitor StmtVisitor) error {
+	return visitor.visitForInStmt(stmt)
+}
+
+func (stmt ForInStmt) String() string {
+	return fmt.Sprintf("for in stmt, name: %s, iterable: %s, body:{%s}", stmt.name, stmt.iterable, stmt.body)
+}
+
+type BreakStmt struct {
+	keyword token
+}
+
+func newBreakStmt(keyword token) Stmt {
+	return BreakStmt{keyword: keyword}
+}
+
+func (stmt BreakStmt) acceptStmtVisitor(visitor StmtVisitor) error {
+	return visitor.visitBreakStmt(stmt)
+}
+
+func (stmt BreakStmt) String() string {
+	return "break stmt"
+}
+
+type ContinueStmt struct {
+	keyword token
+}
+
+func newContinueStmt(keyword token) Stmt {
+	return ContinueStmt{keyword: keyword}
+}
+
+func (stmt ContinueStmt) acceptStmtVisitor(visitor StmtVisitor) error {
+	return visitor.visitContinueStmt(stmt)
+}
+
+func (stmt ContinueStmt) String() string {
+	return "continue stmt"
+}
+
+// YieldStmt 只能出现在 generator 中，暂停 generator 并把 value 交给调用 next() 的地方。
+type YieldStmt struct {
+	keyword token
+	value   Expr
+}
+
+func newYieldStmt(keyword token, value Expr) Stmt {
+	return YieldStmt{
+		keyword: keyword,
+		value:   value,
+	}
+}
+
+func (stmt YieldStmt) acceptStmtVisitor(visitor StmtVisitor) error {
+	return visitor.visitYieldStmt(stmt)
+}
+
+func (stmt YieldStmt) String() string {
+	return fmt.Sprintf("yield stmt, value: %s", stmt.value)
+}
+
 type FunctionStmt struct {
-	name   token
-	params []token
-	stmts  []Stmt // body
+	name     token
+	params   []token
+	defaults []Expr // 跟 params 一一对应，没有默认值的参数为 nil
+	rest     *token // `...rest`，没有的时候为 nil
+	stmts    []Stmt // body
+
+	isAbstract  bool // abstract method 和 interface 中的 method 没有 body
+	isGenerator bool // `fun*`，调用的时候返回 generator 而不是执行 body
+	isAsync     bool // `async fun`，调用的时候返回 promise，body 由 event loop 驱动
 }
 
-func newFunctionStmt(name token, params []token, body []Stmt) Stmt {
+func newFunctionStmt(name token, params []token, defaults []Expr, rest *token, body []Stmt) Stmt {
 	return FunctionStmt{
-		name:   name,
-		params: params,
-		stmts:  body,
+		name:     name,
+		params:   params,
+		defaults: defaults,
+		rest:     rest,
+		stmts:    body,
 	}
 }
 
@@ -153,6 +254,19 @@ func (stmt FunctionStmt) acceptStmtVisitor(visitor StmtVisitor) error {
 	return visitor.visitFunctionStmt(stmt)
 }
 
+func (stmt FunctionStmt) arity() Arity {
+	arity := fixedArity(len(stmt.params))
+	for _, defaultValue := range stmt.defaults {
+		if defaultValue != nil {
+			arity.Min--
+		}
+	}
+	if stmt.rest != nil {
+		arity.Max = variadic
+	}
+	return arity
+}
+
 func (stmt FunctionStmt) String() string {
 	return fmt.Sprintf("funtion stmt, name: %s, params: %s, body: %s", stmt.name, stmt.params, stmt.stmts)
 }
@@ -178,12 +292,23 @@ func (stmt ReturnStmt) String() string {
 }
 
 type ClassStmt struct {
-	name       token
-	methods    []FunctionStmt
-	superclass *VarExpr
-}
-
-func newClassStmt(name token, superclass *VarExpr, methods []FunctionStmt) Stmt {
+	name          token
+	methods       []FunctionStmt
+	superclass    *VarExpr
+	traits        []*VarExpr // `with T1, T2`
+	staticMethods []FunctionStmt
+	staticFields  []VarStmt
+	getters       []FunctionStmt
+	setters       []FunctionStmt
+	// private member 只能在 class body 中访问，跟 subclass 中同名的 private member 互不影响。
+	privateMethods []FunctionStmt
+	privateFields  []VarStmt
+	// abstract method 没有 body，必须由 subclass 实现之后 class 才能被实例化。
+	abstractMethods []FunctionStmt
+	interfaces      []*VarExpr // `implements I1, I2`
+}
+
+func newClassStmt(name token, superclass *VarExpr, methods []FunctionStmt) ClassStmt {
 	return ClassStmt{
 		name:       name,
 		methods:    methods,
@@ -196,5 +321,136 @@ func (stmt ClassStmt) acceptStmtVisitor(visitor StmtVisitor) error {
 }
 
 func (stmt ClassStmt) String() string {
-	return fmt.Sprintf("class stmt, name: %s, superclass:%s functions: %s", stmt.name, stmt.superclass, stmt.methods)
+	return fmt.Sprintf("class stmt, name: %s, superclass:%s traits: %s functions: %s static functions: %s static fields: %s getters: %s setters: %s private functions: %s private fields: %s", stmt.name, stmt.superclass, stmt.traits, stmt.methods, stmt.staticMethods, stmt.staticFields, stmt.getters, stmt.setters, stmt.privateMethods, stmt.privateFields)
+}
+
+type MatchStmt struct {
+	keyword token
+	subject Expr
+	cases   []MatchCase
+}
+
+// MatchCase 中的多个 patterns 是「或」的关系，guard 为 nil 表示没有 `if` 条件。
+type MatchCase struct {
+	patterns []Pattern
+	guard    Expr
+	body     Stmt
+}
+
+func newMatchStmt(keyword token, subject Expr, cases []MatchCase) Stmt {
+	return MatchStmt{
+		keyword: keyword,
+		subject: subject,
+		cases:   cases,
+	}
+}
+
+func (stmt MatchStmt) acceptStmtVisitor(visitor StmtVisitor) error {
+	return visitor.visitMatchStmt(stmt)
+}
+
+func (stmt MatchStmt) String() string {
+	return fmt.Sprintf("match stmt, subject: %s, cases: %v", stmt.subject, stmt.cases)
+}
+
+// TraitStmt 声明一组可以被多个 class 复用的 method，通过 `class C < B with T {}` 混入。
+type TraitStmt struct {
+	name    token
+	methods []FunctionStmt
+}
+
+func newTraitStmt(name token, methods []FunctionStmt) Stmt {
+	return TraitStmt{
+		name:    name,
+		methods: methods,
+	}
+}
+
+func (stmt TraitStmt) acceptStmtVisitor(visitor StmtVisitor) error {
+	return visitor.visitTraitStmt(stmt)
+}
+
+func (stmt TraitStmt) String() string {
+	return fmt.Sprintf("trait stmt, name: %s, functions: %s", stmt.name, stmt.methods)
+}
+
+// InterfaceStmt 声明一组 method 签名，class 通过 `implements` 声明实现了它。
+// class 声明执行的时候会检查 method 的名字和参数个数是否满足 interface。
+type InterfaceStmt struct {
+	name    token
+	methods []FunctionStmt
+}
+
+func newInterfaceStmt(name token, methods []FunctionStmt) Stmt {
+	return InterfaceStmt{
+		name:    name,
+		methods: methods,
+	}
+}
+
+func (stmt InterfaceStmt) acceptStmtVisitor(visitor StmtVisitor) error {
+	return visitor.visitInterfaceStmt(stmt)
+}
+
+func (stmt InterfaceStmt) String() string {
+	return fmt.Sprintf("interface stmt, name: %s, functions: %s", stmt.name, stmt.methods)
+}
+
+// SelectStmt 等待多个 channel 操作中的一个完成，然后执行对应的 case。
+// 有 default case 的时候不会阻塞。
+type SelectStmt struct {
+	keyword token
+	cases   []SelectCase
+}
+
+// SelectCase 是 `case v = ch.receive() => body`、`case ch.send(x) => body` 或者 `case _ => body`。
+// default case 的 channel 为 nil，name 是接收到的值绑定的名字，只在 body 中可见。
+type SelectCase struct {
+	keyword token
+	name    *token
+	channel Expr
+	send    bool
+	value   Expr
+	body    Stmt
+}
+
+func newSelectStmt(keyword token, cases []SelectCase) Stmt {
+	return SelectStmt{
+		keyword: keyword,
+		cases:   cases,
+	}
+}
+
+func (stmt SelectStmt) acceptStmtVisitor(visitor StmtVisitor) error {
+	return visitor.visitSelectStmt(stmt)
+}
+
+func (stmt SelectStmt) String() string {
+	return fmt.Sprintf("select stmt, cases: %d", len(stmt.cases))
+}
+
+// TryStmt 执行 body，body 中的 RuntimeError 交给 handler 处理，name 在 handler 中绑定到这个错误。
+// 其它的错误，比如超过执行预算、exit()，不能被 catch。
+type TryStmt struct {
+	keyword token
+	body    Stmt
+	name    token
+	handler Stmt
+}
+
+func newTryStmt(keyword token, body Stmt, name token, handler Stmt) Stmt {
+	return TryStmt{
+		keyword: keyword,
+		body:    body,
+		name:    name,
+		handler: handler,
+	}
+}
+
+func (stmt TryStmt) acceptStmtVisitor(visitor StmtVisitor) error {
+	return visitor.visitTryStmt(stmt)
+}
+
+func (stmt TryStmt) String() string {
+	return fmt.Sprintf("try stmt, body: %s, catch %s: %s", stmt.body, stmt.name.Lexeme, stmt.handler)
 }